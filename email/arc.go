@@ -0,0 +1,396 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package email
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// arcMaxInstances is the most ARC sets a message may have, as defined by RFC 8617.
+	arcMaxInstances = 50
+
+	arcSealField             = "ARC-Seal"
+	arcMessageSignatureField = "ARC-Message-Signature"
+	arcAuthResultsField      = "ARC-Authentication-Results"
+)
+
+// ARCSet is a single instance of an Authenticated Received Chain (ARC), added by one
+// intermediary (such as a forwarder or mailing list) that handled the message.
+type ARCSet struct {
+	// Instance is the position of this set in the chain (i=), starting at 1.
+	Instance int
+
+	// Domain is the sealing domain (d=) of the ARC-Seal.
+	Domain string
+
+	// Selector is the name of the key (s=) within the Domain.
+	Selector string
+
+	// ChainValidation is the result (cv=) of the chain as validated by this intermediary:
+	// none for the first set, and otherwise pass or fail.
+	ChainValidation AuthResult
+
+	// AuthenticationResults is the ARC-Authentication-Results field value, without its instance,
+	// such as "example.com; dkim=pass header.d=example.org".
+	AuthenticationResults string
+
+	seal             []byte
+	messageSignature []byte
+	authResults      []byte
+}
+
+// ARCValidation is the result of validating the Authenticated Received Chain of a message.
+type ARCValidation struct {
+	// Result is none if the message has no ARC sets, pass if the chain is intact,
+	// temperror if a key could not be looked up, and otherwise fail.
+	Result AuthResult
+
+	// Reason describes why the chain did not pass.
+	Reason string
+
+	// Sets are the ARC sets of the message, in order of their instance.
+	Sets []*ARCSet
+}
+
+// ARCVerifier validates the Authenticated Received Chain of messages, as defined by RFC 8617.
+type ARCVerifier struct {
+	// Resolver looks up public keys. If nil, DNSResolver is used.
+	Resolver TXTResolver
+}
+
+// Verify validates the ARC chain of a message: the structure of its sets, the most recent
+// ARC-Message-Signature, and every ARC-Seal.
+// The message must be the original raw bytes, as received, because ParseMessage
+// does not preserve the exact header and body bytes that were signed.
+func (v *ARCVerifier) Verify(message []byte) *ARCValidation {
+	fields, body := splitRawMessage(message)
+	return v.verify(fields, body)
+}
+
+// verify ...
+func (v *ARCVerifier) verify(fields [][]byte, body []byte) *ARCValidation {
+	sets, err := collectARCSets(fields)
+	validation := &ARCValidation{Result: AuthResultNone, Sets: sets}
+	if err != nil {
+		validation.Result, validation.Reason = AuthResultFail, err.Error()
+		return validation
+	}
+	if len(sets) == 0 {
+		return validation
+	}
+
+	latest := sets[len(sets)-1]
+	if latest.ChainValidation == AuthResultFail {
+		validation.Result, validation.Reason = AuthResultFail, "chain was already failed by instance "+strconv.Itoa(latest.Instance)
+		return validation
+	}
+	for _, set := range sets {
+		if set.Instance == 1 && set.ChainValidation != AuthResultNone || set.Instance > 1 && set.ChainValidation != AuthResultPass {
+			validation.Result, validation.Reason = AuthResultFail, "invalid chain validation for instance "+strconv.Itoa(set.Instance)
+			return validation
+		}
+	}
+
+	// Only the most recent message signature must still be valid
+	if result, reason := v.verifyMessageSignature(fields, latest, body); result != AuthResultPass {
+		validation.Result, validation.Reason = result, "ARC-Message-Signature instance "+strconv.Itoa(latest.Instance)+": "+reason
+		if result != AuthResultTempError {
+			validation.Result = AuthResultFail
+		}
+		return validation
+	}
+	for idx := len(sets) - 1; idx >= 0; idx-- {
+		if result, reason := v.verifySeal(sets[:idx+1]); result != AuthResultPass {
+			validation.Result, validation.Reason = result, "ARC-Seal instance "+strconv.Itoa(sets[idx].Instance)+": "+reason
+			if result != AuthResultTempError {
+				validation.Result = AuthResultFail
+			}
+			return validation
+		}
+	}
+	validation.Result = AuthResultPass
+	return validation
+}
+
+// verifyMessageSignature verifies an ARC-Message-Signature, which is a DKIM signature,
+// except that its i= tag is the instance, and it has no v= tag.
+func (v *ARCVerifier) verifyMessageSignature(fields [][]byte, set *ARCSet, body []byte) (AuthResult, string) {
+	tags, err := parseDKIMTags(rawHeaderValue(set.messageSignature))
+	if err != nil {
+		return AuthResultPermError, err.Error()
+	}
+	for _, required := range []string{"i", "a", "b", "bh", "d", "h", "s"} {
+		if _, ok := tags[required]; !ok {
+			return AuthResultPermError, "missing required tag " + required + "="
+		}
+	}
+	headers := strings.Split(removeWhitespace(tags["h"]), ":")
+	if containsFold(headers, arcSealField) {
+		return AuthResultPermError, "ARC-Seal must not be signed"
+	}
+	headerCanon, bodyCanon, err := parseDKIMCanonicalization(tags["c"])
+	if err != nil {
+		return AuthResultPermError, err.Error()
+	}
+
+	key, keyResult, reason := lookupDKIMKey(v.Resolver, tags["s"], tags["d"], tags["a"])
+	if key == nil {
+		return keyResult, reason
+	}
+	bodyHash := sha256.Sum256(canonicalizeDKIMBody(body, bodyCanon))
+	if base64.StdEncoding.EncodeToString(bodyHash[:]) != removeWhitespace(tags["bh"]) {
+		return AuthResultFail, "body hash did not verify"
+	}
+	signedData := canonicalizeDKIMHeaders(fields, headers, headerCanon)
+	signedData = append(signedData, bytes.TrimSuffix(canonicalizeDKIMHeader(removeDKIMSignatureValue(set.messageSignature), headerCanon), []byte("\r\n"))...)
+	if err = verifyDKIMSignature(key, signedData, tags["b"]); err != nil {
+		return AuthResultFail, err.Error()
+	}
+	return AuthResultPass, ""
+}
+
+// verifySeal verifies the ARC-Seal of the last set, which signs every set up to and including its own.
+func (v *ARCVerifier) verifySeal(sets []*ARCSet) (AuthResult, string) {
+	set := sets[len(sets)-1]
+	tags, err := parseDKIMTags(rawHeaderValue(set.seal))
+	if err != nil {
+		return AuthResultPermError, err.Error()
+	}
+	for _, required := range []string{"i", "a", "b", "cv", "d", "s"} {
+		if _, ok := tags[required]; !ok {
+			return AuthResultPermError, "missing required tag " + required + "="
+		}
+	}
+	if _, ok := tags["h"]; ok {
+		return AuthResultPermError, "ARC-Seal must not have an h= tag"
+	}
+	key, keyResult, reason := lookupDKIMKey(v.Resolver, tags["s"], tags["d"], tags["a"])
+	if key == nil {
+		return keyResult, reason
+	}
+	signedData := arcSealData(sets[:len(sets)-1], set.authResults, set.messageSignature)
+	signedData = append(signedData, bytes.TrimSuffix(canonicalizeDKIMHeader(removeDKIMSignatureValue(set.seal), DKIMRelaxed), []byte("\r\n"))...)
+	if err = verifyDKIMSignature(key, signedData, tags["b"]); err != nil {
+		return AuthResultFail, err.Error()
+	}
+	return AuthResultPass, ""
+}
+
+// arcSealData returns the data signed by an ARC-Seal (except for the seal itself):
+// each previous set, followed by the new ARC-Authentication-Results and ARC-Message-Signature,
+// always with relaxed canonicalization.
+func arcSealData(previous []*ARCSet, authResults []byte, messageSignature []byte) []byte {
+	var data []byte
+	for _, set := range previous {
+		data = append(data, canonicalizeDKIMHeader(set.authResults, DKIMRelaxed)...)
+		data = append(data, canonicalizeDKIMHeader(set.messageSignature, DKIMRelaxed)...)
+		data = append(data, canonicalizeDKIMHeader(set.seal, DKIMRelaxed)...)
+	}
+	data = append(data, canonicalizeDKIMHeader(authResults, DKIMRelaxed)...)
+	return append(data, canonicalizeDKIMHeader(messageSignature, DKIMRelaxed)...)
+}
+
+// collectARCSets finds the ARC sets of a message, returning an error
+// unless each instance from 1 to the highest has exactly one of each ARC header field.
+func collectARCSets(fields [][]byte) ([]*ARCSet, error) {
+	byInstance := map[int]*ARCSet{}
+	for _, field := range fields {
+		name := rawHeaderName(field)
+		if !strings.EqualFold(name, arcSealField) && !strings.EqualFold(name, arcMessageSignatureField) &&
+			!strings.EqualFold(name, arcAuthResultsField) {
+			// Other ARC- fields, such as ARC-Filter, are not part of a set
+			continue
+		}
+		value := rawHeaderValue(field)
+		instanceTag := value
+		if strings.EqualFold(name, arcAuthResultsField) {
+			// The instance is always first, followed by the authentication results
+			if idx := strings.IndexByte(value, ';'); idx >= 0 {
+				instanceTag = value[:idx]
+			}
+		}
+		tags, _ := parseDKIMTags(instanceTag)
+		instance, err := strconv.Atoi(tags["i"])
+		if err != nil || instance < 1 || instance > arcMaxInstances {
+			return nil, errors.New("invalid instance in " + name)
+		}
+		set, ok := byInstance[instance]
+		if !ok {
+			set = &ARCSet{Instance: instance}
+			byInstance[instance] = set
+		}
+
+		var duplicate bool
+		switch {
+		case strings.EqualFold(name, arcSealField):
+			duplicate = set.seal != nil
+			set.seal = field
+			set.Domain, set.Selector, set.ChainValidation = tags["d"], tags["s"], AuthResult(tags["cv"])
+		case strings.EqualFold(name, arcMessageSignatureField):
+			duplicate = set.messageSignature != nil
+			set.messageSignature = field
+		case strings.EqualFold(name, arcAuthResultsField):
+			duplicate = set.authResults != nil
+			set.authResults = field
+			set.AuthenticationResults = strings.TrimSpace(collapseWhitespace(strings.TrimPrefix(value, instanceTag+";")))
+		default:
+			continue
+		}
+		if duplicate {
+			return nil, errors.New("duplicate " + name + " for instance " + strconv.Itoa(instance))
+		}
+	}
+
+	sets := make([]*ARCSet, 0, len(byInstance))
+	for instance := 1; instance <= len(byInstance); instance++ {
+		set, ok := byInstance[instance]
+		if !ok || set.seal == nil || set.messageSignature == nil || set.authResults == nil {
+			return nil, errors.New("incomplete ARC set for instance " + strconv.Itoa(instance))
+		}
+		sets = append(sets, set)
+	}
+	return sets, nil
+}
+
+// ARCSealer adds a new ARC set to messages being forwarded, as defined by RFC 8617,
+// recording the authentication results seen by this intermediary, so that later receivers
+// may trust them even though forwarding broke the original SPF or DKIM authentication.
+// Its public key is published in DNS the same way as for DKIM.
+type ARCSealer struct {
+	// Domain is the sealing domain (d=), such as "lists.example.com".
+	Domain string
+
+	// Selector is the name of the key (s=) within the Domain.
+	Selector string
+
+	// Key is the private key, either an *rsa.PrivateKey or an ed25519.PrivateKey.
+	Key crypto.Signer
+
+	// AuthServID identifies this intermediary's authentication service, such as "lists.example.com".
+	AuthServID string
+
+	// AuthenticationResults are the results of authenticating the message as received,
+	// such as "dkim=pass header.d=example.org; spf=pass smtp.mailfrom=example.org".
+	// If empty, "none" is recorded.
+	AuthenticationResults string
+
+	// Headers are the header fields signed by the ARC-Message-Signature, if present.
+	// Defaults to DefaultDKIMHeaders and DKIM-Signature.
+	Headers []string
+
+	// ChainValidation is the result of validating the existing chain, if it was validated
+	// before the message was modified, such as by a mailing list adding a footer.
+	// If empty, the chain of the message being sealed is validated.
+	ChainValidation AuthResult
+
+	// Resolver looks up public keys to validate the existing chain. If nil, DNSResolver is used.
+	Resolver TXTResolver
+
+	// Now returns the current time, for the timestamps (t=). If nil, time.Now is used.
+	Now func() time.Time
+}
+
+// Sign validates the message's existing ARC chain (unless ChainValidation is set), and returns the message
+// with a new ARC set prepended. An error is returned if the chain has already failed,
+// or could not be validated due to a temporary error.
+func (s *ARCSealer) Sign(message []byte) ([]byte, error) {
+	if len(s.Domain) == 0 || len(s.Selector) == 0 || len(s.AuthServID) == 0 {
+		return nil, errors.New("ARC sealing requires a Domain, Selector and AuthServID")
+	}
+	algorithm, err := dkimAlgorithm(s.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	fields, body := splitRawMessage(message)
+	var validation *ARCValidation
+	if len(s.ChainValidation) == 0 {
+		validation = (&ARCVerifier{Resolver: s.Resolver}).verify(fields, body)
+	} else {
+		sets, err := collectARCSets(fields)
+		validation = &ARCValidation{Result: s.ChainValidation, Sets: sets}
+		if err != nil {
+			validation.Result, validation.Reason = AuthResultFail, err.Error()
+		}
+	}
+	if len(validation.Sets) > 0 && validation.Sets[len(validation.Sets)-1].ChainValidation == AuthResultFail {
+		return nil, errors.New("ARC chain has already failed, and must not be sealed again")
+	}
+	if validation.Result == AuthResultTempError {
+		return nil, errors.New("ARC chain could not be validated: " + validation.Reason)
+	}
+	if len(validation.Sets) >= arcMaxInstances {
+		return nil, errors.New("ARC chain has too many instances")
+	}
+	if validation.Result == AuthResultFail && len(validation.Sets) == 0 {
+		// The chain is too broken to seal, such as with missing fields
+		return nil, errors.New("ARC chain is invalid: " + validation.Reason)
+	}
+	instance := "i=" + strconv.Itoa(len(validation.Sets)+1)
+
+	now := time.Now
+	if s.Now != nil {
+		now = s.Now
+	}
+	timestamp := "t=" + strconv.FormatInt(now().Unix(), 10)
+
+	results := s.AuthenticationResults
+	if len(results) == 0 {
+		results = "none"
+	}
+	authResults := foldDKIMTags(arcAuthResultsField+": ", []string{instance, s.AuthServID, results}) + "\r\n"
+
+	headers := s.Headers
+	if len(headers) == 0 {
+		headers = append(append([]string(nil), DefaultDKIMHeaders...), "DKIM-Signature")
+	}
+	signedNames := (&DKIMSigner{Headers: headers}).signedHeaderNames(fields)
+	for idx := len(signedNames) - 1; idx >= 0; idx-- {
+		if strings.EqualFold(signedNames[idx], arcSealField) {
+			signedNames = append(signedNames[:idx], signedNames[idx+1:]...)
+		}
+	}
+	bodyHash := sha256.Sum256(canonicalizeDKIMBody(body, DKIMRelaxed))
+	messageSignature, err := signDKIMField(s.Key, arcMessageSignatureField, []string{
+		instance,
+		"a=" + algorithm,
+		"c=relaxed/relaxed",
+		"d=" + s.Domain,
+		"s=" + s.Selector,
+		timestamp,
+		"h=" + strings.Join(signedNames, ":"),
+		"bh=" + base64.StdEncoding.EncodeToString(bodyHash[:]),
+	}, canonicalizeDKIMHeaders(fields, signedNames, DKIMRelaxed), DKIMRelaxed)
+	if err != nil {
+		return nil, err
+	}
+
+	chainValidation := AuthResultPass
+	if len(validation.Sets) == 0 {
+		chainValidation = AuthResultNone
+	} else if validation.Result != AuthResultPass {
+		chainValidation = AuthResultFail
+	}
+	seal, err := signDKIMField(s.Key, arcSealField, []string{
+		instance,
+		"a=" + algorithm,
+		"cv=" + string(chainValidation),
+		"d=" + s.Domain,
+		"s=" + s.Selector,
+		timestamp,
+	}, arcSealData(validation.Sets, []byte(authResults), []byte(messageSignature)), DKIMRelaxed)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(seal+messageSignature+authResults), message...), nil
+}
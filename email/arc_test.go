@@ -0,0 +1,95 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package email
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestARCSealAndVerify ...
+func TestARCSealAndVerify(t *testing.T) {
+	t.Parallel()
+
+	seed, _ := base64.StdEncoding.DecodeString(dkimTestSeed)
+	key := ed25519.NewKeyFromSeed(seed)
+	resolver := stubResolver{
+		"brisbane._domainkey.football.example.com": {"v=DKIM1; k=ed25519; p=11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo="},
+		"brisbane._domainkey.lists.example.net":    {"v=DKIM1; k=ed25519; p=11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo="},
+	}
+	now := func() time.Time { return time.Unix(1528637909, 0) }
+	verifier := &ARCVerifier{Resolver: resolver}
+
+	seal := func(domain string, chainValidation AuthResult, message string) string {
+		sealer := &ARCSealer{Domain: domain, Selector: "brisbane", Key: key, AuthServID: domain,
+			AuthenticationResults: "dkim=pass header.d=football.example.com", ChainValidation: chainValidation,
+			Resolver: resolver, Now: now}
+		sealed, err := sealer.Sign([]byte(message))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(sealed)
+	}
+
+	if validation := verifier.Verify([]byte(dkimTestMessage)); validation.Result != AuthResultNone {
+		t.Errorf("Expected none without ARC sets; got %+v", validation)
+	}
+
+	first := seal("football.example.com", "", dkimTestMessage)
+	if !strings.HasPrefix(first, "ARC-Seal: i=1; a=ed25519-sha256; cv=none; d=football.example.com;") ||
+		!strings.Contains(first, "ARC-Authentication-Results: i=1; football.example.com;\r\n\tdkim=pass header.d=football.example.com\r\n") {
+		t.Errorf("Unexpected first ARC set:\n%s", first)
+	}
+
+	// A mailing list validates the chain, then adds a footer, breaking the first message signature, but not the chain
+	received := verifier.Verify([]byte(first))
+	forwarded := strings.Replace(first, "Joe.\r\n", "Joe.\r\n--\r\nThe list\r\n", 1)
+	second := seal("lists.example.net", received.Result, forwarded)
+	if !strings.HasPrefix(second, "ARC-Seal: i=2; a=ed25519-sha256; cv=pass; d=lists.example.net;") {
+		t.Errorf("Unexpected second ARC set:\n%s", second)
+	}
+	validation := verifier.Verify([]byte(second))
+	if validation.Result != AuthResultPass || len(validation.Sets) != 2 {
+		t.Fatalf("Expected the chain to pass; got %+v", validation)
+	}
+	if set := validation.Sets[0]; set.Instance != 1 || set.Domain != "football.example.com" || set.Selector != "brisbane" ||
+		set.ChainValidation != AuthResultNone || set.AuthenticationResults != "football.example.com; dkim=pass header.d=football.example.com" {
+		t.Errorf("Unexpected first set: %+v", set)
+	}
+
+	testCases := []struct {
+		message string
+		result  AuthResult
+		reason  string
+	}{
+		{first, AuthResultPass, ""},
+		{"ARC-Filter: spamfilter; engine=2.1\r\n" + second, AuthResultPass, ""},
+		{forwarded, AuthResultFail, "ARC-Message-Signature instance 1: body hash did not verify"},
+		{strings.Replace(second, "dinner", "lunch", 1), AuthResultFail, "ARC-Message-Signature instance 2: signature did not verify"},
+		{strings.Replace(second, "i=1; football.example.com", "i=1; evil.example.com", 1), AuthResultFail, "ARC-Seal instance 2: signature did not verify"},
+		{strings.Replace(second, "ARC-Seal: i=1;", "ARC-Seal: i=3;", 1), AuthResultFail, "incomplete ARC set for instance 1"},
+		{strings.Replace(second, "cv=pass", "cv=fail", 1), AuthResultFail, "chain was already failed by instance 2"},
+		{strings.Replace(first, "d=football.example.com", "d=unknown.example.com", 1), AuthResultFail, "ARC-Seal instance 1: no key for signature"},
+	}
+	for idx, tc := range testCases {
+		validation := verifier.Verify([]byte(tc.message))
+		if validation.Result != tc.result || validation.Reason != tc.reason {
+			t.Errorf("Case %d: expected %s %q; got %s %q", idx, tc.result, tc.reason, validation.Result, validation.Reason)
+		}
+	}
+
+	// Sealing a broken chain records the failure, after which the chain must not be sealed again
+	broken := seal("lists.example.net", "", strings.Replace(first, "dinner", "lunch", 1))
+	if !strings.HasPrefix(broken, "ARC-Seal: i=2; a=ed25519-sha256; cv=fail;") {
+		t.Errorf("Expected cv=fail; got:\n%s", broken)
+	}
+	sealer := &ARCSealer{Domain: "lists.example.net", Selector: "brisbane", Key: key, AuthServID: "lists.example.net", Resolver: resolver}
+	if _, err := sealer.Sign([]byte(broken)); err == nil {
+		t.Error("Expected an error sealing a failed chain")
+	}
+}
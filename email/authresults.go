@@ -0,0 +1,437 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package email
+
+import (
+	"errors"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// authResultsField is the name of the Authentication-Results header field.
+const authResultsField = "Authentication-Results"
+
+// AuthenticationResults is an Authentication-Results header field, as defined by RFC 8601,
+// recording the results of authenticating a message (such as with SPF, DKIM, DMARC, or ARC)
+// by the server that received it.
+// ARC-Authentication-Results field values, without their instance, use the same format.
+type AuthenticationResults struct {
+	// AuthServID identifies the authentication service that added the field,
+	// usually the hostname of the receiving server.
+	AuthServID string
+
+	// Version is the version of the format, if given, which is currently always 1.
+	Version int
+
+	// Results are the results of each authentication method.
+	// If there are none, the field records that no authentication was performed.
+	Results []*AuthenticationResult
+}
+
+// AuthenticationResult is the result of a single authentication method within
+// an Authentication-Results header field, such as
+// "dkim=pass (good signature) header.d=example.com header.s=mail".
+type AuthenticationResult struct {
+	// Method is the lower case authentication method, such as "spf", "dkim", "dmarc", or "arc".
+	Method string
+
+	// MethodVersion is the version of the method, if given.
+	MethodVersion string
+
+	// Result is the result of the method, such as pass or fail.
+	Result AuthResult
+
+	// Comment is the comment following the result, if any, without its parentheses.
+	Comment string
+
+	// Reason is the human readable reason for the result (reason=), if any.
+	Reason string
+
+	// Properties are the properties of the message that were authenticated, in order,
+	// such as "smtp.mailfrom" or "header.d".
+	Properties []*AuthenticationProperty
+}
+
+// AuthenticationProperty is a property of the message that an authentication method used,
+// such as "header.d=example.com".
+type AuthenticationProperty struct {
+	// Type is the lower case type of the property: "smtp", "header", "body", or "policy".
+	Type string
+
+	// Property is the lower case name of the property, such as "mailfrom" or "d".
+	Property string
+
+	// Value is the unquoted value of the property, such as "example.com".
+	Value string
+
+	// Comment is the comment following the value, if any, without its parentheses.
+	Comment string
+}
+
+// AuthenticationResults parses every Authentication-Results header field, in order from
+// the top of the header, so that the most recently added field is first.
+// Fields that can not be parsed are left out, and the first such error is returned.
+// Only fields with the AuthServID of a trusted server (usually the receiving server)
+// should be relied on, because senders may add their own forged fields.
+func (h Header) AuthenticationResults() ([]*AuthenticationResults, error) {
+	var results []*AuthenticationResults
+	var firstErr error
+	for _, value := range h[authResultsField] {
+		parsed, err := ParseAuthenticationResults(value)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		results = append(results, parsed)
+	}
+	return results, firstErr
+}
+
+// AddAuthenticationResults adds an Authentication-Results header field above any existing ones,
+// as a receiving server does.
+func (h Header) AddAuthenticationResults(results *AuthenticationResults) {
+	key := textproto.CanonicalMIMEHeaderKey(authResultsField)
+	h[key] = append([]string{results.String()}, h[key]...)
+}
+
+// DelAuthenticationResults deletes the Authentication-Results header fields with this AuthServID
+// (case insensitive), which RFC 8601 requires of a receiving server before adding its own,
+// so that fields forged by the sender are not trusted.
+func (h Header) DelAuthenticationResults(authServID string) {
+	key := textproto.CanonicalMIMEHeaderKey(authResultsField)
+	var kept []string
+	for _, value := range h[key] {
+		if parsed, err := ParseAuthenticationResults(value); err == nil && strings.EqualFold(parsed.AuthServID, authServID) {
+			continue
+		}
+		kept = append(kept, value)
+	}
+	if len(kept) == 0 {
+		delete(h, key)
+		return
+	}
+	h[key] = kept
+}
+
+// ParseAuthenticationResults parses the value of an Authentication-Results header field,
+// such as "example.com; spf=pass smtp.mailfrom=example.org; dkim=pass header.d=example.org".
+// Comments are allowed anywhere whitespace is, and quoted values are unquoted.
+// Malformed method results are skipped, but an error is returned if the field
+// does not start with an authserv-id.
+func ParseAuthenticationResults(value string) (*AuthenticationResults, error) {
+	s := &authResultsScanner{s: value}
+	s.skipCFWS()
+	results := &AuthenticationResults{AuthServID: s.value(";")}
+	if len(results.AuthServID) == 0 {
+		return nil, errors.New("Authentication-Results is missing the authserv-id: " + value)
+	}
+	s.skipCFWS()
+	if version := s.token(";"); len(version) > 0 {
+		if !isDigits(version) {
+			return nil, errors.New("Invalid Authentication-Results version: " + value)
+		}
+		results.Version, _ = strconv.Atoi(version)
+	}
+
+	for {
+		s.skipCFWS()
+		if !s.consume(';') {
+			break
+		}
+		s.skipCFWS()
+		if s.atEnd() {
+			break
+		}
+		result, ok := s.result()
+		if !ok {
+			s.skipTo(';')
+			continue
+		}
+		if result != nil {
+			results.Results = append(results.Results, result)
+		}
+	}
+	if !s.atEnd() {
+		return nil, errors.New("Invalid Authentication-Results: " + value)
+	}
+	return results, nil
+}
+
+// Method returns the results of this authentication method (case insensitive), in order.
+func (r *AuthenticationResults) Method(method string) []*AuthenticationResult {
+	var found []*AuthenticationResult
+	for _, result := range r.Results {
+		if strings.EqualFold(result.Method, method) {
+			found = append(found, result)
+		}
+	}
+	return found
+}
+
+// String returns the Authentication-Results header field value, without folding.
+func (r *AuthenticationResults) String() string {
+	b := &strings.Builder{}
+	b.WriteString(authResultsValue(r.AuthServID))
+	if r.Version > 0 {
+		b.WriteString(" " + strconv.Itoa(r.Version))
+	}
+	if len(r.Results) == 0 {
+		b.WriteString("; none")
+	}
+	for _, result := range r.Results {
+		b.WriteString("; " + result.String())
+	}
+	return b.String()
+}
+
+// Property returns the value of a property, such as "header.d" (case insensitive),
+// or an empty string if it is not present.
+func (r *AuthenticationResult) Property(name string) string {
+	for _, property := range r.Properties {
+		if strings.EqualFold(property.Type+"."+property.Property, name) {
+			return property.Value
+		}
+	}
+	return ""
+}
+
+// AddProperty adds a property with this type and name, such as ("header", "d", "example.com"),
+// unless the value is empty.
+func (r *AuthenticationResult) AddProperty(propertyType string, property string, value string) {
+	if len(value) > 0 {
+		r.Properties = append(r.Properties, &AuthenticationProperty{Type: propertyType, Property: property, Value: value})
+	}
+}
+
+// String returns the method result, such as "dkim=pass header.d=example.com".
+func (r *AuthenticationResult) String() string {
+	b := &strings.Builder{}
+	b.WriteString(r.Method)
+	if len(r.MethodVersion) > 0 {
+		b.WriteString("/" + r.MethodVersion)
+	}
+	b.WriteString("=" + string(r.Result))
+	writeAuthResultsComment(b, r.Comment)
+	if len(r.Reason) > 0 {
+		b.WriteString(" reason=" + quoteAuthResultsValue(r.Reason))
+	}
+	for _, property := range r.Properties {
+		b.WriteString(" " + property.Type + "." + property.Property + "=" + authResultsValue(property.Value))
+		writeAuthResultsComment(b, property.Comment)
+	}
+	return b.String()
+}
+
+// AuthenticationResult returns this verification as a method result for an
+// Authentication-Results header field, such as
+// "dkim=pass header.d=example.com header.i=@example.com header.s=mail header.a=rsa-sha256".
+func (v *DKIMVerification) AuthenticationResult() *AuthenticationResult {
+	result := &AuthenticationResult{Method: "dkim", Result: v.Result, Reason: v.Reason}
+	result.AddProperty("header", "d", v.Domain)
+	result.AddProperty("header", "i", v.Identity)
+	result.AddProperty("header", "s", v.Selector)
+	result.AddProperty("header", "a", v.Algorithm)
+	return result
+}
+
+// AuthenticationResult returns this validation as a method result for an
+// Authentication-Results header field, such as "arc=pass".
+func (v *ARCValidation) AuthenticationResult() *AuthenticationResult {
+	return &AuthenticationResult{Method: "arc", Result: v.Result, Reason: v.Reason}
+}
+
+// authResultsValue returns the value as is if it is a token, or an address or domain,
+// and otherwise as a quoted-string.
+func authResultsValue(value string) string {
+	if len(value) == 0 {
+		return `""`
+	}
+	for idx := 0; idx < len(value); idx++ {
+		if c := value[idx]; c <= ' ' || c >= 0x7f || strings.IndexByte(`()<>,;:\"/[]?=`, c) >= 0 {
+			return quoteAuthResultsValue(value)
+		}
+	}
+	return value
+}
+
+// quoteAuthResultsValue returns the value as a quoted-string.
+func quoteAuthResultsValue(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
+
+// writeAuthResultsComment writes a comment, with its parentheses escaped, if it is not empty.
+func writeAuthResultsComment(b *strings.Builder, comment string) {
+	if len(comment) > 0 {
+		b.WriteString(" (" + strings.NewReplacer(`\`, `\\`, `(`, `\(`, `)`, `\)`).Replace(comment) + ")")
+	}
+}
+
+// authResultsScanner reads the parts of an Authentication-Results header field value.
+type authResultsScanner struct {
+	s   string
+	pos int
+}
+
+// result reads a method result, returning nil if it is "none",
+// or false if it is malformed.
+func (s *authResultsScanner) result() (*AuthenticationResult, bool) {
+	result := &AuthenticationResult{Method: strings.ToLower(s.token("=/;"))}
+	if len(result.Method) == 0 {
+		return nil, false
+	}
+	s.skipCFWS()
+	if s.consume('/') {
+		s.skipCFWS()
+		result.MethodVersion = s.token("=;")
+		s.skipCFWS()
+	}
+	if result.Method == "none" && (s.atEnd() || s.peek() == ';') {
+		return nil, true
+	}
+	if !s.consume('=') {
+		return nil, false
+	}
+	s.skipCFWS()
+	result.Result = AuthResult(strings.ToLower(s.token(";")))
+	if len(result.Result) == 0 {
+		return nil, false
+	}
+	result.Comment = s.skipCFWS()
+
+	for !s.atEnd() && s.peek() != ';' {
+		// A reason, or a property such as "header.d", which may have comments around its dot
+		name := strings.ToLower(s.token(".=;"))
+		s.skipCFWS()
+		property := &AuthenticationProperty{Type: name}
+		if s.consume('.') {
+			s.skipCFWS()
+			property.Property = strings.ToLower(s.token("=;"))
+			s.skipCFWS()
+		}
+		if len(name) == 0 || !s.consume('=') {
+			return nil, false
+		}
+		s.skipCFWS()
+		property.Value = s.value(";")
+		property.Comment = s.skipCFWS()
+
+		if name == "reason" && len(property.Property) == 0 {
+			result.Reason = property.Value
+		} else if len(property.Property) > 0 {
+			result.Properties = append(result.Properties, property)
+		} else {
+			return nil, false
+		}
+	}
+	return result, true
+}
+
+// skipCFWS skips whitespace and comments, returning the text of any comments.
+func (s *authResultsScanner) skipCFWS() string {
+	var comments []string
+	for !s.atEnd() {
+		switch s.peek() {
+		case ' ', '\t', '\r', '\n':
+			s.pos++
+		case '(':
+			comments = append(comments, s.comment())
+		default:
+			return strings.Join(comments, " ")
+		}
+	}
+	return strings.Join(comments, " ")
+}
+
+// comment reads a comment, which may be nested, returning its text without the outer parentheses.
+func (s *authResultsScanner) comment() string {
+	b := &strings.Builder{}
+	depth := 0
+	for ; !s.atEnd(); s.pos++ {
+		c := s.peek()
+		switch {
+		case c == '\\' && s.pos+1 < len(s.s):
+			s.pos++
+			b.WriteByte(s.s[s.pos])
+			continue
+		case c == '(':
+			depth++
+			if depth == 1 {
+				continue
+			}
+		case c == ')':
+			depth--
+			if depth == 0 {
+				s.pos++
+				return strings.TrimSpace(b.String())
+			}
+		}
+		b.WriteByte(c)
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// token reads until whitespace, a comment, a quote, or one of the stop characters.
+func (s *authResultsScanner) token(stop string) string {
+	start := s.pos
+	for !s.atEnd() && strings.IndexByte(" \t\r\n(\""+stop, s.peek()) < 0 {
+		s.pos++
+	}
+	return s.s[start:s.pos]
+}
+
+// value reads a quoted-string, returning it unquoted, or else a token.
+func (s *authResultsScanner) value(stop string) string {
+	if !s.consume('"') {
+		return s.token(stop)
+	}
+	b := &strings.Builder{}
+	for ; !s.atEnd(); s.pos++ {
+		c := s.peek()
+		if c == '\\' && s.pos+1 < len(s.s) {
+			s.pos++
+			b.WriteByte(s.s[s.pos])
+			continue
+		}
+		if c == '"' {
+			s.pos++
+			break
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// skipTo skips to the next instance of the character, ignoring any in comments or quotes.
+func (s *authResultsScanner) skipTo(c byte) {
+	for s.skipCFWS(); !s.atEnd() && s.peek() != c; s.skipCFWS() {
+		if s.peek() == '"' {
+			s.value("")
+		} else {
+			s.pos++
+		}
+	}
+}
+
+// consume skips the next character if it is c, returning true if it was.
+func (s *authResultsScanner) consume(c byte) bool {
+	if !s.atEnd() && s.s[s.pos] == c {
+		s.pos++
+		return true
+	}
+	return false
+}
+
+// peek ...
+func (s *authResultsScanner) peek() byte {
+	return s.s[s.pos]
+}
+
+// atEnd ...
+func (s *authResultsScanner) atEnd() bool {
+	return s.pos >= len(s.s)
+}
@@ -0,0 +1,112 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package email
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestParseAuthenticationResults ...
+func TestParseAuthenticationResults(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		value    string
+		expected *AuthenticationResults
+		str      string
+	}{
+		{
+			value:    "example.org 1; none",
+			expected: &AuthenticationResults{AuthServID: "example.org", Version: 1},
+			str:      "example.org 1; none",
+		},
+		{
+			value: "example.com;\r\n spf=pass smtp.mailfrom=example.net;\r\n dkim=pass (good signature) header.d=mail-router.example.net;\r\n dkim=fail (bad signature) header.d=newyork.example.com",
+			expected: &AuthenticationResults{AuthServID: "example.com", Results: []*AuthenticationResult{
+				{Method: "spf", Result: AuthResultPass, Properties: []*AuthenticationProperty{{Type: "smtp", Property: "mailfrom", Value: "example.net"}}},
+				{Method: "dkim", Result: AuthResultPass, Comment: "good signature", Properties: []*AuthenticationProperty{{Type: "header", Property: "d", Value: "mail-router.example.net"}}},
+				{Method: "dkim", Result: AuthResultFail, Comment: "bad signature", Properties: []*AuthenticationProperty{{Type: "header", Property: "d", Value: "newyork.example.com"}}},
+			}},
+			str: "example.com; spf=pass smtp.mailfrom=example.net; dkim=pass (good signature) header.d=mail-router.example.net; dkim=fail (bad signature) header.d=newyork.example.com",
+		},
+		{
+			// RFC 8601, Appendix B.7, with comments almost everywhere
+			value: "foo.example.net (foobar) 1 (baz);\r\n dkim (Because I like it) / 1 (One yay) = (wait for it) fail\r\n policy (A dot can go here) . (like that) expired\r\n (this surprised me) = (as I wasn't expecting it) 1362471462",
+			expected: &AuthenticationResults{AuthServID: "foo.example.net", Version: 1, Results: []*AuthenticationResult{
+				{Method: "dkim", MethodVersion: "1", Result: AuthResultFail, Properties: []*AuthenticationProperty{{Type: "policy", Property: "expired", Value: "1362471462"}}},
+			}},
+			str: "foo.example.net 1; dkim/1=fail policy.expired=1362471462",
+		},
+		{
+			value: `mx.example.com; DMARC=Fail reason="From: \"domain\" (not aligned)" header.from=example.org (the (nested) comment); bogus; spf=softfail smtp.mailfrom=user@example.org`,
+			expected: &AuthenticationResults{AuthServID: "mx.example.com", Results: []*AuthenticationResult{
+				{Method: "dmarc", Result: AuthResultFail, Reason: `From: "domain" (not aligned)`, Properties: []*AuthenticationProperty{{Type: "header", Property: "from", Value: "example.org", Comment: "the (nested) comment"}}},
+				{Method: "spf", Result: AuthResultSoftFail, Properties: []*AuthenticationProperty{{Type: "smtp", Property: "mailfrom", Value: "user@example.org"}}},
+			}},
+			str: `mx.example.com; dmarc=fail reason="From: \"domain\" (not aligned)" header.from=example.org (the \(nested\) comment); spf=softfail smtp.mailfrom=user@example.org`,
+		},
+		{
+			value:    `"quoted id"; arc=pass`,
+			expected: &AuthenticationResults{AuthServID: "quoted id", Results: []*AuthenticationResult{{Method: "arc", Result: AuthResultPass}}},
+			str:      `"quoted id"; arc=pass`,
+		},
+	}
+
+	for idx, tc := range testCases {
+		parsed, err := ParseAuthenticationResults(tc.value)
+		if err != nil {
+			t.Errorf("Case %d: unexpected error: %v", idx, err)
+			continue
+		}
+		if !reflect.DeepEqual(parsed, tc.expected) {
+			t.Errorf("Case %d: expected %s; got %s", idx, tc.expected, parsed)
+		}
+		if parsed.String() != tc.str {
+			t.Errorf("Case %d: expected string %q; got %q", idx, tc.str, parsed.String())
+		}
+		if reparsed, err := ParseAuthenticationResults(parsed.String()); err != nil || reparsed.String() != tc.str {
+			t.Errorf("Case %d: did not round trip: %q %v", idx, reparsed, err)
+		}
+	}
+
+	for _, value := range []string{"", "; spf=pass", "example.com version; spf=pass", "(only a comment)"} {
+		if _, err := ParseAuthenticationResults(value); err == nil {
+			t.Errorf("Expected an error parsing %q", value)
+		}
+	}
+}
+
+// TestHeaderAuthenticationResults ...
+func TestHeaderAuthenticationResults(t *testing.T) {
+	t.Parallel()
+
+	h := Header{}
+	h.Add("Authentication-Results", "mx.example.com; spf=pass smtp.mailfrom=forged.example.org")
+	h.Add("Authentication-Results", "relay.example.net; none")
+
+	verification := &DKIMVerification{Result: AuthResultPass, Domain: "example.org", Selector: "mail", Identity: "@example.org", Algorithm: "rsa-sha256"}
+	h.DelAuthenticationResults("MX.example.com")
+	h.AddAuthenticationResults(&AuthenticationResults{AuthServID: "mx.example.com", Results: []*AuthenticationResult{
+		verification.AuthenticationResult(),
+		(&ARCValidation{Result: AuthResultFail, Reason: "ARC-Seal instance 1: signature did not verify"}).AuthenticationResult(),
+	}})
+
+	expected := []string{
+		`mx.example.com; dkim=pass header.d=example.org header.i=@example.org header.s=mail header.a=rsa-sha256; arc=fail reason="ARC-Seal instance 1: signature did not verify"`,
+		"relay.example.net; none",
+	}
+	if !reflect.DeepEqual(h["Authentication-Results"], expected) {
+		t.Errorf("Expected %q; got %q", expected, h["Authentication-Results"])
+	}
+
+	results, err := h.AuthenticationResults()
+	if err != nil || len(results) != 2 {
+		t.Fatalf("Expected 2 results; got %v %v", results, err)
+	}
+	if dkim := results[0].Method("DKIM"); len(dkim) != 1 || dkim[0].Property("Header.D") != "example.org" || dkim[0].Property("smtp.mailfrom") != "" {
+		t.Errorf("Unexpected DKIM results: %v", dkim)
+	}
+}
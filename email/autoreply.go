@@ -0,0 +1,144 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package email
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Automation is the classification of how a message was sent:
+// by a human, or automatically by a program.
+type Automation int
+
+const (
+	// AutomationNone means no signals of automation were found,
+	// so the message was most likely sent by a human.
+	AutomationNone Automation = iota
+
+	// AutomationBulk means the message was sent in bulk, such as by a mailing list.
+	AutomationBulk
+
+	// AutomationAutoGenerated means the message was generated automatically,
+	// such as a notification or a bounce, without being a reply to another message.
+	AutomationAutoGenerated
+
+	// AutomationAutoReplied means the message was an automatic reply to
+	// another message, such as a vacation or out-of-office reply.
+	AutomationAutoReplied
+)
+
+// String ...
+func (a Automation) String() string {
+	switch a {
+	case AutomationBulk:
+		return "bulk"
+	case AutomationAutoGenerated:
+		return "auto-generated"
+	case AutomationAutoReplied:
+		return "auto-replied"
+	}
+	return "none"
+}
+
+// AutomationSignal is a header field that indicated a message was automated.
+type AutomationSignal struct {
+	// Field is the header field name, such as "Auto-Submitted".
+	Field string
+
+	// Value is the value of the header field, such as "auto-replied".
+	Value string
+
+	// Automation is the classification this signal indicates.
+	Automation Automation
+}
+
+// autoReplySubjectRegexp matches the subject prefixes commonly used by
+// vacation and out-of-office auto-responders.
+// The English out-of-office and vacation prefixes must be followed by a colon,
+// so that ordinary subjects such as "Vacation request" are not matched.
+var autoReplySubjectRegexp = regexp.MustCompile(`(?i)^\s*(auto(matic)?[ -]?(reply|response|antwort)|auto:|autoreply|out of (the )?office( auto-?reply)?\s*:|abwesenheitsnotiz|r[ée]ponse automatique|risposta automatica|respuesta autom[áa]tica|vacation( reply)?\s*:)`)
+
+// Automation classifies this message as having been sent by a human,
+// or as an automatic reply, automatically generated, or bulk message.
+// It evaluates the Auto-Submitted (RFC 3834), X-Auto-Response-Suppress,
+// Precedence, X-Autoreply, X-Autorespond, List-Id, List-Unsubscribe,
+// and Return-Path header fields, and common auto-reply subject prefixes.
+// The strongest classification found is returned, along with every
+// signal that was found.
+func (m *Message) Automation() (Automation, []AutomationSignal) {
+	return m.Header.Automation()
+}
+
+// Automation classifies this header, see Message.Automation.
+func (h Header) Automation() (Automation, []AutomationSignal) {
+	var signals []AutomationSignal
+	add := func(field string, value string, automation Automation) {
+		signals = append(signals, AutomationSignal{Field: field, Value: value, Automation: automation})
+	}
+
+	if value := h.Get("Auto-Submitted"); len(value) > 0 {
+		switch strings.ToLower(strings.TrimSpace(removeComments(strings.SplitN(value, ";", 2)[0]))) {
+		case "no":
+		case "auto-replied":
+			add("Auto-Submitted", value, AutomationAutoReplied)
+		default:
+			add("Auto-Submitted", value, AutomationAutoGenerated)
+		}
+	}
+
+	if value := h.Get("X-Auto-Response-Suppress"); len(value) > 0 {
+		for _, option := range strings.Split(value, ",") {
+			if option = strings.ToLower(strings.TrimSpace(option)); option == "all" || option == "oof" || option == "autoreply" {
+				add("X-Auto-Response-Suppress", value, AutomationAutoGenerated)
+				break
+			}
+		}
+	}
+
+	if value := h.Get("Precedence"); len(value) > 0 {
+		switch strings.ToLower(strings.TrimSpace(value)) {
+		case "bulk", "list", "junk":
+			add("Precedence", value, AutomationBulk)
+		case "auto_reply":
+			add("Precedence", value, AutomationAutoReplied)
+		}
+	}
+
+	for _, field := range []string{"X-Autoreply", "X-Autorespond"} {
+		if value := h.Get(field); len(value) > 0 && !strings.EqualFold(strings.TrimSpace(value), "no") {
+			add(field, value, AutomationAutoReplied)
+		}
+	}
+	if value := h.Get("X-Autogenerated"); len(value) > 0 {
+		if strings.EqualFold(strings.TrimSpace(value), "reply") {
+			add("X-Autogenerated", value, AutomationAutoReplied)
+		} else {
+			add("X-Autogenerated", value, AutomationAutoGenerated)
+		}
+	}
+
+	for _, field := range []string{"List-Id", "List-Unsubscribe"} {
+		if value := h.Get(field); len(value) > 0 {
+			add(field, value, AutomationBulk)
+		}
+	}
+
+	if h.IsSet("Return-Path") && trimAngleBrackets(h.Get("Return-Path")) == "" {
+		add("Return-Path", h.Get("Return-Path"), AutomationAutoGenerated)
+	}
+
+	if subject := h.Subject(); autoReplySubjectRegexp.MatchString(subject) {
+		add("Subject", subject, AutomationAutoReplied)
+	}
+
+	automation := AutomationNone
+	for _, signal := range signals {
+		if signal.Automation > automation {
+			automation = signal.Automation
+		}
+	}
+	return automation, signals
+}
@@ -0,0 +1,45 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package email
+
+import (
+	"testing"
+)
+
+// TestAutomation ...
+func TestAutomation(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		header   Header
+		expected Automation
+		signals  int
+	}{
+		{Header{"Subject": {"Question about my order"}}, AutomationNone, 0},
+		{Header{"Auto-Submitted": {"no"}}, AutomationNone, 0},
+		{Header{"Auto-Submitted": {"auto-replied (vacation)"}}, AutomationAutoReplied, 1},
+		{Header{"Auto-Submitted": {"auto-generated"}}, AutomationAutoGenerated, 1},
+		{Header{"X-Auto-Response-Suppress": {"DR, OOF, AutoReply"}}, AutomationAutoGenerated, 1},
+		{Header{"Precedence": {"bulk"}}, AutomationBulk, 1},
+		{Header{"List-Id": {"<list.host.com>"}, "Precedence": {"list"}}, AutomationBulk, 2},
+		{Header{"X-Autoreply": {"yes"}}, AutomationAutoReplied, 1},
+		{Header{"Return-Path": {"<>"}}, AutomationAutoGenerated, 1},
+		{Header{"Return-Path": {"<sender@host.com>"}}, AutomationNone, 0},
+		{Header{"Subject": {"Automatic reply: Question about my order"}}, AutomationAutoReplied, 1},
+		{Header{"Subject": {"Out of Office: Question"}, "List-Id": {"<list.host.com>"}}, AutomationAutoReplied, 2},
+		{Header{"Subject": {"Out of Office AutoReply: Question"}}, AutomationAutoReplied, 1},
+		{Header{"Subject": {"Vacation: back on Monday"}}, AutomationAutoReplied, 1},
+		{Header{"Subject": {"Vacation request for March"}}, AutomationNone, 0},
+		{Header{"Subject": {"Out of office supplies budget"}}, AutomationNone, 0},
+		{Header{"Subject": {"Vacationing in Spain"}}, AutomationNone, 0},
+	}
+
+	for idx, test := range tests {
+		automation, signals := test.header.Automation()
+		if automation != test.expected || len(signals) != test.signals {
+			t.Fatal("Automation does not match expected for test", idx, automation, signals)
+		}
+	}
+}
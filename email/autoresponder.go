@@ -0,0 +1,257 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package email
+
+import (
+	"errors"
+	"net/mail"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultAutoReplyWindow is the default length of time during which
+	// only a single automatic reply will be sent to the same sender.
+	// RFC 3834 recommends a period of at least several days.
+	DefaultAutoReplyWindow = 7 * 24 * time.Hour
+)
+
+var (
+	// ErrAutoReplyAutomated ...
+	ErrAutoReplyAutomated = errors.New("Message was automated, and must not be automatically replied to")
+
+	// ErrAutoReplyNoSender ...
+	ErrAutoReplyNoSender = errors.New("Message has no usable sender to automatically reply to")
+
+	// ErrAutoReplyNotRecipient ...
+	ErrAutoReplyNotRecipient = errors.New("Message was not addressed to any of the responder's addresses")
+
+	// ErrAutoReplyRecentlySent ...
+	ErrAutoReplyRecentlySent = errors.New("Message sender was already automatically replied to within the window")
+)
+
+// ReplyHistory records when automatic replies were sent to each address,
+// so that an AutoResponder replies to each sender only once per window.
+// Implementations must be safe for concurrent use.
+type ReplyHistory interface {
+	// LastReplied returns the last time an automatic reply was sent to
+	// this address, and false if a reply has never been sent to it.
+	LastReplied(address string) (time.Time, bool, error)
+
+	// ShouldReply returns true, and records that an automatic reply was sent
+	// to this address at this time, unless one was already sent to it within
+	// the window. The check and the record must be a single atomic operation,
+	// so that concurrent calls for the same address return true only once.
+	ShouldReply(address string, now time.Time, window time.Duration) (bool, error)
+}
+
+// NewMemoryReplyHistory returns a ReplyHistory kept in memory,
+// which is lost when the process exits.
+func NewMemoryReplyHistory() ReplyHistory {
+	return &memoryReplyHistory{replied: map[string]time.Time{}}
+}
+
+// memoryReplyHistory ...
+type memoryReplyHistory struct {
+	mu      sync.Mutex
+	replied map[string]time.Time
+}
+
+// LastReplied ...
+func (h *memoryReplyHistory) LastReplied(address string) (time.Time, bool, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	at, ok := h.replied[strings.ToLower(address)]
+	return at, ok, nil
+}
+
+// ShouldReply ...
+func (h *memoryReplyHistory) ShouldReply(address string, now time.Time, window time.Duration) (bool, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	address = strings.ToLower(address)
+	if last, ok := h.replied[address]; ok && now.Sub(last) < window {
+		return false, nil
+	}
+	h.replied[address] = now
+	return true, nil
+}
+
+// AutoResponder creates automatic replies, such as vacation or
+// out-of-office replies, following the rules of RFC 3834.
+type AutoResponder struct {
+	// From is the address the automatic replies are sent from.
+	From string
+
+	// Addresses are the responder's own addresses. If not empty, only messages
+	// that list one of these addresses in To or Cc are replied to.
+	// The From address is always included.
+	Addresses []string
+
+	// Subject is the subject of the automatic replies.
+	// If empty, the original subject prefixed with "Re: " is used.
+	Subject string
+
+	// TextPlain is the plain text content of the automatic replies.
+	TextPlain string
+
+	// HTML is the optional html content of the automatic replies.
+	HTML string
+
+	// Window is the length of time during which only a single automatic reply
+	// is sent to the same sender. If zero, DefaultAutoReplyWindow is used.
+	Window time.Duration
+
+	// History records the automatic replies sent. If nil, every message
+	// that is not automated is replied to.
+	History ReplyHistory
+
+	// Now returns the current time. If nil, time.Now is used.
+	Now func() time.Time
+}
+
+// Reply creates an automatic reply to the original message, addressed to
+// its envelope sender (the Return-Path, or the From address if missing).
+// The reply is marked with "Auto-Submitted: auto-replied", and threaded
+// to the original using the In-Reply-To and References header fields.
+// An error is returned instead if the original message was itself automated
+// or sent by a mailing list (ErrAutoReplyAutomated), if there is no
+// usable sender (ErrAutoReplyNoSender), if it was not addressed to the
+// responder (ErrAutoReplyNotRecipient), or if the sender was already
+// replied to within the Window (ErrAutoReplyRecentlySent).
+// The reply is recorded in the History before it is returned.
+func (a *AutoResponder) Reply(original *Message) (*Message, error) {
+	if automation, _ := original.Automation(); automation != AutomationNone {
+		return nil, ErrAutoReplyAutomated
+	}
+
+	sender, err := envelopeSender(original.Header)
+	if err != nil {
+		return nil, err
+	}
+	if sender.Address == "" || isAutomatedSender(sender.Address) {
+		return nil, ErrAutoReplyNoSender
+	}
+	if !a.isRecipient(original.Header) {
+		return nil, ErrAutoReplyNotRecipient
+	}
+
+	now := time.Now
+	if a.Now != nil {
+		now = a.Now
+	}
+	if a.History != nil {
+		window := a.Window
+		if window == 0 {
+			window = DefaultAutoReplyWindow
+		}
+		shouldReply, err := a.History.ShouldReply(sender.Address, now(), window)
+		if err != nil {
+			return nil, err
+		}
+		if !shouldReply {
+			return nil, ErrAutoReplyRecentlySent
+		}
+	}
+
+	subject := a.Subject
+	if len(subject) == 0 {
+		subject = replySubject(original.Header.Subject())
+	}
+	headers := NewHeader(a.From, subject, sender.Address)
+	headers.Set("Auto-Submitted", "auto-replied")
+	setReplyReferences(headers, original.Header)
+
+	var reply *Message
+	if len(a.HTML) > 0 {
+		reply = NewMessage(headers, a.TextPlain, a.HTML)
+	} else {
+		reply = NewPartText(a.TextPlain)
+		for field, values := range headers {
+			reply.Header[field] = values
+		}
+	}
+	return reply, nil
+}
+
+// isRecipient returns true if one of the responder's addresses
+// is listed in the To or Cc header fields, or there are no addresses.
+func (a *AutoResponder) isRecipient(h Header) bool {
+	if len(a.Addresses) == 0 {
+		return true
+	}
+	own := append([]string{a.From}, a.Addresses...)
+	for _, field := range []string{"To", "Cc"} {
+		recipients, err := h.AddressList(field)
+		if err != nil {
+			continue
+		}
+		for _, recipient := range recipients {
+			for _, address := range own {
+				if parsed, err := mail.ParseAddress(address); err == nil && strings.EqualFold(parsed.Address, recipient.Address) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// envelopeSender returns the Return-Path address, or the From address if missing.
+func envelopeSender(h Header) (*mail.Address, error) {
+	if h.IsSet("Return-Path") {
+		returnPath := trimAngleBrackets(h.Get("Return-Path"))
+		if returnPath == "" {
+			return &mail.Address{}, nil
+		}
+		return &mail.Address{Address: returnPath}, nil
+	}
+	if len(h.From()) == 0 {
+		return nil, ErrAutoReplyNoSender
+	}
+	return mail.ParseAddress(h.From())
+}
+
+// isAutomatedSender returns true for addresses that RFC 3834 recommends
+// never automatically replying to, such as "MAILER-DAEMON" or "owner-" addresses.
+func isAutomatedSender(address string) bool {
+	local := strings.ToLower(address)
+	if at := strings.LastIndexByte(local, '@'); at >= 0 {
+		local = local[:at]
+	}
+	switch local {
+	case "mailer-daemon", "postmaster", "listserv", "majordomo", "noreply", "no-reply", "do-not-reply", "donotreply":
+		return true
+	}
+	return strings.HasPrefix(local, "owner-") || strings.HasSuffix(local, "-request") || strings.HasSuffix(local, "-owner")
+}
+
+// replySubject returns the subject prefixed with "Re: ", unless it already is.
+func replySubject(subject string) string {
+	if len(subject) >= 3 && strings.EqualFold(subject[:3], "re:") {
+		return subject
+	}
+	return "Re: " + subject
+}
+
+// setReplyReferences sets the In-Reply-To and References header fields
+// of a reply, to thread it with the original message.
+func setReplyReferences(h Header, original Header) {
+	messageID := strings.TrimSpace(original.Get("Message-Id"))
+	if len(messageID) == 0 {
+		return
+	}
+	h.Set("In-Reply-To", messageID)
+	references := strings.TrimSpace(original.Get("References"))
+	if len(references) == 0 {
+		references = strings.TrimSpace(original.Get("In-Reply-To"))
+	}
+	if len(references) > 0 {
+		h.Set("References", references+" "+messageID)
+	} else {
+		h.Set("References", messageID)
+	}
+}
@@ -0,0 +1,101 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package email
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestAutoResponder ...
+func TestAutoResponder(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC)
+	responder := &AutoResponder{
+		From:      "Support <support@host.com>",
+		TextPlain: "I am on vacation.",
+		History:   NewMemoryReplyHistory(),
+		Now:       func() time.Time { return now },
+	}
+
+	original := NewMessage(NewHeader("customer@host.org", "Question", "support@host.com"), "text", "<b>html</b>")
+	original.Header.Set("Message-Id", "<1@host.org>")
+	original.Header.Set("Return-Path", "<bounces@host.org>")
+
+	reply, err := responder.Reply(original)
+	if err != nil {
+		t.Fatal("Could not create automatic reply:", err)
+	}
+	if reply.Header.Get("Auto-Submitted") != "auto-replied" || reply.Header.Subject() != "Re: Question" ||
+		reply.Header.Get("To") != "bounces@host.org" || reply.Header.Get("In-Reply-To") != "<1@host.org>" ||
+		reply.Header.Get("References") != "<1@host.org>" || string(reply.Body) != "I am on vacation." {
+		t.Fatal("Automatic reply does not match expected values:", reply.Header)
+	}
+	if automation, _ := reply.Automation(); automation != AutomationAutoReplied {
+		t.Fatal("Automatic reply should be detected as automated")
+	}
+
+	// Replying to the reply, the same sender within the window, or a list, must be refused
+	if _, err = responder.Reply(reply); err != ErrAutoReplyAutomated {
+		t.Fatal("Should not reply to an automatic reply:", err)
+	}
+	if _, err = responder.Reply(original); err != ErrAutoReplyRecentlySent {
+		t.Fatal("Should not reply to the same sender within the window:", err)
+	}
+	now = now.Add(DefaultAutoReplyWindow)
+	if _, err = responder.Reply(original); err != nil {
+		t.Fatal("Should reply to the same sender after the window:", err)
+	}
+	original.Header.Set("List-Id", "<list.host.org>")
+	if _, err = responder.Reply(original); err != ErrAutoReplyAutomated {
+		t.Fatal("Should not reply to a mailing list:", err)
+	}
+	original.Header.Del("List-Id")
+	original.Header.Set("Return-Path", "<owner-list@host.org>")
+	if _, err = responder.Reply(original); err != ErrAutoReplyNoSender {
+		t.Fatal("Should not reply to a list owner:", err)
+	}
+	original.Header.Del("Return-Path")
+	responder.Addresses = []string{"help@host.com"}
+	original.Header.SetTo("someone.else@host.com")
+	if _, err = responder.Reply(original); err != ErrAutoReplyNotRecipient {
+		t.Fatal("Should not reply to messages not addressed to the responder:", err)
+	}
+}
+
+// TestMemoryReplyHistory ...
+func TestMemoryReplyHistory(t *testing.T) {
+	t.Parallel()
+
+	history := NewMemoryReplyHistory()
+	now := time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	// Concurrent checks for the same sender must only allow a single reply
+	var replies int32
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if shouldReply, err := history.ShouldReply("Customer@host.org", now, time.Hour); err == nil && shouldReply {
+				atomic.AddInt32(&replies, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if replies != 1 {
+		t.Fatal("Expected exactly one reply; got", replies)
+	}
+
+	if last, ok, err := history.LastReplied("customer@host.org"); err != nil || !ok || !last.Equal(now) {
+		t.Fatal("Reply was not recorded:", last, ok, err)
+	}
+	if shouldReply, _ := history.ShouldReply("customer@host.org", now.Add(time.Hour), time.Hour); !shouldReply {
+		t.Fatal("Should reply after the window")
+	}
+}
@@ -0,0 +1,271 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package email
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+// Compound File Binary constants, from MS-CFB
+const (
+	cfbHeaderSize     = 512
+	cfbDirEntrySize   = 128
+	cfbMaxRegSect     = 0xFFFFFFFA
+	cfbEndOfChain     = 0xFFFFFFFE
+	cfbFreeSect       = 0xFFFFFFFF
+	cfbNoStream       = 0xFFFFFFFF
+	cfbTypeStorage    = 1
+	cfbTypeStream     = 2
+	cfbTypeRoot       = 5
+	cfbHeaderDIFATLen = 109
+)
+
+// cfbSignature is the first eight bytes of every Compound File Binary file.
+var cfbSignature = []byte{0xD0, 0xCF, 0x11, 0xE0, 0xA1, 0xB1, 0x1A, 0xE1}
+
+// compoundFile is a read-only Compound File Binary (OLE2) file,
+// as used by Outlook .msg files, as defined by MS-CFB.
+type compoundFile struct {
+	data            []byte
+	sectorSize      int
+	miniSectorSize  int
+	miniStreamLimit uint64
+	fat             []uint32
+	miniFAT         []uint32
+	miniStream      []byte
+	entries         []cfbEntry
+}
+
+// cfbEntry is a directory entry: a storage (directory) or stream (file).
+type cfbEntry struct {
+	name        string
+	objectType  byte
+	left        uint32
+	right       uint32
+	child       uint32
+	startSector uint32
+	size        uint64
+}
+
+// cfbStorage is a storage, with its streams and child storages by name.
+type cfbStorage struct {
+	streams  map[string][]byte
+	storages map[string]*cfbStorage
+}
+
+// parseCompoundFile parses the header, allocation tables, and directory of a Compound File Binary file.
+func parseCompoundFile(data []byte) (*compoundFile, error) {
+	if len(data) < cfbHeaderSize || !bytes.Equal(data[:8], cfbSignature) {
+		return nil, errors.New("Compound file signature not found")
+	}
+	sectorShift := binary.LittleEndian.Uint16(data[30:])
+	miniSectorShift := binary.LittleEndian.Uint16(data[32:])
+	if sectorShift != 9 && sectorShift != 12 || miniSectorShift != 6 {
+		return nil, errors.New("Compound file has an invalid sector size")
+	}
+	cf := &compoundFile{
+		data:            data,
+		sectorSize:      1 << sectorShift,
+		miniSectorSize:  1 << miniSectorShift,
+		miniStreamLimit: uint64(binary.LittleEndian.Uint32(data[56:])),
+	}
+	numFATSectors := binary.LittleEndian.Uint32(data[44:])
+	firstDirSector := binary.LittleEndian.Uint32(data[48:])
+	firstMiniFATSector := binary.LittleEndian.Uint32(data[60:])
+	firstDIFATSector := binary.LittleEndian.Uint32(data[68:])
+
+	// Sizes from the header are bounded by the number of sectors in the file, before anything is allocated
+	numSectors := len(data) / cf.sectorSize
+	if uint64(numFATSectors) > uint64(numSectors) {
+		return nil, errors.New("Compound file has an invalid number of FAT sectors")
+	}
+
+	// The DIFAT lists the sectors of the FAT: the first 109 are in the header, the rest are chained
+	difat := make([]uint32, 0, numFATSectors)
+	for i := 0; i < cfbHeaderDIFATLen; i++ {
+		difat = append(difat, binary.LittleEndian.Uint32(data[76+i*4:]))
+	}
+	entriesPerSector := cf.sectorSize / 4
+	for sector, seen := firstDIFATSector, 0; sector <= cfbMaxRegSect; seen++ {
+		b, err := cf.sector(sector)
+		if err != nil || seen > numSectors {
+			return nil, errors.New("Compound file has an invalid DIFAT chain")
+		}
+		for i := 0; i < entriesPerSector-1; i++ {
+			difat = append(difat, binary.LittleEndian.Uint32(b[i*4:]))
+		}
+		sector = binary.LittleEndian.Uint32(b[(entriesPerSector-1)*4:])
+	}
+
+	for _, sector := range difat {
+		if uint32(len(cf.fat)/entriesPerSector) >= numFATSectors || sector > cfbMaxRegSect {
+			break
+		}
+		b, err := cf.sector(sector)
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < entriesPerSector; i++ {
+			cf.fat = append(cf.fat, binary.LittleEndian.Uint32(b[i*4:]))
+		}
+	}
+
+	dir, err := cf.chain(cf.fat, firstDirSector, cf.sector)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i+cfbDirEntrySize <= len(dir); i += cfbDirEntrySize {
+		e := dir[i : i+cfbDirEntrySize]
+		nameLen := int(binary.LittleEndian.Uint16(e[64:]))
+		if nameLen > 64 {
+			nameLen = 64
+		}
+		cf.entries = append(cf.entries, cfbEntry{
+			name:        decodeUTF16(e[:nameLen]),
+			objectType:  e[66],
+			left:        binary.LittleEndian.Uint32(e[68:]),
+			right:       binary.LittleEndian.Uint32(e[72:]),
+			child:       binary.LittleEndian.Uint32(e[76:]),
+			startSector: binary.LittleEndian.Uint32(e[116:]),
+			size:        binary.LittleEndian.Uint64(e[120:]),
+		})
+	}
+	if len(cf.entries) == 0 || cf.entries[0].objectType != cfbTypeRoot {
+		return nil, errors.New("Compound file is missing its root directory entry")
+	}
+	if cf.sectorSize == 512 {
+		// Version 3 files may have garbage in the high 32 bits of the size
+		for i := range cf.entries {
+			cf.entries[i].size &= 0xFFFFFFFF
+		}
+	}
+
+	miniFAT, err := cf.chain(cf.fat, firstMiniFATSector, cf.sector)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i+4 <= len(miniFAT); i += 4 {
+		cf.miniFAT = append(cf.miniFAT, binary.LittleEndian.Uint32(miniFAT[i:]))
+	}
+	root := cf.entries[0]
+	cf.miniStream, err = cf.chain(cf.fat, root.startSector, cf.sector)
+	if err != nil {
+		return nil, err
+	}
+	return cf, nil
+}
+
+// sector returns the bytes of a regular sector.
+// The last sector of a file is allowed to be truncated.
+func (cf *compoundFile) sector(sector uint32) ([]byte, error) {
+	start := (int64(sector) + 1) * int64(cf.sectorSize)
+	if sector > cfbMaxRegSect || start >= int64(len(cf.data)) {
+		return nil, errors.New("Compound file sector is out of range")
+	}
+	end := start + int64(cf.sectorSize)
+	if end > int64(len(cf.data)) {
+		end = int64(len(cf.data))
+	}
+	return cf.data[start:end], nil
+}
+
+// miniSectorAt returns the bytes of a mini sector, from within the mini stream.
+func (cf *compoundFile) miniSectorAt(sector uint32) ([]byte, error) {
+	start := int64(sector) * int64(cf.miniSectorSize)
+	if start+int64(cf.miniSectorSize) > int64(len(cf.miniStream)) {
+		return nil, errors.New("Compound file mini sector is out of range")
+	}
+	return cf.miniStream[start : start+int64(cf.miniSectorSize)], nil
+}
+
+// chain follows a chain of sectors through an allocation table, returning their concatenated bytes.
+func (cf *compoundFile) chain(table []uint32, start uint32, read func(uint32) ([]byte, error)) ([]byte, error) {
+	buffer := &bytes.Buffer{}
+	visited := make([]bool, len(table))
+	for sector := start; sector != cfbEndOfChain && sector != cfbFreeSect; {
+		if int64(sector) >= int64(len(table)) {
+			return nil, errors.New("Compound file has an invalid sector chain")
+		}
+		if visited[sector] {
+			return nil, errors.New("Compound file has a cyclic sector chain")
+		}
+		visited[sector] = true
+		b, err := read(sector)
+		if err != nil {
+			return nil, err
+		}
+		buffer.Write(b)
+		sector = table[sector]
+	}
+	return buffer.Bytes(), nil
+}
+
+// stream returns the content of a stream directory entry.
+func (cf *compoundFile) stream(entry cfbEntry) ([]byte, error) {
+	if entry.size == 0 {
+		return []byte{}, nil
+	}
+	var b []byte
+	var err error
+	if entry.size < cf.miniStreamLimit {
+		b, err = cf.chain(cf.miniFAT, entry.startSector, cf.miniSectorAt)
+	} else {
+		b, err = cf.chain(cf.fat, entry.startSector, cf.sector)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(b)) < entry.size {
+		return nil, errors.New("Compound file stream is truncated")
+	}
+	return b[:entry.size], nil
+}
+
+// root returns the root storage, with all of its streams and child storages loaded.
+func (cf *compoundFile) root() (*cfbStorage, error) {
+	return cf.storage(cf.entries[0], 0, map[uint32]bool{0: true})
+}
+
+// storage loads a storage's streams and child storages, recursively.
+// Each directory entry may only be reached once in the whole tree, as they
+// would otherwise be loaded again for every storage that shares them.
+func (cf *compoundFile) storage(entry cfbEntry, depth int, visited map[uint32]bool) (*cfbStorage, error) {
+	if depth > 32 {
+		return nil, errors.New("Compound file storages are nested too deeply")
+	}
+	s := &cfbStorage{streams: map[string][]byte{}, storages: map[string]*cfbStorage{}}
+	var walk func(id uint32) error
+	walk = func(id uint32) error {
+		if id == cfbNoStream {
+			return nil
+		}
+		if int(id) >= len(cf.entries) || visited[id] {
+			return errors.New("Compound file has an invalid directory tree")
+		}
+		visited[id] = true
+		child := cf.entries[id]
+		switch child.objectType {
+		case cfbTypeStream:
+			b, err := cf.stream(child)
+			if err != nil {
+				return err
+			}
+			s.streams[child.name] = b
+		case cfbTypeStorage:
+			sub, err := cf.storage(child, depth+1, visited)
+			if err != nil {
+				return err
+			}
+			s.storages[child.name] = sub
+		}
+		if err := walk(child.left); err != nil {
+			return err
+		}
+		return walk(child.right)
+	}
+	return s, walk(entry.child)
+}
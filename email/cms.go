@@ -0,0 +1,549 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package email
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/subtle"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"math/big"
+)
+
+// The object identifiers of the Cryptographic Message Syntax (CMS), as defined by RFC 5652,
+// and of the algorithms used with it by S/MIME.
+var (
+	oidData                   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData             = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidEnvelopedData          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 3}
+	oidAuthEnvelopedData      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 23}
+	oidAttributeContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidAttributeMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidAttributeSigningTime   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+
+	oidSHA1   = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidSHA256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSHA384 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidSHA512 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+
+	oidRSAEncryption   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidSHA256WithRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}
+	oidSHA384WithRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 12}
+	oidSHA512WithRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 13}
+	oidECPublicKey     = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+	oidECDSAWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	oidECDSAWithSHA384 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}
+	oidECDSAWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}
+	oidEd25519         = asn1.ObjectIdentifier{1, 3, 101, 112}
+
+	oidAES128CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	oidAES128GCM  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 6}
+	oidAES192GCM  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 26}
+	oidAES256GCM  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 46}
+	oidAES128Wrap = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 5}
+	oidAES192Wrap = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 25}
+	oidAES256Wrap = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 45}
+
+	oidRSAESOAEP               = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 7}
+	oidMGF1                    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 8}
+	oidECDHSinglePassSHA1KDF   = asn1.ObjectIdentifier{1, 3, 133, 16, 840, 63, 0, 2}
+	oidECDHSinglePassSHA256KDF = asn1.ObjectIdentifier{1, 3, 132, 1, 11, 1}
+	oidECDHSinglePassSHA384KDF = asn1.ObjectIdentifier{1, 3, 132, 1, 11, 2}
+	oidECDHSinglePassSHA512KDF = asn1.ObjectIdentifier{1, 3, 132, 1, 11, 3}
+)
+
+// cmsKeyWrapIV is the initial value of AES key wrap, as defined by RFC 3394.
+var cmsKeyWrapIV = []byte{0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6}
+
+// cmsContentInfo wraps every CMS structure, identifying its type.
+// The Content is tagged [0], and holds the DER of the structure.
+type cmsContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"optional,tag:0"`
+}
+
+// cmsSignedData is the content of a signed CMS message.
+type cmsSignedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	EncapContentInfo cmsContentInfo
+	Certificates     asn1.RawValue   `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue   `asn1:"optional,tag:1"`
+	SignerInfos      []cmsSignerInfo `asn1:"set"`
+}
+
+// cmsSignerInfo is the signature of a single signer.
+// The SID is either an IssuerAndSerialNumber, or a [0] SubjectKeyIdentifier.
+type cmsSignerInfo struct {
+	Version            int
+	SID                asn1.RawValue
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignedAttrs        asn1.RawValue `asn1:"optional,tag:0"`
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+	UnsignedAttrs      asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+// cmsIssuerAndSerial identifies a certificate by its issuer and serial number.
+type cmsIssuerAndSerial struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+// cmsAttribute is a signed or unsigned attribute, with its DER encoded values.
+type cmsAttribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+// cmsEnvelopedData is the content of an encrypted CMS message.
+// Each of the RecipientInfos is either a KeyTransRecipientInfo, or a [1] KeyAgreeRecipientInfo.
+type cmsEnvelopedData struct {
+	Version              int
+	OriginatorInfo       asn1.RawValue   `asn1:"optional,tag:0"`
+	RecipientInfos       []asn1.RawValue `asn1:"set"`
+	EncryptedContentInfo cmsEncryptedContentInfo
+	UnprotectedAttrs     asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+// cmsAuthEnvelopedData is the content of an encrypted CMS message, whose encryption also authenticates it,
+// as defined by RFC 5083.
+type cmsAuthEnvelopedData struct {
+	Version                  int
+	OriginatorInfo           asn1.RawValue   `asn1:"optional,tag:0"`
+	RecipientInfos           []asn1.RawValue `asn1:"set"`
+	AuthEncryptedContentInfo cmsEncryptedContentInfo
+	AuthAttrs                asn1.RawValue `asn1:"optional,tag:1"`
+	MAC                      []byte
+	UnauthAttrs              asn1.RawValue `asn1:"optional,tag:2"`
+}
+
+// cmsEncryptedContentInfo is the encrypted content, which is tagged [0].
+type cmsEncryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           asn1.RawValue `asn1:"optional,tag:0"`
+}
+
+// cmsGCMParameters are the parameters of AES-GCM content encryption, as defined by RFC 5084.
+type cmsGCMParameters struct {
+	Nonce  []byte
+	ICVLen int `asn1:"default:12"`
+}
+
+// cmsKeyTransRecipientInfo is the content encryption key, encrypted with a recipient's RSA public key.
+type cmsKeyTransRecipientInfo struct {
+	Version                int
+	RID                    asn1.RawValue
+	KeyEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedKey           []byte
+}
+
+// cmsRSAOAEPParameters are the parameters of RSA-OAEP key encryption, as defined by RFC 4055.
+// Absent hash and mask generation functions are SHA-1.
+type cmsRSAOAEPParameters struct {
+	HashFunc    pkix.AlgorithmIdentifier `asn1:"optional,explicit,tag:0"`
+	MaskGenFunc pkix.AlgorithmIdentifier `asn1:"optional,explicit,tag:1"`
+	PSourceFunc pkix.AlgorithmIdentifier `asn1:"optional,explicit,tag:2"`
+}
+
+// cmsKeyAgreeRecipientInfo is the content encryption key, wrapped with a key agreed between
+// an ephemeral originator key and a recipient's elliptic curve public key, as defined by RFC 5753.
+// The KeyEncryptionAlgorithm's parameters are the key wrap algorithm.
+type cmsKeyAgreeRecipientInfo struct {
+	Version                int
+	Originator             asn1.RawValue `asn1:"explicit,tag:0"`
+	UKM                    []byte        `asn1:"optional,explicit,tag:1"`
+	KeyEncryptionAlgorithm pkix.AlgorithmIdentifier
+	RecipientEncryptedKeys []cmsRecipientEncryptedKey
+}
+
+// cmsOriginatorPublicKey is the ephemeral public key of a key agreement, which is tagged [1].
+type cmsOriginatorPublicKey struct {
+	Algorithm pkix.AlgorithmIdentifier
+	PublicKey asn1.BitString
+}
+
+// cmsRecipientEncryptedKey is the wrapped content encryption key of a single recipient of a key agreement.
+// The RID is either an IssuerAndSerialNumber, or a [0] RecipientKeyIdentifier.
+type cmsRecipientEncryptedKey struct {
+	RID          asn1.RawValue
+	EncryptedKey []byte
+}
+
+// cmsECCSharedInfo is the input to the key derivation function of a key agreement.
+type cmsECCSharedInfo struct {
+	KeyInfo     asn1.RawValue
+	EntityUInfo []byte `asn1:"optional,explicit,tag:0"`
+	SuppPubInfo []byte `asn1:"explicit,tag:2"`
+}
+
+// cmsWrap returns the DER of a ContentInfo holding the DER of the content.
+func cmsWrap(contentType asn1.ObjectIdentifier, content []byte) ([]byte, error) {
+	return asn1.Marshal(cmsContentInfo{
+		ContentType: contentType,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: content},
+	})
+}
+
+// cmsUnwrap parses a ContentInfo of the expected type, returning the DER of its content.
+func cmsUnwrap(der []byte, contentType asn1.ObjectIdentifier) ([]byte, error) {
+	actualType, content, err := cmsParse(der)
+	if err != nil {
+		return nil, err
+	}
+	if !actualType.Equal(contentType) {
+		return nil, errors.New("Unexpected CMS content type: " + actualType.String())
+	}
+	return content, nil
+}
+
+// cmsParse parses a ContentInfo of any type, which may be BER encoded,
+// returning its type and the DER of its content.
+func cmsParse(ber []byte) (asn1.ObjectIdentifier, []byte, error) {
+	der, err := cmsBERToDER(ber)
+	if err != nil {
+		return nil, nil, errors.New("Invalid CMS content: " + err.Error())
+	}
+	var info cmsContentInfo
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, nil, errors.New("Invalid CMS content: " + err.Error())
+	}
+	return info.ContentType, info.Content.Bytes, nil
+}
+
+// cmsMaxBERDepth is the deepest nesting of BER elements that is converted to DER,
+// which is far more than any CMS message needs.
+const cmsMaxBERDepth = 64
+
+// cmsBERElement is a parsed BER element, with the length of its content once converted to DER.
+type cmsBERElement struct {
+	identifier []byte
+	content    []byte // of primitive elements
+	children   []*cmsBERElement
+	joined     bool // a constructed OCTET STRING, whose children are joined into one
+	length     int
+}
+
+// cmsBERToDER converts the BER that many clients create CMS messages with to the DER that encoding/asn1 requires,
+// by replacing indefinite and long form lengths with minimal definite lengths, and joining constructed octet strings.
+// It does not sort sets, so a signature of BER signed attributes will still not verify.
+func cmsBERToDER(ber []byte) ([]byte, error) {
+	element, _, err := cmsParseBER(ber, 0)
+	if err != nil {
+		return nil, err
+	}
+	// The lengths are all known once parsed, so the DER is written in a single pass
+	return element.appendDER(make([]byte, 0, element.size())), nil
+}
+
+// cmsParseBER parses the first BER element, at this depth of nesting, returning it and the remaining bytes.
+func cmsParseBER(b []byte, depth int) (*cmsBERElement, []byte, error) {
+	if depth > cmsMaxBERDepth {
+		return nil, nil, errors.New("BER elements are nested too deeply")
+	}
+	truncated := errors.New("truncated BER element")
+	end := 1
+	if len(b) > 0 && b[0]&0x1f == 0x1f {
+		// High tag numbers continue while the top bit is set
+		for end < len(b) && b[end]&0x80 != 0 {
+			end++
+		}
+		end++
+	}
+	if end >= len(b) {
+		return nil, nil, truncated
+	}
+	element := &cmsBERElement{identifier: b[:end]}
+	constructed := b[0]&0x20 != 0
+	lengthByte := b[end]
+	end++
+
+	var rest []byte
+	if lengthByte == 0x80 {
+		// Indefinite lengths are followed by elements until an end-of-contents
+		if !constructed {
+			return nil, nil, errors.New("indefinite length primitive BER element")
+		}
+		for rest = b[end:]; ; {
+			if len(rest) < 2 {
+				return nil, nil, truncated
+			}
+			if rest[0] == 0 && rest[1] == 0 {
+				rest = rest[2:]
+				break
+			}
+			var child *cmsBERElement
+			var err error
+			if child, rest, err = cmsParseBER(rest, depth+1); err != nil {
+				return nil, nil, err
+			}
+			element.children = append(element.children, child)
+		}
+	} else {
+		length := int(lengthByte)
+		if lengthByte&0x80 != 0 {
+			size := int(lengthByte & 0x7f)
+			if size > 4 || end+size > len(b) {
+				return nil, nil, truncated
+			}
+			length = 0
+			for _, c := range b[end : end+size] {
+				length = length<<8 | int(c)
+			}
+			end += size
+		}
+		if length < 0 || end+length > len(b) {
+			return nil, nil, truncated
+		}
+		content := b[end : end+length]
+		rest = b[end+length:]
+		if !constructed {
+			element.content = content
+		}
+		for constructed && len(content) > 0 {
+			var child *cmsBERElement
+			var err error
+			if child, content, err = cmsParseBER(content, depth+1); err != nil {
+				return nil, nil, err
+			}
+			element.children = append(element.children, child)
+		}
+	}
+
+	element.length = len(element.content)
+	if len(element.identifier) == 1 && element.identifier[0] == 0x24 {
+		// A constructed OCTET STRING is the concatenation of its primitive OCTET STRINGs
+		element.identifier, element.joined = []byte{0x04}, true
+		for _, child := range element.children {
+			if len(child.identifier) != 1 || child.identifier[0] != 0x04 {
+				return nil, nil, errors.New("constructed OCTET STRING holds another type")
+			}
+			element.length += child.length
+		}
+	} else {
+		for _, child := range element.children {
+			element.length += child.size()
+		}
+	}
+	return element, rest, nil
+}
+
+// size returns the length of the element once converted to DER.
+func (e *cmsBERElement) size() int {
+	return len(e.identifier) + len(cmsDERLength(e.length)) + e.length
+}
+
+// appendDER appends the DER of the element.
+func (e *cmsBERElement) appendDER(der []byte) []byte {
+	der = append(der, e.identifier...)
+	der = append(der, cmsDERLength(e.length)...)
+	if e.joined {
+		return e.appendOctets(der)
+	}
+	der = append(der, e.content...)
+	for _, child := range e.children {
+		der = child.appendDER(der)
+	}
+	return der
+}
+
+// appendOctets appends the content of an OCTET STRING, joining those that were constructed.
+func (e *cmsBERElement) appendOctets(der []byte) []byte {
+	der = append(der, e.content...)
+	for _, child := range e.children {
+		der = child.appendOctets(der)
+	}
+	return der
+}
+
+// cmsJoinOctetStrings returns the concatenated contents of a series of DER OCTET STRINGs.
+func cmsJoinOctetStrings(der []byte) ([]byte, error) {
+	var joined []byte
+	for len(der) > 0 {
+		var part []byte
+		var err error
+		if der, err = asn1.Unmarshal(der, &part); err != nil {
+			return nil, err
+		}
+		joined = append(joined, part...)
+	}
+	return joined, nil
+}
+
+// cmsDERLength returns the minimal DER encoding of a length.
+func cmsDERLength(length int) []byte {
+	if length < 0x80 {
+		return []byte{byte(length)}
+	}
+	var b []byte
+	for ; length > 0; length >>= 8 {
+		b = append([]byte{byte(length)}, b...)
+	}
+	return append([]byte{0x80 | byte(len(b))}, b...)
+}
+
+// cmsIssuerAndSerialOf returns the DER of the IssuerAndSerialNumber of a certificate.
+func cmsIssuerAndSerialOf(cert *x509.Certificate) (asn1.RawValue, error) {
+	der, err := asn1.Marshal(cmsIssuerAndSerial{Issuer: asn1.RawValue{FullBytes: cert.RawIssuer}, SerialNumber: cert.SerialNumber})
+	return asn1.RawValue{FullBytes: der}, err
+}
+
+// cmsMatchesCertificate returns true if the signer or recipient identifier is of this certificate.
+func cmsMatchesCertificate(id asn1.RawValue, cert *x509.Certificate) bool {
+	if id.Class == asn1.ClassContextSpecific && id.Tag == 0 {
+		return len(cert.SubjectKeyId) > 0 && string(id.Bytes) == string(cert.SubjectKeyId)
+	}
+	var issuerAndSerial cmsIssuerAndSerial
+	if _, err := asn1.Unmarshal(id.FullBytes, &issuerAndSerial); err != nil || issuerAndSerial.SerialNumber == nil {
+		return false
+	}
+	return string(issuerAndSerial.Issuer.FullBytes) == string(cert.RawIssuer) && issuerAndSerial.SerialNumber.Cmp(cert.SerialNumber) == 0
+}
+
+// cmsDigestHash returns the hash of a digest algorithm, or zero if it is not supported.
+func cmsDigestHash(algorithm asn1.ObjectIdentifier) crypto.Hash {
+	switch {
+	case algorithm.Equal(oidSHA1):
+		return crypto.SHA1
+	case algorithm.Equal(oidSHA256):
+		return crypto.SHA256
+	case algorithm.Equal(oidSHA384):
+		return crypto.SHA384
+	case algorithm.Equal(oidSHA512):
+		return crypto.SHA512
+	}
+	return 0
+}
+
+// cmsDigestAlgorithm returns the digest algorithm identifier of a hash.
+func cmsDigestAlgorithm(hash crypto.Hash) asn1.ObjectIdentifier {
+	switch hash {
+	case crypto.SHA384:
+		return oidSHA384
+	case crypto.SHA512:
+		return oidSHA512
+	}
+	return oidSHA256
+}
+
+// cmsSignatureAlgorithm returns the x509 signature algorithm of a CMS signature,
+// which may identify either the whole algorithm, or only the type of key.
+func cmsSignatureAlgorithm(algorithm asn1.ObjectIdentifier, hash crypto.Hash) x509.SignatureAlgorithm {
+	switch {
+	case algorithm.Equal(oidRSAEncryption):
+		switch hash {
+		case crypto.SHA1:
+			return x509.SHA1WithRSA
+		case crypto.SHA256:
+			return x509.SHA256WithRSA
+		case crypto.SHA384:
+			return x509.SHA384WithRSA
+		case crypto.SHA512:
+			return x509.SHA512WithRSA
+		}
+	case algorithm.Equal(oidECPublicKey):
+		switch hash {
+		case crypto.SHA1:
+			return x509.ECDSAWithSHA1
+		case crypto.SHA256:
+			return x509.ECDSAWithSHA256
+		case crypto.SHA384:
+			return x509.ECDSAWithSHA384
+		case crypto.SHA512:
+			return x509.ECDSAWithSHA512
+		}
+	case algorithm.Equal(oidSHA256WithRSA):
+		return x509.SHA256WithRSA
+	case algorithm.Equal(oidSHA384WithRSA):
+		return x509.SHA384WithRSA
+	case algorithm.Equal(oidSHA512WithRSA):
+		return x509.SHA512WithRSA
+	case algorithm.Equal(oidECDSAWithSHA256):
+		return x509.ECDSAWithSHA256
+	case algorithm.Equal(oidECDSAWithSHA384):
+		return x509.ECDSAWithSHA384
+	case algorithm.Equal(oidECDSAWithSHA512):
+		return x509.ECDSAWithSHA512
+	case algorithm.Equal(oidEd25519):
+		return x509.PureEd25519
+	}
+	return x509.UnknownSignatureAlgorithm
+}
+
+// cmsKeyWrap wraps a key with AES key wrap, as defined by RFC 3394.
+func cmsKeyWrap(kek []byte, key []byte) ([]byte, error) {
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) < 16 || len(key)%8 != 0 {
+		return nil, errors.New("Invalid length of key to wrap")
+	}
+	n := len(key) / 8
+	a := append([]byte(nil), cmsKeyWrapIV...)
+	r := append([]byte(nil), key...)
+	buf := make([]byte, 16)
+	for j := 0; j < 6; j++ {
+		for i := 0; i < n; i++ {
+			copy(buf, a)
+			copy(buf[8:], r[i*8:])
+			block.Encrypt(buf, buf)
+			binary.BigEndian.PutUint64(a, binary.BigEndian.Uint64(buf)^uint64(n*j+i+1))
+			copy(r[i*8:], buf[8:])
+		}
+	}
+	return append(a, r...), nil
+}
+
+// cmsKeyUnwrap unwraps a key wrapped with AES key wrap, as defined by RFC 3394.
+func cmsKeyUnwrap(kek []byte, wrapped []byte) ([]byte, error) {
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < 24 || len(wrapped)%8 != 0 {
+		return nil, errors.New("Invalid length of wrapped key")
+	}
+	n := len(wrapped)/8 - 1
+	a := append([]byte(nil), wrapped[:8]...)
+	r := append([]byte(nil), wrapped[8:]...)
+	buf := make([]byte, 16)
+	for j := 5; j >= 0; j-- {
+		for i := n - 1; i >= 0; i-- {
+			binary.BigEndian.PutUint64(buf, binary.BigEndian.Uint64(a)^uint64(n*j+i+1))
+			copy(buf[8:], r[i*8:])
+			block.Decrypt(buf, buf)
+			copy(a, buf)
+			copy(r[i*8:], buf[8:])
+		}
+	}
+	if subtle.ConstantTimeCompare(a, cmsKeyWrapIV) != 1 {
+		return nil, errors.New("Wrapped key failed its integrity check")
+	}
+	return r, nil
+}
+
+// cmsKDF derives a key encryption key from a key agreement's shared secret,
+// with the ANSI X9.63 key derivation function, as used by RFC 5753.
+func cmsKDF(hash crypto.Hash, secret []byte, sharedInfo []byte, length int) []byte {
+	var key []byte
+	counter := make([]byte, 4)
+	for i := uint32(1); len(key) < length; i++ {
+		binary.BigEndian.PutUint32(counter, i)
+		h := hash.New()
+		h.Write(secret)
+		h.Write(counter)
+		h.Write(sharedInfo)
+		key = h.Sum(key)
+	}
+	return key[:length]
+}
@@ -5,9 +5,11 @@
 package email
 
 import (
+	"errors"
 	"io"
 	"io/ioutil"
 	"mime"
+	"net/mail"
 	"path/filepath"
 )
 
@@ -62,6 +64,150 @@ func NewMessageWithInlines(headers Header, textPlain string, html string, inline
 	return &Message{Header: headers, Parts: parts}
 }
 
+// NewDeliveryStatusMessage will create a Delivery Status Notification (DSN)
+// as defined by RFC 3464, containing a human readable explanation, the
+// machine readable delivery status, and optionally the original message.
+// If headersOnly is true, only the headers of the original message are attached.
+// Example structure:
+//     * multipart/report; report-type=delivery-status
+//     * * text/plain
+//     * * message/delivery-status
+//     * * message/rfc822 (or text/rfc822-headers)
+func NewDeliveryStatusMessage(headers Header, textPlain string, status *DeliveryStatus, original *Message, headersOnly bool) (*Message, error) {
+	statusPart, err := NewPartDeliveryStatus(status)
+	if err != nil {
+		return nil, err
+	}
+	return newReportMessage(headers, "delivery-status", NewPartText(textPlain), statusPart, original, headersOnly)
+}
+
+// NewFeedbackReportMessage will create an Abuse Reporting Format (ARF) report
+// as defined by RFC 5965, containing a human readable explanation, the
+// machine readable feedback report, and optionally the original message.
+// If headersOnly is true, only the headers of the original message are attached.
+// Example structure:
+//     * multipart/report; report-type=feedback-report
+//     * * text/plain
+//     * * message/feedback-report
+//     * * message/rfc822 (or text/rfc822-headers)
+func NewFeedbackReportMessage(headers Header, textPlain string, report *FeedbackReport, original *Message, headersOnly bool) (*Message, error) {
+	return newReportMessage(headers, "feedback-report", NewPartText(textPlain), NewPartFeedbackReport(report), original, headersOnly)
+}
+
+// NewDispositionNotificationMessage will create a Message Disposition
+// Notification (MDN, or "read receipt") as defined by RFC 8098, in reply to
+// the original message, which must have requested one using the
+// Disposition-Notification-To header.
+// The MDN contains a human readable explanation, the machine readable
+// notification, and the headers of the original message.
+// The notification's Disposition is required, with its action mode,
+// sending mode and type, otherwise ErrDispositionMissing is returned.
+// If they are empty, the notification's Final-Recipient is set from the
+// from address, and the Original-Message-ID and Original-Recipient are set
+// from the original message.
+// Example structure:
+//     * multipart/report; report-type=disposition-notification
+//     * * text/plain
+//     * * message/disposition-notification
+//     * * text/rfc822-headers
+func NewDispositionNotificationMessage(original *Message, from string, textPlain string, notification *DispositionNotification) (*Message, error) {
+	to := original.Header.DispositionNotificationTo()
+	if len(to) == 0 {
+		return nil, errors.New("Message did not request a disposition notification")
+	}
+	if d := notification.Disposition; len(d.ActionMode) == 0 || len(d.SendingMode) == 0 || len(d.Type) == 0 {
+		return nil, ErrDispositionMissing
+	}
+
+	filled := *notification
+	if len(filled.FinalRecipient) == 0 {
+		address, err := mail.ParseAddress(from)
+		if err != nil {
+			return nil, err
+		}
+		filled.FinalRecipient = "rfc822; " + address.Address
+	}
+	if len(filled.OriginalMessageID) == 0 {
+		filled.OriginalMessageID = original.Header.Get("Message-Id")
+	}
+	if len(filled.OriginalRecipient) == 0 {
+		filled.OriginalRecipient = original.Header.Get("Original-Recipient")
+	}
+
+	headers := NewHeader(from, "Read: "+original.Header.Subject(), to...)
+	setReplyReferences(headers, original.Header)
+	return newReportMessage(headers, "disposition-notification", NewPartText(textPlain), NewPartDispositionNotification(&filled), original, true)
+}
+
+// newReportMessage creates a multipart/report of this report-type,
+// containing the human readable part, the machine readable part,
+// and optionally the original message or its headers.
+func newReportMessage(headers Header, reportType string, humanPart *Message, reportPart *Message, original *Message, headersOnly bool) (*Message, error) {
+
+	headers.Set("Content-Type", "multipart/report; report-type="+reportType+"; boundary=\""+randomBoundary()+"\"")
+
+	parts := []*Message{humanPart, reportPart}
+	if original != nil {
+		if headersOnly {
+			headersPart, err := NewPartRFC822Headers(original.Header)
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, headersPart)
+		} else {
+			parts = append(parts, NewPartRFC822(original))
+		}
+	}
+	return &Message{Header: headers, Parts: parts}, nil
+}
+
+// NewPartDeliveryStatus creates a "message/delivery-status" part,
+// containing the per-message and per-recipient fields of the DeliveryStatus.
+func NewPartDeliveryStatus(status *DeliveryStatus) (*Message, error) {
+	recipients, err := status.recipientBytes()
+	if err != nil {
+		return nil, err
+	}
+	return &Message{
+		Header:     Header{"Content-Type": []string{"message/delivery-status"}},
+		SubMessage: &Message{Header: status.fields(), Body: recipients}}, nil
+}
+
+// NewPartFeedbackReport creates a "message/feedback-report" part,
+// containing the fields of the FeedbackReport.
+func NewPartFeedbackReport(report *FeedbackReport) *Message {
+	return &Message{
+		Header:     Header{"Content-Type": []string{"message/feedback-report"}},
+		SubMessage: &Message{Header: report.fields(), Body: []byte{}}}
+}
+
+// NewPartDispositionNotification creates a "message/disposition-notification"
+// part, containing the fields of the DispositionNotification.
+func NewPartDispositionNotification(notification *DispositionNotification) *Message {
+	return &Message{
+		Header:     Header{"Content-Type": []string{"message/disposition-notification"}},
+		SubMessage: &Message{Header: notification.fields(), Body: []byte{}}}
+}
+
+// NewPartRFC822 creates a "message/rfc822" part, encapsulating the message.
+func NewPartRFC822(msg *Message) *Message {
+	return &Message{
+		Header:     Header{"Content-Type": []string{"message/rfc822"}},
+		SubMessage: msg}
+}
+
+// NewPartRFC822Headers creates a "text/rfc822-headers" part,
+// with the header as its content.
+func NewPartRFC822Headers(header Header) (*Message, error) {
+	b, err := header.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	return &Message{
+		Header: Header{"Content-Type": []string{"text/rfc822-headers"}},
+		Body:   b}, nil
+}
+
 // NewPartMultipart will create a multipart part, optionally filled with sub-parts.
 // Common values for parameter multipartSubType are: mixed, alternative, related, and report.
 // Example: if "mixed" is passed in as multipartSubType, then a "multipart/mixed" part is created.
@@ -13,10 +13,12 @@ import (
 	"io/ioutil"
 	"mime"
 	"mime/multipart"
+	"net"
 	"net/mail"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 // TestBasicEmailCreation ...
@@ -545,3 +547,146 @@ func bytesOrPanic(b []byte, err error) []byte {
 	}
 	return b
 }
+
+// TestReportCreation ...
+func TestReportCreation(t *testing.T) {
+	t.Parallel()
+
+	original := NewMessage(NewHeader("sender@host.com", "Original Subject", "missing@host.org"), "text", "<b>html</b>")
+	if err := original.Save(); err != nil {
+		t.Fatal("Could not save original:", err)
+	}
+
+	status := &DeliveryStatus{
+		ReportingMTA: "dns; mx.host.org",
+		ArrivalDate:  time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC),
+		Recipients: []RecipientStatus{
+			{FinalRecipient: "rfc822; missing@host.org", Action: "failed", Status: "5.1.1",
+				DiagnosticCode: "smtp; 550 5.1.1 User unknown"},
+			{FinalRecipient: "rfc822; slow@host.org", Action: "delayed", Status: "4.4.1"},
+		},
+	}
+	dsn, err := NewDeliveryStatusMessage(NewHeader("MAILER-DAEMON@host.org", "Undelivered Mail", "sender@host.com"),
+		"Your message could not be delivered.", status, original, false)
+	if err != nil {
+		t.Fatal("Could not create delivery status notification:", err)
+	}
+	if !confirmValidHeader(dsn.Header) || !confirmContentType(dsn, "Content-Type", "multipart/report",
+		map[string]string{"boundary": "", "report-type": "delivery-status"}) || !confirmHasParts(dsn, 3, false, false) {
+		t.Fatal("Message does not match expected structure")
+	}
+
+	parsed, err := ParseMessage(bytes.NewReader(testMessageAgainstSelf(t, dsn)))
+	if err != nil {
+		t.Fatal("Could not parse delivery status notification:", err)
+	}
+	parsedStatus, err := parsed.Parts[1].DeliveryStatus()
+	if err != nil || len(parsedStatus.Recipients) != 2 {
+		t.Fatal("Could not parse delivery status:", err)
+	}
+	if parsedStatus.ReportingMTA != status.ReportingMTA || !parsedStatus.ArrivalDate.Equal(status.ArrivalDate) ||
+		parsedStatus.Recipients[0].DiagnosticCode != status.Recipients[0].DiagnosticCode ||
+		parsedStatus.Recipients[1].Action != "delayed" || parsedStatus.Recipients[1].Status != "4.4.1" {
+		t.Fatal("Delivery status does not match its parsed counterpart:", parsedStatus)
+	}
+	if parsedOriginal, err := parsed.DeliveryStatusOriginal(); err != nil || parsedOriginal.Header.Subject() != "Original Subject" {
+		t.Fatal("Could not find original message:", err)
+	}
+
+	report := &FeedbackReport{
+		FeedbackType:     "abuse",
+		UserAgent:        "go-email/1.0",
+		Version:          "1",
+		OriginalMailFrom: "sender@host.com",
+		OriginalRcptTo:   []string{"missing@host.org", "slow@host.org"},
+		SourceIP:         net.ParseIP("192.0.2.1"),
+		ReportedDomain:   []string{"host.com"},
+	}
+	arf, err := NewFeedbackReportMessage(NewHeader("abuse@host.org", "Abuse Report", "abuse@host.com"),
+		"This is an abuse report.", report, original, true)
+	if err != nil {
+		t.Fatal("Could not create feedback report:", err)
+	}
+	if !confirmValidHeader(arf.Header) || !confirmHasParts(arf, 3, false, false) {
+		t.Fatal("Message does not match expected structure")
+	}
+
+	parsed, err = ParseMessage(bytes.NewReader(testMessageAgainstSelf(t, arf)))
+	if err != nil {
+		t.Fatal("Could not parse feedback report:", err)
+	}
+	reportPart, err := parsed.FeedbackReportPart()
+	if err != nil {
+		t.Fatal("Could not find feedback report part:", err)
+	}
+	parsedReport, err := reportPart.FeedbackReport()
+	if err != nil {
+		t.Fatal("Could not parse feedback report:", err)
+	}
+	if parsedReport.FeedbackType != "abuse" || parsedReport.OriginalMailFrom != "sender@host.com" ||
+		!reflect.DeepEqual(parsedReport.OriginalRcptTo, report.OriginalRcptTo) || !parsedReport.SourceIP.Equal(report.SourceIP) {
+		t.Fatal("Feedback report does not match its parsed counterpart:", parsedReport)
+	}
+	if parsedOriginal, err := parsed.FeedbackReportOriginal(); err != nil ||
+		parsedOriginal.Header.Get("Message-Id") != original.Header.Get("Message-Id") || len(parsedOriginal.Body) != 0 {
+		t.Fatal("Could not find original message headers:", err)
+	}
+}
+
+// TestDispositionNotificationCreation ...
+func TestDispositionNotificationCreation(t *testing.T) {
+	t.Parallel()
+
+	header := NewHeader("sender@host.com", "Please Read", "reader@host.org")
+	header.SetDispositionNotificationTo("Sender Name <sender@host.com>")
+	original := NewMessage(header, "text", "<b>html</b>")
+	if err := original.Save(); err != nil {
+		t.Fatal("Could not save original:", err)
+	}
+
+	mdn, err := NewDispositionNotificationMessage(original, "reader@host.org", "Your message was displayed.",
+		&DispositionNotification{
+			ReportingUA: "host.org; go-email",
+			Disposition: Disposition{ActionMode: "manual-action", SendingMode: "MDN-sent-manually", Type: "displayed"},
+		})
+	if err != nil {
+		t.Fatal("Could not create disposition notification:", err)
+	}
+	if !confirmValidHeader(mdn.Header) || !confirmHasParts(mdn, 3, false, false) ||
+		!reflect.DeepEqual(mdn.Header.To(), []string{"Sender Name <sender@host.com>"}) ||
+		mdn.Header.Get("In-Reply-To") != original.Header.Get("Message-Id") {
+		t.Fatal("Message does not match expected structure")
+	}
+
+	parsed, err := ParseMessage(bytes.NewReader(testMessageAgainstSelf(t, mdn)))
+	if err != nil {
+		t.Fatal("Could not parse disposition notification:", err)
+	}
+	part, err := parsed.DispositionNotificationPart()
+	if err != nil {
+		t.Fatal("Could not find disposition notification part:", err)
+	}
+	notification, err := part.DispositionNotification()
+	if err != nil {
+		t.Fatal("Could not parse disposition notification:", err)
+	}
+	if notification.FinalRecipient != "rfc822; reader@host.org" ||
+		notification.OriginalMessageID != original.Header.Get("Message-Id") ||
+		notification.Disposition.String() != "manual-action/MDN-sent-manually; displayed" {
+		t.Fatal("Disposition notification does not match expected values:", notification)
+	}
+
+	if _, err = NewDispositionNotificationMessage(mdn, "reader@host.org", "", &DispositionNotification{}); err == nil {
+		t.Fatal("Should not create a disposition notification for a message that did not request one")
+	}
+
+	for _, disposition := range []Disposition{
+		{},
+		{ActionMode: "manual-action", SendingMode: "MDN-sent-manually"},
+		{Type: "displayed"},
+	} {
+		if _, err = NewDispositionNotificationMessage(original, "reader@host.org", "", &DispositionNotification{Disposition: disposition}); err != ErrDispositionMissing {
+			t.Fatal("Should not create a disposition notification without a disposition:", disposition, err)
+		}
+	}
+}
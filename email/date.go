@@ -0,0 +1,260 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package email
+
+import (
+	"errors"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dateZones are the obsolete zone names of RFC 5322, and other common zone names
+// found in real mail, with their offsets in hours.
+var dateZones = map[string]float64{
+	"UT": 0, "UTC": 0, "GMT": 0, "Z": 0, "WET": 0,
+	"EST": -5, "EDT": -4, "CST": -6, "CDT": -5, "MST": -7, "MDT": -6, "PST": -8, "PDT": -7,
+	"AST": -4, "ADT": -3, "AKST": -9, "AKDT": -8, "HST": -10, "NST": -3.5, "NDT": -2.5,
+	"BST": 1, "CET": 1, "MET": 1, "WEST": 1, "CEST": 2, "MEST": 2, "EET": 2, "EEST": 3, "MSK": 3,
+	"SGT": 8, "HKT": 8, "AWST": 8, "JST": 9, "KST": 9, "ACST": 9.5, "AEST": 10, "AEDT": 11,
+	"NZST": 12, "NZDT": 13,
+}
+
+// dateLayouts are the formats, other than RFC 5322, that clients commonly use instead.
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02 15:04:05",
+}
+
+// ParseDate parses an RFC 5322 date, such as from a Date header.
+// Unlike mail.ParseDate, it is lenient toward the many malformed dates found in real mail:
+// it allows obsolete and other common zone names, a missing day-of-week, seconds or zone,
+// two and three digit years, comments, extra or missing whitespace and commas,
+// the day and month in either order, am/pm times, and ISO 8601 dates.
+// Dates without a zone are assumed to be UTC.
+func ParseDate(date string) (time.Time, error) {
+	if parsed, err := mail.ParseDate(date); err == nil {
+		// mail.ParseDate gives zone names it does not know, such as "EST", an offset of zero
+		if name, offset := parsed.Zone(); offset != 0 || dateZones[name] == 0 {
+			return parsed, nil
+		}
+	}
+	cleaned := strings.TrimSpace(removeComments(date))
+	for _, layout := range dateLayouts {
+		if parsed, err := time.Parse(layout, cleaned); err == nil {
+			return parsed, nil
+		}
+	}
+
+	d := &dateParts{year: -1, hour: -1}
+	for _, token := range strings.Fields(strings.Replace(cleaned, ",", " ", -1)) {
+		d.parseToken(token)
+	}
+	return d.time(date)
+}
+
+// dateParts are the parts of a date found by ParseDate, in any order.
+type dateParts struct {
+	day, month, year     int
+	hour, minute, second int
+	pm, am               bool
+	zone                 *time.Location
+	numericZone          bool
+}
+
+// parseToken ...
+func (d *dateParts) parseToken(token string) {
+	upper := strings.ToUpper(token)
+	switch {
+	case token[0] == '+' || token[0] == '-':
+		if zone, ok := parseZoneOffset(token, token); ok {
+			d.zone, d.numericZone = zone, true
+		}
+
+	case (strings.HasPrefix(upper, "GMT") || strings.HasPrefix(upper, "UTC")) && len(token) > 4 && (token[3] == '+' || token[3] == '-'):
+		if zone, ok := parseZoneOffset(token[3:], token); ok {
+			d.zone, d.numericZone = zone, true
+		}
+
+	case strings.Contains(token, ":") && d.hour < 0:
+		d.parseTime(upper)
+
+	case isDigits(token):
+		n, _ := strconv.Atoi(token)
+		if d.day == 0 && len(token) <= 2 && n >= 1 && n <= 31 {
+			d.day = n
+		} else if d.year < 0 {
+			d.year = fixYear(n, len(token))
+		}
+
+	case len(token) == 10 && token[4] == '-' && token[7] == '-':
+		// An ISO 8601 date, followed by a separate time
+		year, errYear := strconv.Atoi(token[:4])
+		month, errMonth := strconv.Atoi(token[5:7])
+		day, errDay := strconv.Atoi(token[8:])
+		if errYear == nil && errMonth == nil && errDay == nil {
+			d.year, d.month, d.day = year, month, day
+		}
+
+	case strings.Count(token, "-") == 2:
+		// Such as "07-Mar-2016"
+		for _, part := range strings.Split(token, "-") {
+			if len(part) > 0 {
+				d.parseToken(part)
+			}
+		}
+
+	case upper == "AM" || upper == "A.M.":
+		d.am = true
+
+	case upper == "PM" || upper == "P.M.":
+		d.pm = true
+
+	case d.month == 0 && monthNumber(upper) > 0:
+		d.month = monthNumber(upper)
+
+	default:
+		if offset, ok := dateZones[upper]; ok && !d.numericZone {
+			d.zone = time.FixedZone(upper, int(offset*3600))
+		} else if len(upper) == 1 && upper[0] >= 'A' && upper[0] <= 'Z' && !d.numericZone {
+			// RFC 5322 says military zones were so often wrong that they should be treated as unknown
+			d.zone = time.FixedZone("-0000", 0)
+		}
+		// Otherwise a day-of-week, or noise such as "at"
+	}
+}
+
+// parseTime parses "hh:mm", "hh:mm:ss", or "hh:mm:ss.fraction", optionally followed by "am" or "pm".
+func (d *dateParts) parseTime(token string) {
+	if strings.HasSuffix(token, "AM") {
+		d.am = true
+		token = token[:len(token)-2]
+	} else if strings.HasSuffix(token, "PM") {
+		d.pm = true
+		token = token[:len(token)-2]
+	}
+	if idx := strings.IndexByte(token, '.'); idx >= 0 {
+		token = token[:idx]
+	}
+	fields := strings.Split(token, ":")
+	if len(fields) < 2 || len(fields) > 3 {
+		return
+	}
+	var values [3]int
+	for idx, field := range fields {
+		value, err := strconv.Atoi(field)
+		if err != nil || len(field) == 0 || len(field) > 2 {
+			return
+		}
+		values[idx] = value
+	}
+	d.hour, d.minute, d.second = values[0], values[1], values[2]
+}
+
+// time returns the parsed date, or an error if it is incomplete or invalid.
+func (d *dateParts) time(original string) (time.Time, error) {
+	invalid := errors.New("Unable to parse date: " + original)
+	if d.day == 0 || d.month == 0 || d.year < 0 {
+		return time.Time{}, invalid
+	}
+	if d.hour < 0 {
+		d.hour = 0
+	}
+	if d.pm && d.hour < 12 {
+		d.hour += 12
+	} else if d.am && d.hour == 12 {
+		d.hour = 0
+	}
+	if d.second == 60 {
+		// A leap second
+		d.second = 59
+	}
+	if d.hour > 23 || d.minute > 59 || d.second > 59 {
+		return time.Time{}, invalid
+	}
+	if d.zone == nil {
+		d.zone = time.UTC
+	}
+	t := time.Date(d.year, time.Month(d.month), d.day, d.hour, d.minute, d.second, 0, d.zone)
+	if t.Day() != d.day {
+		// Such as February 30th
+		return time.Time{}, invalid
+	}
+	return t, nil
+}
+
+// parseZoneOffset parses a numeric zone offset: "+hhmm", "+hh:mm", "+hh", or "+h".
+func parseZoneOffset(offset string, name string) (*time.Location, bool) {
+	sign := 1
+	if offset[0] == '-' {
+		sign = -1
+	}
+	digits := strings.Replace(offset[1:], ":", "", 1)
+	if !isDigits(digits) {
+		return nil, false
+	}
+	var hours, minutes int
+	switch len(digits) {
+	case 1, 2:
+		hours, _ = strconv.Atoi(digits)
+	case 3, 4:
+		hours, _ = strconv.Atoi(digits[:len(digits)-2])
+		minutes, _ = strconv.Atoi(digits[len(digits)-2:])
+	default:
+		return nil, false
+	}
+	if hours > 14 || minutes > 59 {
+		return nil, false
+	}
+	return time.FixedZone(name, sign*(hours*3600+minutes*60)), true
+}
+
+// monthNumber returns the month (1-12) of an upper case English month name
+// or abbreviation (of at least 3 letters, such as "SEP." or "SEPT"), or 0.
+func monthNumber(name string) int {
+	name = strings.TrimSuffix(name, ".")
+	if len(name) < 3 {
+		return 0
+	}
+	for month := time.January; month <= time.December; month++ {
+		if strings.HasPrefix(strings.ToUpper(month.String()), name) {
+			return int(month)
+		}
+	}
+	return 0
+}
+
+// fixYear interprets two and three digit years as RFC 5322 does for obsolete dates.
+func fixYear(year int, digits int) int {
+	switch {
+	case digits <= 2 && year < 50:
+		return year + 2000
+	case digits <= 3 && year < 1000:
+		return year + 1900
+	}
+	return year
+}
+
+// isDigits returns true if the string is made of only ASCII digits, and is not empty.
+func isDigits(s string) bool {
+	for idx := 0; idx < len(s); idx++ {
+		if s[idx] < '0' || s[idx] > '9' {
+			return false
+		}
+	}
+	return len(s) > 0
+}
+
+// receivedDate returns the date of a Received header, which follows its last ";".
+func receivedDate(received string) (time.Time, error) {
+	idx := strings.LastIndexByte(received, ';')
+	if idx < 0 {
+		return time.Time{}, errors.New("Received header does not have a date: " + received)
+	}
+	return ParseDate(received[idx+1:])
+}
@@ -0,0 +1,63 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package email
+
+import (
+	"testing"
+	"time"
+)
+
+// TestParseDate ...
+func TestParseDate(t *testing.T) {
+	t.Parallel()
+
+	expected := time.Date(2016, time.March, 7, 14, 5, 9, 0, time.UTC)
+	testCases := []string{
+		"Mon, 07 Mar 2016 14:05:09 +0000",
+		"Mon, 7 Mar 2016 09:05:09 -0500 (EST)",
+		"7 Mar 2016 09:05:09 EST",
+		"Mon, 07 Mar 16 14:05:09 GMT",
+		"Monday, 07-Mar-2016 14:05:09 UTC",
+		"Mon,07 Mar 2016 15:05:09 +0100",
+		"Mon, 07 Mar 2016 15:05:09 CET",
+		"Mon, 07 Mar 2016 14:05:09",
+		"Mon, 07 Mar 2016 14:05:09.123 +0000",
+		"Mon, 07 March 2016 2:05:09 PM +0000",
+		"Mon, 07 Mar 2016 16:05:09 GMT+02:00",
+		"Mon, 07 Mar 2016 14:05:09 +0000 (Coordinated Universal Time)",
+		"Mon Mar  7 14:05:09 2016",
+		"Mar 7, 2016 14:05:09 +0000",
+		"2016-03-07T14:05:09Z",
+		"2016-03-07 15:05:09 +0100",
+		"Mon, 07 Mar 2016 14:05:09 Z",
+	}
+	for _, tc := range testCases {
+		parsed, err := ParseDate(tc)
+		if err != nil {
+			t.Errorf("Could not parse %q: %v", tc, err)
+		} else if !parsed.Truncate(time.Second).Equal(expected) {
+			t.Errorf("Parsed %q as %v; expected %v", tc, parsed, expected)
+		}
+	}
+
+	if parsed, err := ParseDate("Sat, 1 Jan 99 00:00:00 +0000"); err != nil || parsed.Year() != 1999 {
+		t.Errorf("Expected a two digit year to be in 1999; got %v %v", parsed, err)
+	}
+
+	for _, tc := range []string{"", "yesterday", "Mon, 30 Feb 2016 14:05:09 +0000", "Mon, 07 Mar 2016 25:05:09 +0000"} {
+		if parsed, err := ParseDate(tc); err == nil {
+			t.Errorf("Expected %q to be invalid; got %v", tc, parsed)
+		}
+	}
+
+	// Fall back to the Received header when the Date is missing or broken
+	h := Header{}
+	h.Add("Received", "from mx.example.com by mail.example.com; Mon, 07 Mar 2016 14:05:09 +0000")
+	h.Add("Received", "from localhost by mx.example.com; Mon, 07 Mar 2016 14:00:00 +0000")
+	h.Set("Date", "not a date")
+	if parsed, err := h.Date(); err != nil || !parsed.Equal(expected) {
+		t.Errorf("Expected the Received date; got %v %v", parsed, err)
+	}
+}
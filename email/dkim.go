@@ -0,0 +1,366 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package email
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DKIMCanonicalization is a DKIM canonicalization algorithm, as defined by RFC 6376.
+type DKIMCanonicalization string
+
+const (
+	// DKIMSimple tolerates almost no modification of the header or body.
+	DKIMSimple DKIMCanonicalization = "simple"
+
+	// DKIMRelaxed tolerates common modifications, such as whitespace changes and header re-folding.
+	DKIMRelaxed DKIMCanonicalization = "relaxed"
+)
+
+// DefaultDKIMHeaders are the header fields signed by a DKIMSigner, if present,
+// when its Headers are not set.
+var DefaultDKIMHeaders = []string{
+	"From", "Reply-To", "Sender", "To", "Cc", "Subject", "Date", "Message-Id",
+	"In-Reply-To", "References", "MIME-Version", "Content-Type", "Content-Transfer-Encoding",
+	"List-Id", "List-Unsubscribe", "List-Unsubscribe-Post",
+}
+
+// MessageSigner signs the exact bytes of a written out message, returning the signed message,
+// such as with a DKIM-Signature header field prepended.
+type MessageSigner interface {
+	Sign(message []byte) ([]byte, error)
+}
+
+// DKIMSigner creates DKIM-Signature header fields, as defined by RFC 6376,
+// with RSA-SHA256 or Ed25519-SHA256 (RFC 8463) signatures.
+// Its public key must be published in DNS as a TXT record at "Selector._domainkey.Domain".
+type DKIMSigner struct {
+	// Domain is the signing domain (d=), such as "example.com".
+	Domain string
+
+	// Selector is the name of the key (s=) within the Domain.
+	Selector string
+
+	// Key is the private key, either an *rsa.PrivateKey or an ed25519.PrivateKey.
+	Key crypto.Signer
+
+	// Identity is the optional agent or user identity (i=), such as "@mail.example.com".
+	Identity string
+
+	// HeaderCanonicalization is the canonicalization of the header. Defaults to DKIMRelaxed.
+	HeaderCanonicalization DKIMCanonicalization
+
+	// BodyCanonicalization is the canonicalization of the body. Defaults to DKIMRelaxed.
+	BodyCanonicalization DKIMCanonicalization
+
+	// Headers are the header fields to sign, if present. Defaults to DefaultDKIMHeaders.
+	// The From field is always signed.
+	Headers []string
+
+	// Oversign are header fields to sign one more time than they are present,
+	// so that no further instances of them may be added without breaking the signature.
+	Oversign []string
+
+	// BodyLength adds the length of the signed body (l=), allowing content to be appended
+	// (such as by mailing lists) without breaking the signature, which has security risks.
+	BodyLength bool
+
+	// Expiration is how long the signature is valid for (x=). If zero, it does not expire.
+	Expiration time.Duration
+
+	// Now returns the current time, for the timestamp (t=). If nil, time.Now is used.
+	Now func() time.Time
+}
+
+// Sign returns the message with a DKIM-Signature header field prepended.
+// The message must already be written out, such as by Message.Bytes,
+// and must not be modified afterward.
+func (s *DKIMSigner) Sign(message []byte) ([]byte, error) {
+	if len(s.Domain) == 0 || len(s.Selector) == 0 {
+		return nil, errors.New("DKIM signing requires a Domain and Selector")
+	}
+	algorithm, err := dkimAlgorithm(s.Key)
+	if err != nil {
+		return nil, err
+	}
+	headerCanon := dkimDefaultCanonicalization(s.HeaderCanonicalization)
+	bodyCanon := dkimDefaultCanonicalization(s.BodyCanonicalization)
+
+	fields, body := splitRawMessage(message)
+	canonicalBody := canonicalizeDKIMBody(body, bodyCanon)
+	bodyHash := sha256.Sum256(canonicalBody)
+
+	signedNames := s.signedHeaderNames(fields)
+	if len(signedNames) == 0 || !strings.EqualFold(signedNames[0], "From") {
+		return nil, errors.New("DKIM signing requires a From header field")
+	}
+
+	now := time.Now
+	if s.Now != nil {
+		now = s.Now
+	}
+	timestamp := now().Unix()
+
+	tags := []string{
+		"v=1",
+		"a=" + algorithm,
+		"c=" + string(headerCanon) + "/" + string(bodyCanon),
+		"d=" + s.Domain,
+		"s=" + s.Selector,
+	}
+	if len(s.Identity) > 0 {
+		tags = append(tags, "i="+s.Identity)
+	}
+	tags = append(tags, "t="+strconv.FormatInt(timestamp, 10))
+	if s.Expiration > 0 {
+		tags = append(tags, "x="+strconv.FormatInt(timestamp+int64(s.Expiration/time.Second), 10))
+	}
+	if s.BodyLength {
+		tags = append(tags, "l="+strconv.Itoa(len(canonicalBody)))
+	}
+	tags = append(tags,
+		"h="+strings.Join(signedNames, ":"),
+		"bh="+base64.StdEncoding.EncodeToString(bodyHash[:]))
+
+	field, err := signDKIMField(s.Key, "DKIM-Signature", tags, canonicalizeDKIMHeaders(fields, signedNames, headerCanon), headerCanon)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(field), message...), nil
+}
+
+// dkimAlgorithm returns the DKIM signing algorithm (a=) for the private key.
+func dkimAlgorithm(key crypto.Signer) (string, error) {
+	switch key.(type) {
+	case *rsa.PrivateKey:
+		return "rsa-sha256", nil
+	case ed25519.PrivateKey:
+		return "ed25519-sha256", nil
+	}
+	return "", errors.New("DKIM signing requires an *rsa.PrivateKey or ed25519.PrivateKey")
+}
+
+// signDKIMField returns a signed DKIM-Signature (or ARC) header field with these tags, followed by b=,
+// signing the canonicalized header data followed by the field itself with an empty b= value.
+func signDKIMField(key crypto.Signer, name string, tags []string, signedData []byte, c DKIMCanonicalization) (string, error) {
+	unsigned := foldDKIMTags(name+": ", append(tags, "b="))
+	signedData = append(append([]byte(nil), signedData...), bytes.TrimSuffix(canonicalizeDKIMHeader([]byte(unsigned), c), []byte("\r\n"))...)
+	digest := sha256.Sum256(signedData)
+
+	// RSA signs the SHA-256 hash, while Ed25519 signs the SHA-256 hash as the message, without pre-hashing
+	hash := crypto.SHA256
+	if _, ok := key.(ed25519.PrivateKey); ok {
+		hash = crypto.Hash(0)
+	}
+	signature, err := key.Sign(rand.Reader, digest[:], hash)
+	if err != nil {
+		return "", err
+	}
+	return unsigned + foldBase64(base64.StdEncoding.EncodeToString(signature)) + "\r\n", nil
+}
+
+// signedHeaderNames returns the h= list: each configured header field once for every instance
+// present in the message, plus once more if it is oversigned, starting with From.
+func (s *DKIMSigner) signedHeaderNames(fields [][]byte) []string {
+	headers := s.Headers
+	if len(headers) == 0 {
+		headers = DefaultDKIMHeaders
+	}
+	headers = append([]string{"From"}, headers...)
+
+	counts := map[string]int{}
+	for _, field := range fields {
+		counts[strings.ToLower(rawHeaderName(field))]++
+	}
+	oversign := map[string]bool{}
+	for _, name := range s.Oversign {
+		oversign[strings.ToLower(name)] = true
+	}
+
+	var names []string
+	seen := map[string]bool{}
+	for _, name := range headers {
+		lower := strings.ToLower(name)
+		if seen[lower] {
+			continue
+		}
+		seen[lower] = true
+		count := counts[lower]
+		if oversign[lower] {
+			count++
+		}
+		for i := 0; i < count; i++ {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// dkimDefaultCanonicalization ...
+func dkimDefaultCanonicalization(c DKIMCanonicalization) DKIMCanonicalization {
+	if len(c) == 0 {
+		return DKIMRelaxed
+	}
+	return c
+}
+
+// splitRawMessage splits a written out message into its raw header fields
+// (each including any folded lines, and ending in CRLF), and its body.
+// Bare line feeds are treated as CRLF, as they will become CRLF when sent by SMTP.
+func splitRawMessage(message []byte) ([][]byte, []byte) {
+	message = bytes.Replace(bytes.Replace(message, []byte("\r\n"), []byte("\n"), -1), []byte("\n"), []byte("\r\n"), -1)
+	header := message
+	var body []byte
+	if bytes.HasPrefix(message, []byte("\r\n")) {
+		header, body = nil, message[2:]
+	} else if idx := bytes.Index(message, []byte("\r\n\r\n")); idx >= 0 {
+		header, body = message[:idx+2], message[idx+4:]
+	}
+
+	var fields [][]byte
+	for len(header) > 0 {
+		end := bytes.Index(header, []byte("\r\n"))
+		if end < 0 {
+			end = len(header)
+		} else {
+			end += 2
+		}
+		line := header[:end]
+		header = header[end:]
+		if len(fields) > 0 && (line[0] == ' ' || line[0] == '\t') {
+			fields[len(fields)-1] = append(fields[len(fields)-1], line...)
+		} else {
+			fields = append(fields, append([]byte(nil), line...))
+		}
+	}
+	return fields, body
+}
+
+// rawHeaderName returns the name of a raw header field.
+func rawHeaderName(field []byte) string {
+	if idx := bytes.IndexByte(field, ':'); idx >= 0 {
+		return strings.TrimRight(string(field[:idx]), " \t")
+	}
+	return ""
+}
+
+// canonicalizeDKIMHeaders returns the canonicalized header fields named in the h= list.
+// Each name selects the last instance of that field not yet selected, and selects
+// nothing if there are no more instances, as required for oversigning.
+func canonicalizeDKIMHeaders(fields [][]byte, names []string, c DKIMCanonicalization) []byte {
+	used := map[int]bool{}
+	var canonical []byte
+	for _, name := range names {
+		for idx := len(fields) - 1; idx >= 0; idx-- {
+			if !used[idx] && strings.EqualFold(rawHeaderName(fields[idx]), name) {
+				used[idx] = true
+				canonical = append(canonical, canonicalizeDKIMHeader(fields[idx], c)...)
+				break
+			}
+		}
+	}
+	return canonical
+}
+
+// canonicalizeDKIMHeader canonicalizes a single raw header field, ending in CRLF.
+func canonicalizeDKIMHeader(field []byte, c DKIMCanonicalization) []byte {
+	if c == DKIMSimple {
+		return field
+	}
+	idx := bytes.IndexByte(field, ':')
+	if idx < 0 {
+		return field
+	}
+	name := strings.ToLower(strings.TrimRight(string(field[:idx]), " \t"))
+	value := bytes.Replace(field[idx+1:], []byte("\r\n"), nil, -1)
+	return []byte(name + ":" + strings.TrimSpace(collapseWhitespace(string(value))) + "\r\n")
+}
+
+// canonicalizeDKIMBody canonicalizes a body, whose lines end in CRLF.
+func canonicalizeDKIMBody(body []byte, c DKIMCanonicalization) []byte {
+	if c == DKIMRelaxed {
+		lines := strings.Split(string(body), "\r\n")
+		for idx := range lines {
+			lines[idx] = strings.TrimRight(collapseWhitespace(lines[idx]), " ")
+		}
+		body = []byte(strings.Join(lines, "\r\n"))
+	}
+	for bytes.HasSuffix(body, []byte("\r\n")) {
+		body = body[:len(body)-2]
+	}
+	if len(body) == 0 {
+		if c == DKIMRelaxed {
+			return []byte{}
+		}
+		return []byte("\r\n")
+	}
+	return append(body, '\r', '\n')
+}
+
+// collapseWhitespace replaces each run of spaces and tabs with a single space.
+func collapseWhitespace(s string) string {
+	var b strings.Builder
+	space := false
+	for idx := 0; idx < len(s); idx++ {
+		if s[idx] == ' ' || s[idx] == '\t' {
+			space = true
+			continue
+		}
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		b.WriteByte(s[idx])
+	}
+	if space {
+		b.WriteByte(' ')
+	}
+	return b.String()
+}
+
+// foldDKIMTags joins the tags of a DKIM (or ARC) header field, folding lines before they get too long.
+func foldDKIMTags(prefix string, tags []string) string {
+	b := &strings.Builder{}
+	b.WriteString(prefix)
+	lineLen := len(prefix)
+	for idx, tag := range tags {
+		if idx > 0 {
+			if lineLen+len(tag)+2 > MaxBodyLineLength {
+				b.WriteString(";\r\n\t")
+				lineLen = 1
+			} else {
+				b.WriteString("; ")
+				lineLen += 2
+			}
+		}
+		b.WriteString(tag)
+		lineLen += len(tag)
+	}
+	return b.String()
+}
+
+// foldBase64 folds a long base64 value onto continuation lines.
+func foldBase64(value string) string {
+	b := &strings.Builder{}
+	for len(value) > 0 {
+		n := min(len(value), MaxBodyLineLength-1)
+		if b.Len() > 0 {
+			b.WriteString("\r\n\t")
+		}
+		b.WriteString(value[:n])
+		value = value[n:]
+	}
+	return b.String()
+}
@@ -0,0 +1,186 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package email
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"net"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The example message and key from RFC 8463, Appendix A
+const dkimTestMessage = "From: Joe SixPack <joe@football.example.com>\r\n" +
+	"To: Suzie Q <suzie@shopping.example.net>\r\n" +
+	"Subject: Is dinner ready?\r\n" +
+	"Date: Fri, 11 Jul 2003 21:00:37 -0700 (PDT)\r\n" +
+	"Message-ID: <20030712040037.46341.5F8J@football.example.com>\r\n" +
+	"\r\n" +
+	"Hi.\r\n\r\nWe lost the game.  Are you hungry yet?\r\n\r\nJoe.\r\n"
+
+const dkimTestSeed = "nWGxne/9WmC6hEr0kuwsxERJxWl7MmkZcDusAxyuf2A="
+
+// TestDKIMSign ...
+func TestDKIMSign(t *testing.T) {
+	t.Parallel()
+
+	seed, _ := base64.StdEncoding.DecodeString(dkimTestSeed)
+	ed25519Key := ed25519.NewKeyFromSeed(seed)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []crypto.Signer{ed25519Key, rsaKey} {
+		signer := &DKIMSigner{
+			Domain:     "football.example.com",
+			Selector:   "brisbane",
+			Key:        key,
+			Headers:    []string{"To", "Subject", "Date", "Message-ID", "Cc"},
+			Oversign:   []string{"From", "Subject"},
+			BodyLength: true,
+			Expiration: time.Hour,
+			Now:        func() time.Time { return time.Unix(1528637909, 0) },
+		}
+		signed, err := signer.Sign([]byte(dkimTestMessage))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasSuffix(signed, []byte(dkimTestMessage)) {
+			t.Fatal("Signing should only prepend a header field")
+		}
+
+		fields, _ := splitRawMessage(signed)
+		signature := string(canonicalizeDKIMHeader(fields[0], DKIMRelaxed))
+		for _, tag := range []string{"c=relaxed/relaxed", "d=football.example.com", "s=brisbane", "t=1528637909", "x=1528641509",
+			"l=54", "h=From:From:To:Subject:Subject:Date:Message-ID", "bh=2jUSOH9NhtVGCQWNr9BrIAPreKQjO6Sn7XIkfJVOzv8="} {
+			if !strings.Contains(signature, tag+";") {
+				t.Errorf("Expected tag %q in %q", tag, signature)
+			}
+		}
+
+		// Recompute the signed data, to confirm the signature is valid
+		names := strings.Split("From:From:To:Subject:Subject:Date:Message-ID", ":")
+		data := canonicalizeDKIMHeaders(fields[1:], names, DKIMRelaxed)
+		unsigned := regexp.MustCompile(`b=[^;]*$`).ReplaceAllString(strings.TrimSuffix(signature, "\r\n"), "b=")
+		data = append(data, unsigned...)
+		digest := sha256.Sum256(data)
+		b, _ := base64.StdEncoding.DecodeString(strings.Replace(regexp.MustCompile(`; b=(.*)\r\n$`).FindStringSubmatch(signature)[1], " ", "", -1))
+
+		switch key := key.(type) {
+		case ed25519.PrivateKey:
+			if !ed25519.Verify(key.Public().(ed25519.PublicKey), digest[:], b) {
+				t.Error("Ed25519 signature does not verify")
+			}
+		case *rsa.PrivateKey:
+			if err = rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], b); err != nil {
+				t.Error("RSA signature does not verify:", err)
+			}
+		}
+	}
+}
+
+// stubResolver is a TXTResolver with fixed records, and errors for any other name.
+type stubResolver map[string][]string
+
+func (r stubResolver) LookupTXT(name string) ([]string, error) {
+	if records, ok := r[name]; ok {
+		return records, nil
+	}
+	if name == "unavailable._domainkey.football.example.com" {
+		return nil, &net.DNSError{Err: "timeout", Name: name, IsTimeout: true}
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+// TestDKIMVerify ...
+func TestDKIMVerify(t *testing.T) {
+	t.Parallel()
+
+	// The signed example from RFC 8463, Appendix A.3
+	rfcSignature := "DKIM-Signature: v=1; a=ed25519-sha256; c=relaxed/relaxed;\r\n" +
+		" d=football.example.com; i=@football.example.com;\r\n" +
+		" q=dns/txt; s=brisbane; t=1528637909; h=from : to :\r\n" +
+		" subject : date : message-id : from : subject : date;\r\n" +
+		" bh=2jUSOH9NhtVGCQWNr9BrIAPreKQjO6Sn7XIkfJVOzv8=;\r\n" +
+		" b=/gCrinpcQOoIfuHNQIbq4pgh9kyIK3AQUdt9OdqQehSwhEIug4D11Bus\r\n" +
+		" Fa3bT3FY5OsU7ZbnKELq+eXdp1Q1Dw==\r\n"
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPublic, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolver := stubResolver{
+		"brisbane._domainkey.football.example.com": {"v=DKIM1; k=ed25519; p=11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo="},
+		"test._domainkey.football.example.com":     {"v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(rsaPublic)},
+		"revoked._domainkey.football.example.com":  {"v=DKIM1; p="},
+	}
+	now := time.Unix(1528637909, 0)
+	verifier := &DKIMVerifier{Resolver: resolver, Now: func() time.Time { return now }}
+
+	sign := func(selector string, key crypto.Signer, message string) string {
+		signer := &DKIMSigner{Domain: "football.example.com", Selector: selector, Key: key,
+			HeaderCanonicalization: DKIMSimple, BodyCanonicalization: DKIMSimple,
+			Expiration: time.Minute, Now: func() time.Time { return now }}
+		signed, err := signer.Sign([]byte(message))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(signed)
+	}
+	multiple := sign("test", rsaKey, rfcSignature+dkimTestMessage)
+
+	testCases := []struct {
+		message  string
+		expected []AuthResult
+		reason   string
+	}{
+		{rfcSignature + dkimTestMessage, []AuthResult{AuthResultPass}, ""},
+		{multiple, []AuthResult{AuthResultPass, AuthResultPass}, ""},
+		{strings.Replace(multiple, "Joe.", "Jim.", 1), []AuthResult{AuthResultFail, AuthResultFail}, "body hash did not verify"},
+		{strings.Replace(multiple, "dinner", "lunch", 1), []AuthResult{AuthResultFail, AuthResultFail}, "signature did not verify"},
+		{sign("missing", rsaKey, dkimTestMessage), []AuthResult{AuthResultPermError}, "no key for signature"},
+		{sign("revoked", rsaKey, dkimTestMessage), []AuthResult{AuthResultPermError}, "key has been revoked"},
+		{sign("unavailable", rsaKey, dkimTestMessage), []AuthResult{AuthResultTempError}, ""},
+		{sign("brisbane", rsaKey, dkimTestMessage), []AuthResult{AuthResultPermError}, "key type does not match the algorithm"},
+		{"DKIM-Signature: v=1; a=rsa-sha256; d=football.example.com; s=test\r\n" + dkimTestMessage, []AuthResult{AuthResultPermError}, "missing required tag b="},
+		{dkimTestMessage, nil, ""},
+	}
+
+	for idx, tc := range testCases {
+		verifications := verifier.Verify([]byte(tc.message))
+		var results []AuthResult
+		for _, verification := range verifications {
+			results = append(results, verification.Result)
+		}
+		if !reflect.DeepEqual(results, tc.expected) {
+			t.Errorf("Case %d: expected %v; got %v", idx, tc.expected, results)
+			continue
+		}
+		if len(tc.reason) > 0 && verifications[0].Reason != tc.reason {
+			t.Errorf("Case %d: expected reason %q; got %q", idx, tc.reason, verifications[0].Reason)
+		}
+	}
+
+	// Expired signatures are permanent errors
+	now = now.Add(time.Hour)
+	if verification := verifier.Verify([]byte(multiple))[0]; verification.Result != AuthResultPermError || verification.Domain != "football.example.com" || verification.Selector != "test" {
+		t.Errorf("Expected an expired signature; got %+v", verification)
+	}
+}
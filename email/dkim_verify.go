@@ -0,0 +1,384 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package email
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AuthResult is the result of a message authentication method, such as DKIM,
+// as used in Authentication-Results header fields.
+type AuthResult string
+
+const (
+	AuthResultNone      AuthResult = "none"
+	AuthResultPass      AuthResult = "pass"
+	AuthResultFail      AuthResult = "fail"
+	AuthResultTempError AuthResult = "temperror"
+	AuthResultPermError AuthResult = "permerror"
+	AuthResultNeutral   AuthResult = "neutral"
+	AuthResultSoftFail  AuthResult = "softfail"
+	AuthResultPolicy    AuthResult = "policy"
+)
+
+// dkimMinRSAKeyBits is the smallest RSA key allowed, as required by RFC 8301.
+const dkimMinRSAKeyBits = 1024
+
+// DKIMVerification is the result of verifying a single DKIM-Signature header field.
+type DKIMVerification struct {
+	// Result is pass, fail, temperror (such as when DNS is unavailable),
+	// or permerror (such as when the signature is malformed or its key is missing).
+	Result AuthResult
+
+	// Reason describes why the signature did not pass.
+	Reason string
+
+	// Domain is the signing domain (d=).
+	Domain string
+
+	// Selector is the name of the key (s=) within the Domain.
+	Selector string
+
+	// Identity is the agent or user identity (i=), which defaults to "@" + Domain.
+	Identity string
+
+	// Algorithm is the signing algorithm (a=), such as "rsa-sha256".
+	Algorithm string
+
+	// Headers are the names of the signed header fields (h=).
+	Headers []string
+
+	// Timestamp is when the message was signed (t=), if given.
+	Timestamp time.Time
+
+	// Expiration is when the signature expires (x=), if given.
+	Expiration time.Time
+}
+
+// DKIMVerifier verifies the DKIM-Signature header fields of messages, as defined by RFC 6376,
+// with RSA-SHA256 or Ed25519-SHA256 (RFC 8463) signatures.
+type DKIMVerifier struct {
+	// Resolver looks up public keys. If nil, DNSResolver is used.
+	Resolver TXTResolver
+
+	// Now returns the current time, for checking expiration. If nil, time.Now is used.
+	Now func() time.Time
+}
+
+// Verify verifies every DKIM-Signature header field of a message, returning the result of each,
+// in order from the top of the header. An empty slice is returned if there are no signatures.
+// The message must be the original raw bytes, as received, because ParseMessage
+// does not preserve the exact header and body bytes that were signed.
+func (v *DKIMVerifier) Verify(message []byte) []*DKIMVerification {
+	fields, body := splitRawMessage(message)
+	var verifications []*DKIMVerification
+	for idx, field := range fields {
+		if strings.EqualFold(rawHeaderName(field), "DKIM-Signature") {
+			verifications = append(verifications, v.verifySignature(fields, idx, body))
+		}
+	}
+	return verifications
+}
+
+// verifySignature verifies the DKIM-Signature at this index of the header fields.
+func (v *DKIMVerifier) verifySignature(fields [][]byte, idx int, body []byte) *DKIMVerification {
+	result := &DKIMVerification{}
+	tags, err := parseDKIMTags(rawHeaderValue(fields[idx]))
+	if err != nil {
+		return result.permError(err.Error())
+	}
+	result.Domain = tags["d"]
+	result.Selector = tags["s"]
+	result.Algorithm = tags["a"]
+	result.Identity = tags["i"]
+	if len(result.Identity) == 0 {
+		result.Identity = "@" + result.Domain
+	}
+	for _, name := range strings.Split(tags["h"], ":") {
+		if name = strings.TrimSpace(name); len(name) > 0 {
+			result.Headers = append(result.Headers, name)
+		}
+	}
+
+	for _, required := range []string{"v", "a", "b", "bh", "d", "h", "s"} {
+		if _, ok := tags[required]; !ok {
+			return result.permError("missing required tag " + required + "=")
+		}
+	}
+	if tags["v"] != "1" {
+		return result.permError("unsupported version " + tags["v"])
+	}
+	if !containsFold(result.Headers, "From") {
+		return result.permError("From header field is not signed")
+	}
+	if !dkimDomainMatches(identityDomain(result.Identity), result.Domain) {
+		return result.permError("identity is not within the signing domain")
+	}
+	headerCanon, bodyCanon, err := parseDKIMCanonicalization(tags["c"])
+	if err != nil {
+		return result.permError(err.Error())
+	}
+
+	now := time.Now
+	if v.Now != nil {
+		now = v.Now
+	}
+	if t, ok := tags["t"]; ok {
+		timestamp, err := strconv.ParseInt(t, 10, 64)
+		if err != nil {
+			return result.permError("invalid timestamp")
+		}
+		result.Timestamp = time.Unix(timestamp, 0)
+	}
+	if x, ok := tags["x"]; ok {
+		expiration, err := strconv.ParseInt(x, 10, 64)
+		if err != nil {
+			return result.permError("invalid expiration")
+		}
+		result.Expiration = time.Unix(expiration, 0)
+		if now().After(result.Expiration) {
+			return result.permError("signature has expired")
+		}
+	}
+
+	key, keyResult, reason := lookupDKIMKey(v.Resolver, result.Selector, result.Domain, result.Algorithm)
+	if key == nil {
+		result.Result, result.Reason = keyResult, reason
+		return result
+	}
+
+	canonicalBody := canonicalizeDKIMBody(body, bodyCanon)
+	if l, ok := tags["l"]; ok {
+		length, err := strconv.Atoi(l)
+		if err != nil || length < 0 {
+			return result.permError("invalid body length")
+		}
+		if length > len(canonicalBody) {
+			return result.fail("body is shorter than the signed body length")
+		}
+		canonicalBody = canonicalBody[:length]
+	}
+	bodyHash := sha256.Sum256(canonicalBody)
+	if base64.StdEncoding.EncodeToString(bodyHash[:]) != removeWhitespace(tags["bh"]) {
+		return result.fail("body hash did not verify")
+	}
+
+	signedData := canonicalizeDKIMHeaders(fields, result.Headers, headerCanon)
+	signedData = append(signedData, bytes.TrimSuffix(canonicalizeDKIMHeader(removeDKIMSignatureValue(fields[idx]), headerCanon), []byte("\r\n"))...)
+	if err = verifyDKIMSignature(key, signedData, tags["b"]); err != nil {
+		return result.fail(err.Error())
+	}
+	result.Result = AuthResultPass
+	return result
+}
+
+// permError ...
+func (r *DKIMVerification) permError(reason string) *DKIMVerification {
+	r.Result, r.Reason = AuthResultPermError, reason
+	return r
+}
+
+// fail ...
+func (r *DKIMVerification) fail(reason string) *DKIMVerification {
+	r.Result, r.Reason = AuthResultFail, reason
+	return r
+}
+
+// parseDKIMTags parses a tag=value list, as used by DKIM-Signature header fields and DKIM key records.
+func parseDKIMTags(list string) (map[string]string, error) {
+	tags := map[string]string{}
+	for _, tag := range strings.Split(list, ";") {
+		tag = strings.TrimSpace(tag)
+		if len(tag) == 0 {
+			continue
+		}
+		eq := strings.IndexByte(tag, '=')
+		if eq < 0 {
+			return nil, errors.New("malformed tag " + tag)
+		}
+		name := strings.TrimSpace(tag[:eq])
+		if _, ok := tags[name]; ok {
+			return nil, errors.New("duplicate tag " + name + "=")
+		}
+		tags[name] = strings.TrimSpace(tag[eq+1:])
+	}
+	return tags, nil
+}
+
+// parseDKIMCanonicalization parses the c= tag, which defaults to simple/simple.
+func parseDKIMCanonicalization(c string) (DKIMCanonicalization, DKIMCanonicalization, error) {
+	header, body := c, ""
+	if idx := strings.IndexByte(c, '/'); idx >= 0 {
+		header, body = c[:idx], c[idx+1:]
+	}
+	canons := []DKIMCanonicalization{DKIMSimple, DKIMSimple}
+	for idx, value := range []string{header, body} {
+		switch DKIMCanonicalization(value) {
+		case "":
+		case DKIMSimple, DKIMRelaxed:
+			canons[idx] = DKIMCanonicalization(value)
+		default:
+			return "", "", errors.New("unsupported canonicalization " + c)
+		}
+	}
+	return canons[0], canons[1], nil
+}
+
+// lookupDKIMKey looks up and parses the public key at selector._domainkey.domain.
+// If the key can not be used with the algorithm, it returns a nil key, with the result and reason.
+func lookupDKIMKey(resolver TXTResolver, selector string, domain string, algorithm string) (crypto.PublicKey, AuthResult, string) {
+	var keyType string
+	switch algorithm {
+	case "rsa-sha256":
+		keyType = "rsa"
+	case "ed25519-sha256":
+		keyType = "ed25519"
+	default:
+		return nil, AuthResultPermError, "unsupported algorithm " + algorithm
+	}
+
+	if resolver == nil {
+		resolver = DNSResolver{}
+	}
+	records, err := resolver.LookupTXT(selector + "._domainkey." + domain)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, AuthResultPermError, "no key for signature"
+		}
+		return nil, AuthResultTempError, "key unavailable: " + err.Error()
+	}
+	if len(records) == 0 {
+		return nil, AuthResultPermError, "no key for signature"
+	}
+
+	tags, err := parseDKIMTags(records[0])
+	if err != nil {
+		return nil, AuthResultPermError, "malformed key record: " + err.Error()
+	}
+	if v, ok := tags["v"]; ok && v != "DKIM1" {
+		return nil, AuthResultPermError, "unsupported key version " + v
+	}
+	if k, ok := tags["k"]; ok && k != keyType || !ok && keyType != "rsa" {
+		return nil, AuthResultPermError, "key type does not match the algorithm"
+	}
+	if h, ok := tags["h"]; ok && !containsFold(strings.Split(removeWhitespace(h), ":"), "sha256") {
+		return nil, AuthResultPermError, "key does not allow sha256"
+	}
+	p := removeWhitespace(tags["p"])
+	if len(p) == 0 {
+		return nil, AuthResultPermError, "key has been revoked"
+	}
+	der, err := base64.StdEncoding.DecodeString(p)
+	if err != nil {
+		return nil, AuthResultPermError, "malformed key"
+	}
+
+	if keyType == "ed25519" {
+		if len(der) != ed25519.PublicKeySize {
+			return nil, AuthResultPermError, "malformed key"
+		}
+		return ed25519.PublicKey(der), "", ""
+	}
+	var rsaKey *rsa.PublicKey
+	if parsed, err := x509.ParsePKIXPublicKey(der); err == nil {
+		rsaKey, _ = parsed.(*rsa.PublicKey)
+	} else if parsed, err := x509.ParsePKCS1PublicKey(der); err == nil {
+		rsaKey = parsed
+	}
+	if rsaKey == nil {
+		return nil, AuthResultPermError, "malformed key"
+	}
+	if rsaKey.N.BitLen() < dkimMinRSAKeyBits {
+		return nil, AuthResultPermError, "key is too short"
+	}
+	return rsaKey, "", ""
+}
+
+// verifyDKIMSignature verifies the base64 signature of the SHA-256 hash of the signed data.
+func verifyDKIMSignature(key crypto.PublicKey, signedData []byte, b string) error {
+	signature, err := base64.StdEncoding.DecodeString(removeWhitespace(b))
+	if err != nil {
+		return errors.New("malformed signature")
+	}
+	digest := sha256.Sum256(signedData)
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		err = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature)
+	case ed25519.PublicKey:
+		if !ed25519.Verify(key, digest[:], signature) {
+			err = errors.New("invalid")
+		}
+	}
+	if err != nil {
+		return errors.New("signature did not verify")
+	}
+	return nil
+}
+
+// rawHeaderValue returns the unfolded value of a raw header field.
+func rawHeaderValue(field []byte) string {
+	idx := bytes.IndexByte(field, ':')
+	if idx < 0 {
+		return ""
+	}
+	return string(bytes.Replace(field[idx+1:], []byte("\r\n"), nil, -1))
+}
+
+// removeDKIMSignatureValue returns the raw header field with the value of its b= tag removed,
+// leaving every other byte as it was, for computing the signed data.
+func removeDKIMSignatureValue(field []byte) []byte {
+	field = bytes.TrimSuffix(field, []byte("\r\n"))
+	start := bytes.IndexByte(field, ':') + 1
+	for start > 0 && start <= len(field) {
+		end := bytes.IndexByte(field[start:], ';')
+		if end < 0 {
+			end = len(field)
+		} else {
+			end += start
+		}
+		if eq := bytes.IndexByte(field[start:end], '='); eq >= 0 && strings.TrimSpace(string(field[start:start+eq])) == "b" {
+			removed := append(append([]byte(nil), field[:start+eq+1]...), field[end:]...)
+			return append(removed, '\r', '\n')
+		}
+		start = end + 1
+	}
+	return append(field, '\r', '\n')
+}
+
+// identityDomain returns the domain part of an identity, such as "user@mail.example.com".
+func identityDomain(identity string) string {
+	return identity[strings.LastIndexByte(identity, '@')+1:]
+}
+
+// dkimDomainMatches returns true if the domain is the same as, or a subdomain of, the parent domain.
+func dkimDomainMatches(domain string, parent string) bool {
+	domain, parent = strings.ToLower(domain), strings.ToLower(parent)
+	return domain == parent || strings.HasSuffix(domain, "."+parent)
+}
+
+// containsFold returns true if the slice contains the string, ignoring case.
+func containsFold(slice []string, s string) bool {
+	for _, item := range slice {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// removeWhitespace removes all whitespace, such as folding within a base64 value.
+func removeWhitespace(s string) string {
+	return strings.Join(strings.Fields(s), "")
+}
@@ -0,0 +1,325 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package email
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DMARCPolicy is what a domain asks receivers to do with messages that fail DMARC.
+type DMARCPolicy string
+
+const (
+	DMARCPolicyNone       DMARCPolicy = "none"
+	DMARCPolicyQuarantine DMARCPolicy = "quarantine"
+	DMARCPolicyReject     DMARCPolicy = "reject"
+)
+
+// dmarcPublicSuffixes are common public suffixes with more than one label,
+// used to find organizational domains when no PublicSuffix function is given.
+var dmarcPublicSuffixes = map[string]bool{
+	"ac.uk": true, "co.uk": true, "gov.uk": true, "ltd.uk": true, "me.uk": true, "net.uk": true, "org.uk": true, "plc.uk": true,
+	"com.au": true, "edu.au": true, "gov.au": true, "net.au": true, "org.au": true,
+	"co.nz": true, "net.nz": true, "org.nz": true, "co.za": true, "org.za": true,
+	"co.jp": true, "ne.jp": true, "or.jp": true, "ac.jp": true, "co.kr": true, "or.kr": true,
+	"com.br": true, "net.br": true, "org.br": true, "com.mx": true, "com.ar": true, "com.co": true,
+	"com.cn": true, "net.cn": true, "org.cn": true, "com.hk": true, "com.tw": true, "com.sg": true,
+	"co.in": true, "net.in": true, "org.in": true, "co.id": true, "com.my": true, "com.ph": true,
+	"com.tr": true, "co.il": true, "com.ua": true, "com.pl": true, "co.th": true, "com.vn": true,
+}
+
+// DMARCRecord is the DMARC policy record of a domain, as published at "_dmarc." followed by the domain.
+type DMARCRecord struct {
+	// Policy is the policy for the domain (p=).
+	Policy DMARCPolicy
+
+	// SubdomainPolicy is the policy for subdomains without their own record (sp=),
+	// which defaults to the Policy.
+	SubdomainPolicy DMARCPolicy
+
+	// StrictDKIM is true if DKIM signing domains must exactly match the From domain (adkim=s),
+	// rather than share its organizational domain.
+	StrictDKIM bool
+
+	// StrictSPF is true if the SPF domain must exactly match the From domain (aspf=s),
+	// rather than share its organizational domain.
+	StrictSPF bool
+
+	// Percent is the percentage of failing messages to apply the policy to (pct=), defaulting to 100.
+	// The rest should be treated as if the policy were one level weaker.
+	Percent int
+
+	// AggregateReportURIs are where to send aggregate reports (rua=).
+	AggregateReportURIs []string
+
+	// FailureReportURIs are where to send failure reports (ruf=).
+	FailureReportURIs []string
+
+	// FailureReportOptions are when to send failure reports (fo=), defaulting to "0".
+	FailureReportOptions string
+
+	// ReportInterval is how often to send aggregate reports (ri=), defaulting to a day.
+	ReportInterval time.Duration
+}
+
+// DMARCVerification is the result of evaluating the DMARC policy of a message's From domain.
+type DMARCVerification struct {
+	// Result is none (the domain has no DMARC record), pass (an aligned SPF or DKIM identity passed),
+	// fail, temperror (such as when DNS is unavailable), or permerror (such as when the From is invalid).
+	Result AuthResult
+
+	// Reason describes why the message did not pass.
+	Reason string
+
+	// FromDomain is the domain of the From header field.
+	FromDomain string
+
+	// PolicyDomain is the domain the DMARC record was found at:
+	// the FromDomain, or else its organizational domain.
+	PolicyDomain string
+
+	// Record is the DMARC record, if any.
+	Record *DMARCRecord
+
+	// Policy is the policy requested for this message, if it failed:
+	// the record's SubdomainPolicy if it was found at the organizational domain, or else its Policy.
+	// Receivers should apply it to only the record's Percent of failing messages.
+	Policy DMARCPolicy
+
+	// DKIMAligned is true if a passing DKIM signature's domain is aligned with the FromDomain.
+	DKIMAligned bool
+
+	// SPFAligned is true if SPF passed, and its domain is aligned with the FromDomain.
+	SPFAligned bool
+}
+
+// DMARCVerifier evaluates the DMARC policy of messages, as defined by RFC 7489.
+type DMARCVerifier struct {
+	// Resolver looks up DMARC records. If nil, DNSResolver is used.
+	Resolver TXTResolver
+
+	// PublicSuffix returns the public suffix of a domain, such as "co.uk" for "mail.example.co.uk",
+	// used to find organizational domains. It should use the Public Suffix List, such as with
+	// golang.org/x/net/publicsuffix. If nil, a small list of common suffixes is used.
+	PublicSuffix func(domain string) string
+}
+
+// Verify evaluates the DMARC policy of the message's From domain, given the results of
+// verifying its DKIM signatures and checking its SPF (either of which may be nil).
+func (v *DMARCVerifier) Verify(m *Message, dkim []*DKIMVerification, spf *SPFVerification) *DMARCVerification {
+	verification := &DMARCVerification{Result: AuthResultNone}
+	fromDomain, err := dmarcFromDomain(m.Header)
+	if err != nil {
+		verification.Result, verification.Reason = AuthResultPermError, err.Error()
+		return verification
+	}
+	verification.FromDomain = fromDomain
+
+	// Use the From domain's record, or else its organizational domain's record
+	domains := []string{fromDomain}
+	if orgDomain := v.OrganizationalDomain(fromDomain); orgDomain != fromDomain {
+		domains = append(domains, orgDomain)
+	}
+	for _, domain := range domains {
+		record, result, reason := v.lookupRecord(domain)
+		if len(result) > 0 {
+			verification.Result, verification.Reason = result, reason
+			return verification
+		}
+		if record != nil {
+			verification.Record, verification.PolicyDomain = record, domain
+			break
+		}
+	}
+	if verification.Record == nil {
+		verification.Reason = "no DMARC record for " + fromDomain
+		return verification
+	}
+	record := verification.Record
+
+	for _, signature := range dkim {
+		if signature.Result == AuthResultPass && v.aligned(signature.Domain, fromDomain, record.StrictDKIM) {
+			verification.DKIMAligned = true
+		}
+	}
+	if spf != nil && spf.Result == AuthResultPass && v.aligned(spf.Domain, fromDomain, record.StrictSPF) {
+		verification.SPFAligned = true
+	}
+	if verification.DKIMAligned || verification.SPFAligned {
+		verification.Result = AuthResultPass
+		return verification
+	}
+
+	verification.Result, verification.Reason = AuthResultFail, "no aligned DKIM or SPF identity passed"
+	verification.Policy = record.Policy
+	if verification.PolicyDomain != fromDomain {
+		verification.Policy = record.SubdomainPolicy
+	}
+	return verification
+}
+
+// AuthenticationResult returns this verification as a method result for an
+// Authentication-Results header field, such as "dmarc=fail (p=reject) header.from=example.com".
+func (v *DMARCVerification) AuthenticationResult() *AuthenticationResult {
+	result := &AuthenticationResult{Method: "dmarc", Result: v.Result, Reason: v.Reason}
+	if len(v.Policy) > 0 {
+		result.Comment = "p=" + string(v.Policy)
+	}
+	result.AddProperty("header", "from", v.FromDomain)
+	return result
+}
+
+// OrganizationalDomain returns the organizational domain of a domain: its public suffix
+// plus one more label, such as "example.co.uk" for "mail.example.co.uk".
+func (v *DMARCVerifier) OrganizationalDomain(domain string) string {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	var suffix string
+	if v.PublicSuffix != nil {
+		suffix = v.PublicSuffix(domain)
+	} else {
+		suffix = defaultPublicSuffix(domain)
+	}
+	if len(suffix) == 0 || domain == suffix || !strings.HasSuffix(domain, "."+suffix) {
+		return domain
+	}
+	rest := strings.TrimSuffix(domain, "."+suffix)
+	return rest[strings.LastIndexByte(rest, '.')+1:] + "." + suffix
+}
+
+// aligned returns true if the domains are the same,
+// or if not strict, if they have the same organizational domain.
+func (v *DMARCVerifier) aligned(domain string, fromDomain string, strict bool) bool {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	if domain == fromDomain {
+		return true
+	}
+	return !strict && len(domain) > 0 && v.OrganizationalDomain(domain) == v.OrganizationalDomain(fromDomain)
+}
+
+// lookupRecord returns the DMARC record of the domain, or nil if it has none (or several).
+// If DNS is unavailable, it returns temperror and the reason.
+func (v *DMARCVerifier) lookupRecord(domain string) (*DMARCRecord, AuthResult, string) {
+	resolver := v.Resolver
+	if resolver == nil {
+		resolver = DNSResolver{}
+	}
+	records, err := resolver.LookupTXT("_dmarc." + domain)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, "", ""
+		}
+		return nil, AuthResultTempError, "DMARC record unavailable: " + err.Error()
+	}
+	var found []*DMARCRecord
+	for _, record := range records {
+		if parsed, err := ParseDMARCRecord(record); err == nil {
+			found = append(found, parsed)
+		}
+	}
+	if len(found) != 1 {
+		return nil, "", ""
+	}
+	return found[0], "", ""
+}
+
+// ParseDMARCRecord parses a DMARC record, such as "v=DMARC1; p=reject; rua=mailto:dmarc@example.com".
+// As RFC 7489 requires, a record with a missing or invalid policy is treated as
+// having a policy of none if it has aggregate report URIs, and is otherwise invalid.
+func ParseDMARCRecord(record string) (*DMARCRecord, error) {
+	record = strings.TrimSpace(record)
+	rest := strings.TrimSpace(strings.TrimPrefix(record, "v=DMARC1"))
+	if !strings.HasPrefix(record, "v=DMARC1") || len(rest) > 0 && rest[0] != ';' {
+		return nil, errors.New("Not a DMARC record: " + record)
+	}
+	tags, err := parseDKIMTags(record)
+	if err != nil {
+		return nil, errors.New("Invalid DMARC record: " + err.Error())
+	}
+
+	parsed := &DMARCRecord{
+		Policy:               dmarcPolicy(tags["p"]),
+		StrictDKIM:           strings.EqualFold(tags["adkim"], "s"),
+		StrictSPF:            strings.EqualFold(tags["aspf"], "s"),
+		Percent:              100,
+		AggregateReportURIs:  splitDMARCURIs(tags["rua"]),
+		FailureReportURIs:    splitDMARCURIs(tags["ruf"]),
+		FailureReportOptions: "0",
+		ReportInterval:       24 * time.Hour,
+	}
+	if len(parsed.Policy) == 0 {
+		if len(parsed.AggregateReportURIs) == 0 {
+			return nil, errors.New("DMARC record has no valid policy: " + record)
+		}
+		parsed.Policy = DMARCPolicyNone
+	}
+	parsed.SubdomainPolicy = dmarcPolicy(tags["sp"])
+	if len(parsed.SubdomainPolicy) == 0 {
+		parsed.SubdomainPolicy = parsed.Policy
+	}
+	if pct, err := strconv.Atoi(tags["pct"]); err == nil && pct >= 0 && pct <= 100 {
+		parsed.Percent = pct
+	}
+	if fo, ok := tags["fo"]; ok && len(fo) > 0 {
+		parsed.FailureReportOptions = fo
+	}
+	if ri, err := strconv.Atoi(tags["ri"]); err == nil && ri > 0 {
+		parsed.ReportInterval = time.Duration(ri) * time.Second
+	}
+	return parsed, nil
+}
+
+// dmarcPolicy returns the policy, or an empty string if it is invalid.
+func dmarcPolicy(policy string) DMARCPolicy {
+	switch p := DMARCPolicy(strings.ToLower(policy)); p {
+	case DMARCPolicyNone, DMARCPolicyQuarantine, DMARCPolicyReject:
+		return p
+	}
+	return ""
+}
+
+// splitDMARCURIs splits a comma separated list of report URIs.
+func splitDMARCURIs(uris string) []string {
+	var split []string
+	for _, uri := range strings.Split(uris, ",") {
+		if uri = strings.TrimSpace(uri); len(uri) > 0 {
+			split = append(split, uri)
+		}
+	}
+	return split
+}
+
+// dmarcFromDomain returns the lower case domain of the From header field,
+// which must have at least one address, all in the same domain.
+func dmarcFromDomain(h Header) (string, error) {
+	addresses, err := h.AddressList("From")
+	if err != nil {
+		return "", errors.New("Invalid From header: " + err.Error())
+	}
+	var domain string
+	for _, address := range addresses {
+		idx := strings.LastIndexByte(address.Address, '@')
+		addressDomain := strings.ToLower(strings.TrimSuffix(address.Address[idx+1:], "."))
+		if idx < 0 || len(addressDomain) == 0 || len(domain) > 0 && addressDomain != domain {
+			return "", errors.New("From header must have addresses in a single domain")
+		}
+		domain = addressDomain
+	}
+	if len(domain) == 0 {
+		return "", errors.New("From header is missing")
+	}
+	return domain, nil
+}
+
+// defaultPublicSuffix returns the public suffix of a domain, from a small list of common suffixes.
+func defaultPublicSuffix(domain string) string {
+	labels := strings.Split(domain, ".")
+	if len(labels) >= 2 && dmarcPublicSuffixes[strings.Join(labels[len(labels)-2:], ".")] {
+		return strings.Join(labels[len(labels)-2:], ".")
+	}
+	return labels[len(labels)-1]
+}
@@ -0,0 +1,103 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package email
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestParseDMARCRecord ...
+func TestParseDMARCRecord(t *testing.T) {
+	t.Parallel()
+
+	parsed, err := ParseDMARCRecord("v=DMARC1; p=Reject; sp=quarantine; adkim=s; pct=20; rua=mailto:a@example.com, mailto:b@example.net; fo=1; ri=3600")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &DMARCRecord{Policy: DMARCPolicyReject, SubdomainPolicy: DMARCPolicyQuarantine, StrictDKIM: true, Percent: 20,
+		AggregateReportURIs: []string{"mailto:a@example.com", "mailto:b@example.net"}, FailureReportOptions: "1", ReportInterval: time.Hour}
+	if !reflect.DeepEqual(parsed, expected) {
+		t.Errorf("Expected %+v; got %+v", expected, parsed)
+	}
+
+	// A missing policy is none if there are aggregate reports
+	if parsed, err := ParseDMARCRecord("v=DMARC1; rua=mailto:a@example.com"); err != nil || parsed.Policy != DMARCPolicyNone || parsed.SubdomainPolicy != DMARCPolicyNone {
+		t.Errorf("Expected a policy of none; got %+v %v", parsed, err)
+	}
+	for _, record := range []string{"v=DMARC1", "v=DMARC1; p=maybe", "v=spf1 -all", "v=DMARC10; p=none", "p=none; v=DMARC1"} {
+		if _, err := ParseDMARCRecord(record); err == nil {
+			t.Errorf("Expected an error parsing %q", record)
+		}
+	}
+}
+
+// TestDMARCVerify ...
+func TestDMARCVerify(t *testing.T) {
+	t.Parallel()
+
+	zone := &memoryZone{txt: map[string][]string{
+		"_dmarc.example.com":        {"v=DMARC1; p=reject; sp=quarantine"},
+		"_dmarc.strict.example.com": {"v=DMARC1; p=reject; adkim=s; aspf=s"},
+		"_dmarc.example.co.uk":      {"v=DMARC1; p=quarantine"},
+		"_dmarc.two.example.net":    {"v=DMARC1; p=reject", "v=DMARC1; p=none"},
+	}}
+	verifier := &DMARCVerifier{Resolver: zone}
+
+	pass := func(domain string) []*DKIMVerification {
+		return []*DKIMVerification{{Result: AuthResultFail, Domain: "example.com"}, {Result: AuthResultPass, Domain: domain}}
+	}
+	spf := func(result AuthResult, domain string) *SPFVerification {
+		return &SPFVerification{Result: result, Domain: domain}
+	}
+
+	testCases := []struct {
+		from         string
+		dkim         []*DKIMVerification
+		spf          *SPFVerification
+		result       AuthResult
+		policyDomain string
+		policy       DMARCPolicy
+	}{
+		{"Joe <joe@example.com>", pass("example.com"), nil, AuthResultPass, "example.com", ""},
+		{"joe@example.com", pass("mail.example.com"), nil, AuthResultPass, "example.com", ""},
+		{"joe@example.com", nil, spf(AuthResultPass, "bounces.example.com"), AuthResultPass, "example.com", ""},
+		{"joe@example.com", pass("example.net"), spf(AuthResultPass, "example.net"), AuthResultFail, "example.com", DMARCPolicyReject},
+		{"joe@example.com", nil, spf(AuthResultSoftFail, "example.com"), AuthResultFail, "example.com", DMARCPolicyReject},
+		{"joe@news.example.com", nil, nil, AuthResultFail, "example.com", DMARCPolicyQuarantine},
+		{"joe@strict.example.com", pass("example.com"), spf(AuthResultPass, "mail.strict.example.com"), AuthResultFail, "strict.example.com", DMARCPolicyReject},
+		{"joe@strict.example.com", pass("strict.example.com"), nil, AuthResultPass, "strict.example.com", ""},
+		{"joe@mail.example.co.uk", pass("example.co.uk"), nil, AuthResultPass, "example.co.uk", ""},
+		{"joe@mail.other.co.uk", pass("example.co.uk"), nil, AuthResultNone, "", ""},
+		{"joe@two.example.net", nil, nil, AuthResultNone, "", ""},
+		{"joe@unavailable.example.org", nil, nil, AuthResultTempError, "", ""},
+		{"joe@example.com, jim@example.net", pass("example.com"), nil, AuthResultPermError, "", ""},
+		{"", pass("example.com"), nil, AuthResultPermError, "", ""},
+	}
+	for idx, tc := range testCases {
+		m := &Message{Header: Header{}}
+		if len(tc.from) > 0 {
+			m.Header.Set("From", tc.from)
+		}
+		verification := verifier.Verify(m, tc.dkim, tc.spf)
+		if verification.Result != tc.result || verification.PolicyDomain != tc.policyDomain || verification.Policy != tc.policy {
+			t.Errorf("Case %d: expected %s %q %q; got %s %q %q (%s)", idx, tc.result, tc.policyDomain, tc.policy,
+				verification.Result, verification.PolicyDomain, verification.Policy, verification.Reason)
+		}
+	}
+
+	m := &Message{Header: Header{"From": {"joe@example.com"}}}
+	result := verifier.Verify(m, nil, nil).AuthenticationResult().String()
+	if expected := `dmarc=fail (p=reject) reason="no aligned DKIM or SPF identity passed" header.from=example.com`; result != expected {
+		t.Errorf("Expected %q; got %q", expected, result)
+	}
+
+	// A full public suffix list may be plugged in
+	verifier.PublicSuffix = func(domain string) string { return "example.com" }
+	if orgDomain := verifier.OrganizationalDomain("a.b.example.com"); orgDomain != "b.example.com" {
+		t.Errorf("Expected b.example.com; got %s", orgDomain)
+	}
+}
@@ -0,0 +1,61 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package email
+
+import (
+	"net"
+)
+
+// TXTResolver looks up the DNS TXT records of a name, with each record's strings joined.
+// It may be stubbed in tests.
+type TXTResolver interface {
+	LookupTXT(name string) ([]string, error)
+}
+
+// Resolver looks up the DNS records used to authenticate mail with SPF, DKIM, and DMARC.
+// Lookups of names that do not exist should return a *net.DNSError with IsNotFound set.
+// It may be stubbed in tests with an in-memory zone.
+type Resolver interface {
+	TXTResolver
+
+	// LookupIP looks up the IPv4 and IPv6 addresses of a host.
+	LookupIP(host string) ([]net.IP, error)
+
+	// LookupMX looks up the mail exchangers of a domain.
+	LookupMX(name string) ([]*net.MX, error)
+
+	// LookupAddr looks up the host names of an address (reverse DNS).
+	LookupAddr(addr string) ([]string, error)
+}
+
+// DNSResolver is a Resolver using the system's DNS resolver.
+type DNSResolver struct{}
+
+// LookupTXT ...
+func (DNSResolver) LookupTXT(name string) ([]string, error) {
+	return net.LookupTXT(name)
+}
+
+// LookupIP ...
+func (DNSResolver) LookupIP(host string) ([]net.IP, error) {
+	return net.LookupIP(host)
+}
+
+// LookupMX ...
+func (DNSResolver) LookupMX(name string) ([]*net.MX, error) {
+	return net.LookupMX(name)
+}
+
+// LookupAddr ...
+func (DNSResolver) LookupAddr(addr string) ([]string, error) {
+	return net.LookupAddr(addr)
+}
+
+// isNotFound returns true if the lookup error is because the name does not exist,
+// rather than because DNS was unavailable.
+func isNotFound(err error) bool {
+	dnsErr, ok := err.(*net.DNSError)
+	return ok && dnsErr.IsNotFound
+}
@@ -0,0 +1,150 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package email
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"mime"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Generator creates the values that make each saved message unique:
+// its multipart boundaries, Message-ID, and Date.
+// Any nil field falls back to the default random or current value.
+type Generator struct {
+	// Boundary returns a new multipart boundary.
+	Boundary func() string
+
+	// IDGenerator creates new Message-ID's.
+	IDGenerator IDGenerator
+
+	// Now returns the current time.
+	Now func() time.Time
+}
+
+// NewDeterministicGenerator returns a Generator whose boundaries and Message-ID's
+// are numbered sequentially (with the Message-ID's at this domain), and whose clock
+// always returns this time. Identical messages saved with a new deterministic Generator
+// will write out identical bytes, such as for golden-file tests.
+// The Generator is not safe for concurrent use.
+func NewDeterministicGenerator(now time.Time, domain string) *Generator {
+	boundaries := 0
+	return &Generator{
+		Boundary: func() string {
+			boundaries++
+			return fmt.Sprintf("%x", sha256.Sum256([]byte(fmt.Sprintf("boundary.%d", boundaries))))[:60]
+		},
+		IDGenerator: &sequentialIDGenerator{domain: domain},
+		Now: func() time.Time {
+			return now
+		},
+	}
+}
+
+// sequentialIDGenerator is an IDGenerator that numbers its ID's sequentially.
+type sequentialIDGenerator struct {
+	domain string
+	count  int
+}
+
+// MessageID ...
+func (g *sequentialIDGenerator) MessageID() (string, error) {
+	return g.ContentID("")
+}
+
+// ContentID ...
+func (g *sequentialIDGenerator) ContentID(filename string) (string, error) {
+	g.count++
+	if len(filename) == 0 {
+		return fmt.Sprintf("%d@%s", g.count, g.domain), nil
+	}
+	return fmt.Sprintf("%d.%s@%s", g.count, strings.Map(idAtext, filename), g.domain), nil
+}
+
+// boundary ...
+func (g *Generator) boundary() string {
+	if g == nil || g.Boundary == nil {
+		return randomBoundary()
+	}
+	return g.Boundary()
+}
+
+// messageID ...
+func (g *Generator) messageID() (string, error) {
+	if g == nil || g.IDGenerator == nil {
+		return GenMessageID()
+	}
+	return g.IDGenerator.MessageID()
+}
+
+// now ...
+func (g *Generator) now() time.Time {
+	if g == nil || g.Now == nil {
+		return time.Now()
+	}
+	return g.Now()
+}
+
+// SaveWith is like Save, except it uses the Generator for the "Message-Id" and "Date",
+// if missing, and replaces the boundary of every multipart within this message,
+// recursively, with a new one from the Generator.
+func (m *Message) SaveWith(g *Generator) error {
+	// Parts written out as their Raw bytes, such as signed parts, must keep their boundaries
+	frozen := map[*Message]bool{}
+	for _, msg := range m.MessagesAll() {
+		if msg.WriteRaw && msg.Raw != nil {
+			for _, inner := range msg.MessagesAll() {
+				frozen[inner] = true
+			}
+		}
+	}
+	for _, msg := range m.MessagesAll() {
+		mediaType, params, err := msg.Header.ContentType()
+		if err != nil || frozen[msg] || !strings.HasPrefix(mediaType, "multipart") {
+			continue
+		}
+		params["boundary"] = g.boundary()
+		msg.Header.Set("Content-Type", mime.FormatMediaType(mediaType, params))
+	}
+	return m.Header.save(g)
+}
+
+// EqualIgnoringBoundaries returns true if both messages have the same headers,
+// preamble, epilogue, and payloads, recursively, ignoring the multipart boundaries.
+// This allows comparing messages whose boundaries were randomly generated.
+func EqualIgnoringBoundaries(a *Message, b *Message) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if !bytes.Equal(a.Preamble, b.Preamble) || !bytes.Equal(a.Epilogue, b.Epilogue) ||
+		!bytes.Equal(a.Body, b.Body) || len(a.Parts) != len(b.Parts) ||
+		!equalHeadersIgnoringBoundary(a.Header, b.Header) ||
+		!EqualIgnoringBoundaries(a.SubMessage, b.SubMessage) {
+		return false
+	}
+	for idx := range a.Parts {
+		if !EqualIgnoringBoundaries(a.Parts[idx], b.Parts[idx]) {
+			return false
+		}
+	}
+	return true
+}
+
+// equalHeadersIgnoringBoundary ...
+func equalHeadersIgnoringBoundary(a Header, b Header) bool {
+	a = copyHeader(a)
+	b = copyHeader(b)
+	for _, h := range []Header{a, b} {
+		if mediaType, params, err := h.ContentType(); err == nil {
+			delete(params, "boundary")
+			h.Set("Content-Type", mime.FormatMediaType(mediaType, params))
+		}
+	}
+	return reflect.DeepEqual(map[string][]string(a), map[string][]string(b))
+}
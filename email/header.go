@@ -86,9 +86,23 @@ func (h Header) Del(key string) {
 
 // mail.Header Methods:
 
-// Date parses the Date header field.
+// Date leniently parses the Date header field with ParseDate.
+// If the Date is missing or can not be parsed, the date of the
+// topmost Received header (added by the receiving server) is used instead.
 func (h Header) Date() (time.Time, error) {
-	return mail.Header(h).Date()
+	date, err := ParseDate(h.Get("Date"))
+	if err == nil {
+		return date, nil
+	}
+	for _, received := range h["Received"] {
+		if receivedDate, receivedErr := receivedDate(received); receivedErr == nil {
+			return receivedDate, nil
+		}
+	}
+	if !h.IsSet("Date") {
+		return time.Time{}, mail.ErrHeaderNotPresent
+	}
+	return time.Time{}, err
 }
 
 // AddressList parses the named header field as a list of addresses.
@@ -101,15 +115,21 @@ func (h Header) AddressList(key string) ([]*mail.Address, error) {
 // Save adds headers for the "Message-Id", "Date", and "MIME-Version",
 // if missing.  An error is returned if the Message-Id can not be created.
 func (h Header) Save() error {
+	return h.save(nil)
+}
+
+// save adds headers for the "Message-Id", "Date", and "MIME-Version",
+// if missing, using the Generator.
+func (h Header) save(g *Generator) error {
 	if len(h.Get("Message-Id")) == 0 {
-		id, err := GenMessageID()
+		id, err := g.messageID()
 		if err != nil {
 			return err
 		}
 		h.Set("Message-Id", "<"+id+">")
 	}
 	if len(h.Get("Date")) == 0 {
-		h.Set("Date", time.Now().Format(time.RFC822))
+		h.Set("Date", g.now().Format(time.RFC1123Z))
 	}
 	h.Set("MIME-Version", "1.0")
 	return nil
@@ -237,3 +257,18 @@ func (h Header) Subject() string {
 func (h Header) SetSubject(subject string) {
 	h.Set("Subject", subject)
 }
+
+// DispositionNotificationTo ...
+func (h Header) DispositionNotificationTo() []string {
+	dnt := h.Get("Disposition-Notification-To")
+	if dnt == "" {
+		return []string{}
+	}
+	return strings.Split(dnt, ", ")
+}
+
+// SetDispositionNotificationTo requests a read receipt (a Message Disposition
+// Notification) be sent to these addresses when the message is displayed.
+func (h Header) SetDispositionNotificationTo(emails ...string) {
+	h.Set("Disposition-Notification-To", strings.Join(emails, ", "))
+}
@@ -0,0 +1,137 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package email
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+)
+
+// crockfordBase32 is the alphabet used by ULID's.
+const crockfordBase32 = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// IDGenerator creates globally unique Message-ID's and Content-ID's,
+// without surrounding angle brackets.
+type IDGenerator interface {
+	// MessageID returns a new Message-ID.
+	MessageID() (string, error)
+
+	// ContentID returns a new Content-ID, for an attachment with this (optional) filename.
+	ContentID(filename string) (string, error)
+}
+
+// DefaultIDGenerator is the IDGenerator used by Save, GenMessageID and GenContentID.
+// It may be replaced, such as with a DomainIDGenerator, before creating any messages.
+var DefaultIDGenerator IDGenerator = HostnameIDGenerator{}
+
+// HostnameIDGenerator creates ID's from the current time, process ID and a random number,
+// at the machine's hostname (or "localhost" if the hostname is unavailable).
+type HostnameIDGenerator struct{}
+
+// MessageID ...
+func (g HostnameIDGenerator) MessageID() (string, error) {
+	return g.ContentID("")
+}
+
+// ContentID ...
+func (g HostnameIDGenerator) ContentID(filename string) (string, error) {
+	random, err := rand.Int(rand.Reader, maxInt64)
+	if err != nil {
+		return "", err
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	pid := os.Getpid()
+	nanoTime := time.Now().UTC().UnixNano()
+	if len(filename) == 0 {
+		return fmt.Sprintf("%d.%d.%d@%s", nanoTime, pid, random, hostname), nil
+	}
+	return fmt.Sprintf("%d.%d.%d.%s@%s", nanoTime, pid, random, filename, hostname), nil
+}
+
+// DomainIDGenerator creates ID's at a configured domain (such as "mail.example.com"),
+// which avoids revealing the machine's hostname, with a random UUID (version 4)
+// or a time-ordered ULID as the local part.
+// Content-ID's have the filename appended to the local part, with any
+// characters that are not allowed in an ID replaced by "_".
+type DomainIDGenerator struct {
+	// Domain is the right hand side of every ID. It is required.
+	Domain string
+
+	// ULID uses a ULID as the local part, instead of a UUID.
+	ULID bool
+}
+
+// MessageID ...
+func (g DomainIDGenerator) MessageID() (string, error) {
+	return g.ContentID("")
+}
+
+// ContentID ...
+func (g DomainIDGenerator) ContentID(filename string) (string, error) {
+	if len(g.Domain) == 0 {
+		return "", errors.New("DomainIDGenerator requires a Domain")
+	}
+	var local string
+	var err error
+	if g.ULID {
+		local, err = newULID(rand.Reader, time.Now())
+	} else {
+		local, err = newUUID(rand.Reader)
+	}
+	if err != nil {
+		return "", err
+	}
+	if len(filename) > 0 {
+		local += "." + strings.Map(idAtext, filename)
+	}
+	return local + "@" + g.Domain, nil
+}
+
+// newUUID returns a random (version 4) UUID, as defined by RFC 4122.
+func newUUID(random io.Reader) (string, error) {
+	var b [16]byte
+	if _, err := io.ReadFull(random, b[:]); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0F | 0x40 // version 4
+	b[8] = b[8]&0x3F | 0x80 // variant 10
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
+
+// newULID returns a ULID: a 48-bit millisecond timestamp followed by
+// 80 random bits, encoded as 26 characters of Crockford's base32.
+func newULID(random io.Reader, now time.Time) (string, error) {
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[:8], uint64(now.UnixNano()/int64(time.Millisecond))<<16)
+	if _, err := io.ReadFull(random, b[6:]); err != nil {
+		return "", err
+	}
+	n := new(big.Int).SetBytes(b[:])
+	mask := big.NewInt(31)
+	var encoded [26]byte
+	for i := len(encoded) - 1; i >= 0; i-- {
+		encoded[i] = crockfordBase32[new(big.Int).And(n, mask).Int64()]
+		n.Rsh(n, 5)
+	}
+	return string(encoded[:]), nil
+}
+
+// idAtext maps any character not allowed in the dot-atom of an ID to "_".
+func idAtext(r rune) rune {
+	if r > ' ' && r < 0x7F && !strings.ContainsRune(`"(),:;<>@[\]`, r) {
+		return r
+	}
+	return '_'
+}
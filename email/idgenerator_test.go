@@ -0,0 +1,50 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package email
+
+import (
+	"bytes"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// errReader always fails, as crypto/rand might.
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) {
+	return 0, errors.New("no randomness")
+}
+
+// TestDomainIDGenerator ...
+func TestDomainIDGenerator(t *testing.T) {
+	t.Parallel()
+
+	uuidRegexp := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}@mail\.example\.com$`)
+	id, err := DomainIDGenerator{Domain: "mail.example.com"}.MessageID()
+	if err != nil || !uuidRegexp.MatchString(id) {
+		t.Errorf("Expected a UUID Message-ID; got %q %v", id, err)
+	}
+
+	ulidRegexp := regexp.MustCompile(`^[0-9A-HJKMNP-TV-Z]{26}\.my_file\.txt@mail\.example\.com$`)
+	id, err = DomainIDGenerator{Domain: "mail.example.com", ULID: true}.ContentID("my file.txt")
+	if err != nil || !ulidRegexp.MatchString(id) {
+		t.Errorf("Expected a ULID Content-ID; got %q %v", id, err)
+	}
+
+	// ULID's sort by time, and encode the timestamp first
+	ulid, err := newULID(bytes.NewReader(make([]byte, 10)), time.Unix(1469918176, 385000000))
+	if err != nil || ulid != "01ARYZ6S410000000000000000" {
+		t.Errorf("Unexpected ULID: %q %v", ulid, err)
+	}
+
+	if _, err = newUUID(errReader{}); err == nil {
+		t.Error("Expected an error when randomness is unavailable")
+	}
+	if _, err = (DomainIDGenerator{}).MessageID(); err == nil {
+		t.Error("Expected an error without a Domain")
+	}
+}
@@ -0,0 +1,295 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package email
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Maildir flags, as stored in the info suffix ":2,FLAGS" of a filename.
+const (
+	MaildirFlagPassed  = 'P' // Passed: the message was resent, forwarded, or bounced
+	MaildirFlagReplied = 'R' // Replied: the message was replied to
+	MaildirFlagSeen    = 'S' // Seen: the message was viewed
+	MaildirFlagTrashed = 'T' // Trashed: the message is marked for deletion
+	MaildirFlagDraft   = 'D' // Draft: the message is a draft
+	MaildirFlagFlagged = 'F' // Flagged: the message is flagged for urgent or special attention
+)
+
+const (
+	// maildirInfoSeparator separates the unique key from the info suffix.
+	maildirInfoSeparator = ":2,"
+)
+
+// maildirDeliveries counts the deliveries made by this process,
+// to ensure the uniqueness of filenames.
+var maildirDeliveries int64
+
+// Maildir is the path to a Maildir directory, containing the
+// "tmp", "new" and "cur" subdirectories.
+type Maildir string
+
+// MaildirMessage is a message file stored in a Maildir.
+type MaildirMessage struct {
+	// Maildir is the Maildir the message is stored in.
+	Maildir Maildir
+
+	// Key is the unique name of the message, without the info suffix.
+	Key string
+
+	// Flags are the message's flags, such as "FS" (Flagged and Seen), in ASCII order.
+	Flags string
+
+	// New is true if the message is in "new", and false if it is in "cur".
+	New bool
+
+	// Filename is the name of the message file, as found on disk, including any info suffix.
+	// If empty, the name is made from the Key and Flags.
+	Filename string
+}
+
+// Init creates the Maildir's "tmp", "new" and "cur" subdirectories, if missing.
+func (d Maildir) Init() error {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(string(d), sub), 0700); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ErrMaildirFolderName is returned when a Maildir++ subfolder name is empty,
+// or contains a path separator or "..".
+var ErrMaildirFolderName = errors.New("Invalid Maildir folder name")
+
+// Folder returns the Maildir++ subfolder with this name, such as "Sent",
+// or "Archive.2016" for a subfolder of a subfolder.
+// The subfolder must be created with Init before use.
+func (d Maildir) Folder(name string) (Maildir, error) {
+	name = strings.TrimPrefix(name, ".")
+	if len(name) == 0 || strings.ContainsAny(name, `/\`) || strings.Contains(name, "..") {
+		return "", ErrMaildirFolderName
+	}
+	return Maildir(filepath.Join(string(d), "."+name)), nil
+}
+
+// Folders returns the names of all Maildir++ subfolders.
+func (d Maildir) Folders() ([]string, error) {
+	infos, err := ioutil.ReadDir(string(d))
+	if err != nil {
+		return nil, err
+	}
+	folders := make([]string, 0, len(infos))
+	for _, info := range infos {
+		if !info.IsDir() || !strings.HasPrefix(info.Name(), ".") || info.Name() == "." || info.Name() == ".." {
+			continue
+		}
+		if cur, err := os.Stat(filepath.Join(string(d), info.Name(), "cur")); err == nil && cur.IsDir() {
+			folders = append(folders, info.Name()[1:])
+		}
+	}
+	return folders, nil
+}
+
+// New returns the messages in "new", which have not yet been seen by any mail reader.
+func (d Maildir) New() ([]*MaildirMessage, error) {
+	return d.list("new")
+}
+
+// Cur returns the messages in "cur", which have been seen by a mail reader
+// (although they may not have the Seen flag).
+func (d Maildir) Cur() ([]*MaildirMessage, error) {
+	return d.list("cur")
+}
+
+// list ...
+func (d Maildir) list(sub string) ([]*MaildirMessage, error) {
+	infos, err := ioutil.ReadDir(filepath.Join(string(d), sub))
+	if err != nil {
+		return nil, err
+	}
+	messages := make([]*MaildirMessage, 0, len(infos))
+	for _, info := range infos {
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".") {
+			continue
+		}
+		key, flags := parseMaildirFilename(info.Name())
+		messages = append(messages, &MaildirMessage{Maildir: d, Key: key, Flags: flags, New: sub == "new", Filename: info.Name()})
+	}
+	return messages, nil
+}
+
+// Deliver writes the message into "tmp" under a unique filename, and then
+// atomically moves it into "new", returning the delivered MaildirMessage.
+func (d Maildir) Deliver(msg *Message) (*MaildirMessage, error) {
+	key := maildirUniqueKey()
+	tmpPath := filepath.Join(string(d), "tmp", key)
+	file, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	_, err = msg.WriteTo(file)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return nil, err
+	}
+
+	delivered := &MaildirMessage{Maildir: d, Key: key, New: true, Filename: key}
+	if err = moveNoReplace(tmpPath, delivered.Path()); err != nil {
+		os.Remove(tmpPath)
+		return nil, err
+	}
+	return delivered, nil
+}
+
+// Path returns the full path to the message file.
+func (m *MaildirMessage) Path() string {
+	sub := "cur"
+	if m.New {
+		sub = "new"
+	}
+	filename := m.Filename
+	if len(filename) == 0 {
+		filename = m.Key
+		if !m.New {
+			filename += maildirInfoSeparator + m.Flags
+		}
+	}
+	return filepath.Join(string(m.Maildir), sub, filename)
+}
+
+// Message opens and parses the message file.
+func (m *MaildirMessage) Message() (*Message, error) {
+	file, err := os.Open(m.Path())
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ParseMessage(file)
+}
+
+// HasFlag returns true if the message has this flag, such as MaildirFlagSeen.
+func (m *MaildirMessage) HasFlag(flag rune) bool {
+	return strings.ContainsRune(m.Flags, flag)
+}
+
+// SetFlags moves the message into "cur" (if not already there), with these flags.
+func (m *MaildirMessage) SetFlags(flags string) error {
+	oldPath := m.Path()
+	moved := *m
+	moved.New = false
+	moved.Flags = sortMaildirFlags(flags)
+	moved.Filename = moved.Key + maildirInfoSeparator + moved.Flags
+	newPath := moved.Path()
+	if oldPath != newPath {
+		if err := os.Rename(oldPath, newPath); err != nil {
+			return err
+		}
+	}
+	*m = moved
+	return nil
+}
+
+// AddFlags moves the message into "cur" (if not already there), adding these flags.
+func (m *MaildirMessage) AddFlags(flags string) error {
+	return m.SetFlags(m.Flags + flags)
+}
+
+// RemoveFlags moves the message into "cur" (if not already there), removing these flags.
+func (m *MaildirMessage) RemoveFlags(flags string) error {
+	return m.SetFlags(strings.Map(func(r rune) rune {
+		if strings.ContainsRune(flags, r) {
+			return -1
+		}
+		return r
+	}, m.Flags))
+}
+
+// MoveTo moves the message into another Maildir (such as a Maildir++ subfolder),
+// keeping its flags and whether it is new.
+func (m *MaildirMessage) MoveTo(d Maildir) error {
+	moved := *m
+	moved.Maildir = d
+	if err := moveNoReplace(m.Path(), moved.Path()); err != nil {
+		return err
+	}
+	*m = moved
+	return nil
+}
+
+// Remove deletes the message file.
+func (m *MaildirMessage) Remove() error {
+	return os.Remove(m.Path())
+}
+
+// parseMaildirFilename returns the unique key and flags of a filename.
+func parseMaildirFilename(filename string) (string, string) {
+	idx := strings.LastIndex(filename, maildirInfoSeparator)
+	if idx < 0 {
+		// Ignore any experimental info (":1,") or missing info
+		if colon := strings.LastIndexByte(filename, ':'); colon >= 0 {
+			return filename[:colon], ""
+		}
+		return filename, ""
+	}
+	return filename[:idx], filename[idx+len(maildirInfoSeparator):]
+}
+
+// sortMaildirFlags returns the flags in ASCII order, without duplicates.
+func sortMaildirFlags(flags string) string {
+	runes := []rune(flags)
+	sort.Slice(runes, func(i, j int) bool { return runes[i] < runes[j] })
+	sorted := make([]rune, 0, len(runes))
+	for idx, r := range runes {
+		if idx == 0 || r != runes[idx-1] {
+			sorted = append(sorted, r)
+		}
+	}
+	return string(sorted)
+}
+
+// maildirUniqueKey returns a unique filename for a delivery,
+// in the format recommended by the Maildir specification:
+// seconds.M<microseconds>P<pid>Q<deliveries>R<random>.hostname
+func maildirUniqueKey() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	hostname = strings.NewReplacer("/", `\057`, ":", `\072`).Replace(hostname)
+	random := randomBoundary()[:16]
+	now := time.Now()
+	deliveries := atomic.AddInt64(&maildirDeliveries, 1)
+	return fmt.Sprintf("%d.M%dP%dQ%dR%s.%s", now.Unix(), now.Nanosecond()/1000, os.Getpid(), deliveries, random, hostname)
+}
+
+// moveNoReplace moves a file, failing if the destination already exists.
+// A hard link is attempted first, as it atomically fails if the destination
+// exists, falling back to a rename on file systems without hard links.
+func moveNoReplace(oldPath string, newPath string) error {
+	if err := os.Link(oldPath, newPath); err == nil {
+		return os.Remove(oldPath)
+	} else if os.IsExist(err) {
+		return err
+	}
+	if _, err := os.Stat(newPath); err == nil {
+		return errors.New("Maildir message already exists: " + newPath)
+	}
+	return os.Rename(oldPath, newPath)
+}
@@ -0,0 +1,171 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package email
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestMaildir ...
+func TestMaildir(t *testing.T) {
+	t.Parallel()
+
+	d := Maildir(t.TempDir())
+	if err := d.Init(); err != nil {
+		t.Fatal("Could not create Maildir:", err)
+	}
+
+	// Deliver into tmp, then new
+	msg := NewPartText("maildir body")
+	msg.Header.SetFrom("sender@host.com")
+	msg.Header.SetSubject("Maildir Test")
+	delivered, err := d.Deliver(msg)
+	if err != nil {
+		t.Fatal("Could not deliver message:", err)
+	}
+	if !delivered.New || filepath.Dir(delivered.Path()) != filepath.Join(string(d), "new") {
+		t.Fatal("Delivered message is not in new:", delivered.Path())
+	}
+	if tmp, _ := ioutil.ReadDir(filepath.Join(string(d), "tmp")); len(tmp) != 0 {
+		t.Fatal("Delivered message was left in tmp")
+	}
+	parsed, err := delivered.Message()
+	if err != nil || parsed.Header.Subject() != "Maildir Test" {
+		t.Fatal("Could not read delivered message:", err)
+	}
+
+	// List new and cur
+	newMessages, err := d.New()
+	if err != nil || len(newMessages) != 1 || newMessages[0].Key != delivered.Key || newMessages[0].Path() != delivered.Path() {
+		t.Fatal("New messages do not match the delivered message:", err, newMessages)
+	}
+	if cur, err := d.Cur(); err != nil || len(cur) != 0 {
+		t.Fatal("Expected no messages in cur:", err, cur)
+	}
+
+	// Flags move the message into cur, in ASCII order
+	m := newMessages[0]
+	if err = m.AddFlags("SF"); err != nil {
+		t.Fatal("Could not add flags:", err)
+	}
+	if m.New || m.Flags != "FS" || filepath.Base(m.Path()) != m.Key+":2,FS" {
+		t.Fatal("Message flags were not set:", m.Path())
+	}
+	if err = m.AddFlags("RS"); err != nil || m.Flags != "FRS" || !m.HasFlag(MaildirFlagReplied) {
+		t.Fatal("Could not add flags:", err, m.Flags)
+	}
+	if err = m.RemoveFlags("F"); err != nil || m.Flags != "RS" || m.HasFlag(MaildirFlagFlagged) {
+		t.Fatal("Could not remove flags:", err, m.Flags)
+	}
+	if err = m.SetFlags("T"); err != nil || m.Flags != "T" {
+		t.Fatal("Could not set flags:", err, m.Flags)
+	}
+	cur, err := d.Cur()
+	if err != nil || len(cur) != 1 || cur[0].Flags != "T" || cur[0].Path() != m.Path() {
+		t.Fatal("Cur messages do not match the flagged message:", err, cur)
+	}
+	if newMessages, _ = d.New(); len(newMessages) != 0 {
+		t.Fatal("Expected no messages in new:", newMessages)
+	}
+
+	// Move into a Maildir++ folder
+	if _, err = d.Folder("../Escape"); err != ErrMaildirFolderName {
+		t.Fatal("Expected an invalid folder name error:", err)
+	}
+	if _, err = d.Folder("Archive/2016"); err != ErrMaildirFolderName {
+		t.Fatal("Expected an invalid folder name error:", err)
+	}
+	archive, err := d.Folder("Archive.2016")
+	if err != nil || string(archive) != filepath.Join(string(d), ".Archive.2016") {
+		t.Fatal("Folder does not match expected path:", err, archive)
+	}
+	if err = archive.Init(); err != nil {
+		t.Fatal("Could not create folder:", err)
+	}
+	if err = m.MoveTo(archive); err != nil {
+		t.Fatal("Could not move message:", err)
+	}
+	if archived, err := archive.Cur(); err != nil || len(archived) != 1 || archived[0].Key != m.Key || archived[0].Flags != "T" {
+		t.Fatal("Folder messages do not match the moved message:", err, archived)
+	}
+	if cur, _ = d.Cur(); len(cur) != 0 {
+		t.Fatal("Moved message was left in cur:", cur)
+	}
+	if folders, err := d.Folders(); err != nil || !reflect.DeepEqual(folders, []string{"Archive.2016"}) {
+		t.Fatal("Folders do not match expected:", err, folders)
+	}
+
+	// Remove
+	if err = m.Remove(); err != nil {
+		t.Fatal("Could not remove message:", err)
+	}
+	if _, err = os.Stat(m.Path()); !os.IsNotExist(err) {
+		t.Fatal("Removed message still exists:", err)
+	}
+}
+
+// TestMaildirFilenames ...
+func TestMaildirFilenames(t *testing.T) {
+	t.Parallel()
+
+	d := Maildir(t.TempDir())
+	if err := d.Init(); err != nil {
+		t.Fatal("Could not create Maildir:", err)
+	}
+
+	// Files written by other programs may have any info, or none, in either new or cur
+	files := map[string]string{
+		"new": "1.new:2,S",
+		"cur": "2.experimental:1,xyz",
+	}
+	for sub, name := range files {
+		if err := ioutil.WriteFile(filepath.Join(string(d), sub, name), []byte("Subject: "+name+"\r\n\r\nbody"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(string(d), "cur", "3.noinfo"), []byte("Subject: 3.noinfo\r\n\r\nbody"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	newMessages, err := d.New()
+	if err != nil || len(newMessages) != 1 {
+		t.Fatal("Could not list new:", err)
+	}
+	cur, err := d.Cur()
+	if err != nil || len(cur) != 2 {
+		t.Fatal("Could not list cur:", err)
+	}
+	for _, m := range append(newMessages, cur...) {
+		parsed, err := m.Message()
+		if err != nil || parsed.Header.Subject() != m.Filename {
+			t.Fatal("Could not open message:", m.Filename, err)
+		}
+	}
+
+	// Setting flags renames the file to standard info
+	if err = cur[0].SetFlags("S"); err != nil || filepath.Base(cur[0].Path()) != "2.experimental:2,S" {
+		t.Fatal("Could not set flags:", err, cur[0].Path())
+	}
+	archive, err := d.Folder("Archive")
+	if err != nil {
+		t.Fatal("Could not get folder:", err)
+	}
+	if err = archive.Init(); err != nil {
+		t.Fatal("Could not create folder:", err)
+	}
+	if err = newMessages[0].MoveTo(archive); err != nil {
+		t.Fatal("Could not move message:", err)
+	}
+	if _, err = os.Stat(filepath.Join(string(archive), "new", "1.new:2,S")); err != nil {
+		t.Fatal("Moved message is missing:", err)
+	}
+	if err = cur[1].Remove(); err != nil {
+		t.Fatal("Could not remove message:", err)
+	}
+}
@@ -0,0 +1,339 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package email
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MboxFormat is the variant of the mbox format, which differ in how
+// lines beginning with "From " inside of messages are escaped.
+type MboxFormat int
+
+const (
+	// MboxO escapes lines beginning with "From " by prepending a ">".
+	// Lines that already began with ">From " are ambiguous, and
+	// will be unescaped when read.
+	MboxO MboxFormat = iota
+
+	// MboxRD escapes lines beginning with any number of ">" followed
+	// by "From " by prepending another ">", which is reversible.
+	MboxRD
+
+	// MboxCL2 does not escape any lines, and instead adds a
+	// Content-Length header field with the length of the body.
+	MboxCL2
+)
+
+const (
+	// mboxDefaultSender is the envelope sender written when none is known.
+	mboxDefaultSender = "MAILER-DAEMON"
+)
+
+// mboxDateLayouts are the layouts of the date in a "From " separator line,
+// which should be asctime, but is sometimes followed or preceded by a time zone.
+var mboxDateLayouts = []string{
+	time.ANSIC,
+	"Mon Jan _2 15:04:05 2006 -0700",
+	"Mon Jan _2 15:04:05 MST 2006",
+	"Mon Jan _2 15:04:05 -0700 2006",
+	"Mon Jan _2 15:04 2006",
+	time.RFC1123Z,
+	time.RFC1123,
+}
+
+// MboxMessage is a Message read from, or to be written to, an mbox,
+// along with the envelope information from its "From " separator line.
+type MboxMessage struct {
+	*Message
+
+	// EnvelopeSender is the envelope sender (return path) of the message.
+	EnvelopeSender string
+
+	// EnvelopeDate is the date the message was delivered into the mbox.
+	// It is zero if the date could not be parsed.
+	EnvelopeDate time.Time
+}
+
+// MboxReader reads Messages from an mbox, one at a time.
+type MboxReader struct {
+	r       *bufio.Reader
+	format  MboxFormat
+	pending []byte // the next "From " separator line, if already read
+	err     error
+}
+
+// NewMboxReader returns an MboxReader reading from this io.Reader,
+// containing messages in this mbox format.
+func NewMboxReader(r io.Reader, format MboxFormat) *MboxReader {
+	return &MboxReader{r: bufioReader(r), format: format}
+}
+
+// Next parses and returns the next message in the mbox,
+// or io.EOF if there are no more messages.
+// Any escaped "From " lines in the message are unescaped before parsing.
+func (r *MboxReader) Next() (*MboxMessage, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	separator := r.pending
+	if separator == nil {
+		var err error
+		separator, err = r.readSeparator()
+		if err != nil {
+			r.err = err
+			return nil, err
+		}
+	}
+	r.pending = nil
+
+	var raw []byte
+	var err error
+	if r.format == MboxCL2 {
+		raw, err = r.readContentLength()
+	} else {
+		raw, err = r.readUntilSeparator(true)
+	}
+	if err != nil {
+		r.err = err
+		return nil, err
+	}
+
+	msg, err := ParseMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	sender, date := parseMboxSeparator(separator)
+	return &MboxMessage{Message: msg, EnvelopeSender: sender, EnvelopeDate: date}, nil
+}
+
+// readSeparator skips any blank lines, and returns the "From " separator line.
+func (r *MboxReader) readSeparator() ([]byte, error) {
+	for {
+		line, err := r.r.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			if !bytes.HasPrefix(line, []byte("From ")) {
+				return nil, errors.New("Mbox message does not start with a From separator line")
+			}
+			return line, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// readUntilSeparator reads lines up until the next "From " separator line
+// (which is kept as pending) or EOF, optionally unescaping any escaped lines.
+func (r *MboxReader) readUntilSeparator(unescape bool) ([]byte, error) {
+	buffer := &bytes.Buffer{}
+	for {
+		line, err := r.r.ReadBytes('\n')
+		if bytes.HasPrefix(line, []byte("From ")) {
+			r.pending = line
+			break
+		}
+		if unescape {
+			line = r.unescape(line)
+		}
+		buffer.Write(line)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return trimMboxBlankLine(buffer.Bytes()), nil
+}
+
+// readContentLength reads the header, and then the number of bytes
+// specified by the Content-Length header field, falling back to reading up
+// until the next "From " separator line if the Content-Length is missing.
+func (r *MboxReader) readContentLength() ([]byte, error) {
+	buffer := &bytes.Buffer{}
+	contentLength := -1
+	for {
+		line, err := r.r.ReadBytes('\n')
+		buffer.Write(line)
+		if len(bytes.TrimRight(line, "\r\n")) == 0 {
+			break
+		}
+		if field, value, ok := bytes.Cut(line, []byte(":")); ok && strings.EqualFold(string(field), "Content-Length") {
+			if length, err := strconv.Atoi(string(bytes.TrimSpace(value))); err == nil && length >= 0 {
+				contentLength = length
+			}
+		}
+		if err == io.EOF {
+			return buffer.Bytes(), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if contentLength < 0 {
+		body, err := r.readUntilSeparator(false)
+		return append(buffer.Bytes(), body...), err
+	}
+
+	if _, err := io.CopyN(buffer, r.r, int64(contentLength)); err != nil && err != io.EOF {
+		return nil, err
+	}
+	separator, err := r.readSeparator()
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	r.pending = separator
+	return buffer.Bytes(), nil
+}
+
+// unescape removes one ">" from lines matching the format's escaping.
+func (r *MboxReader) unescape(line []byte) []byte {
+	switch r.format {
+	case MboxO:
+		if bytes.HasPrefix(line, []byte(">From ")) {
+			return line[1:]
+		}
+	case MboxRD:
+		if isMboxRDEscaped(line) {
+			return line[1:]
+		}
+	}
+	return line
+}
+
+// isMboxRDEscaped returns true if the line begins with
+// one or more ">", followed by "From ".
+func isMboxRDEscaped(line []byte) bool {
+	trimmed := bytes.TrimLeft(line, ">")
+	return len(trimmed) < len(line) && bytes.HasPrefix(trimmed, []byte("From "))
+}
+
+// trimMboxBlankLine removes the blank line that precedes a "From " separator line.
+func trimMboxBlankLine(raw []byte) []byte {
+	if bytes.HasSuffix(raw, []byte("\r\n\r\n")) {
+		return raw[:len(raw)-2]
+	}
+	if bytes.HasSuffix(raw, []byte("\n\n")) {
+		return raw[:len(raw)-1]
+	}
+	return raw
+}
+
+// parseMboxSeparator returns the envelope sender and date from a "From " separator line.
+func parseMboxSeparator(line []byte) (string, time.Time) {
+	fields := strings.Fields(strings.TrimPrefix(string(line), "From "))
+	if len(fields) == 0 {
+		return "", time.Time{}
+	}
+	date := strings.Join(fields[1:], " ")
+	for _, layout := range mboxDateLayouts {
+		if parsed, err := time.Parse(layout, date); err == nil {
+			return fields[0], parsed
+		}
+	}
+	return fields[0], time.Time{}
+}
+
+// MboxWriter writes Messages to an mbox. To append to an existing mbox
+// file, open it with os.O_APPEND and pass it to NewMboxWriter.
+type MboxWriter struct {
+	w      io.Writer
+	format MboxFormat
+}
+
+// NewMboxWriter returns an MboxWriter writing to this io.Writer,
+// in this mbox format.
+func NewMboxWriter(w io.Writer, format MboxFormat) *MboxWriter {
+	return &MboxWriter{w: w, format: format}
+}
+
+// Write writes out the message, preceded by a "From " separator line
+// and followed by a blank line, with its line endings converted to the
+// mbox convention of a single new-line, and any "From " lines escaped.
+// If the EnvelopeSender is empty, the Return-Path or From address is used,
+// and if the EnvelopeDate is zero, the current time is used.
+func (w *MboxWriter) Write(msg *MboxMessage) error {
+	sender := msg.EnvelopeSender
+	if len(sender) == 0 {
+		if address, err := envelopeSender(msg.Header); err == nil && len(address.Address) > 0 {
+			sender = address.Address
+		} else {
+			sender = mboxDefaultSender
+		}
+	}
+	date := msg.EnvelopeDate
+	if date.IsZero() {
+		date = time.Now()
+	}
+
+	// Any existing Content-Length would be stale, so write out a copy without it
+	withoutLength := *msg.Message
+	withoutLength.Header = copyHeader(msg.Header)
+	withoutLength.Header.Del("Content-Length")
+	raw, err := withoutLength.Bytes()
+	if err != nil {
+		return err
+	}
+	raw = bytes.ReplaceAll(raw, []byte("\r\n"), []byte("\n"))
+	if !bytes.HasSuffix(raw, []byte("\n")) {
+		raw = append(raw, '\n')
+	}
+
+	if w.format == MboxCL2 {
+		raw = insertContentLength(raw)
+	} else {
+		raw = w.escape(raw)
+	}
+
+	if _, err = fmt.Fprintf(w.w, "From %s %s\n", sender, date.UTC().Format(time.ANSIC)); err != nil {
+		return err
+	}
+	if _, err = w.w.Write(raw); err != nil {
+		return err
+	}
+	_, err = io.WriteString(w.w, "\n")
+	return err
+}
+
+// escape prepends a ">" to lines matching the format's escaping.
+func (w *MboxWriter) escape(raw []byte) []byte {
+	buffer := &bytes.Buffer{}
+	buffer.Grow(len(raw))
+	for len(raw) > 0 {
+		line := raw
+		if idx := bytes.IndexByte(raw, '\n'); idx >= 0 {
+			line = raw[:idx+1]
+		}
+		raw = raw[len(line):]
+		if bytes.HasPrefix(line, []byte("From ")) || (w.format == MboxRD && isMboxRDEscaped(line)) {
+			buffer.WriteByte('>')
+		}
+		buffer.Write(line)
+	}
+	return buffer.Bytes()
+}
+
+// insertContentLength adds a Content-Length header field with the length of the body.
+func insertContentLength(raw []byte) []byte {
+	idx := bytes.Index(raw, []byte("\n\n"))
+	if idx < 0 {
+		return raw
+	}
+	buffer := &bytes.Buffer{}
+	buffer.Write(raw[:idx+1])
+	fmt.Fprintf(buffer, "Content-Length: %d\n", len(raw)-idx-2)
+	buffer.Write(raw[idx+1:])
+	return buffer.Bytes()
+}
@@ -0,0 +1,65 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package email
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestMboxReadWrite ...
+func TestMboxReadWrite(t *testing.T) {
+	t.Parallel()
+
+	expectedBodies := []string{
+		"From the start of a line\r\n>From an already quoted line\r\nnormal line",
+		"second message",
+	}
+	expectedDate := time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	for _, format := range []MboxFormat{MboxO, MboxRD, MboxCL2} {
+		buffer := &bytes.Buffer{}
+		writer := NewMboxWriter(buffer, format)
+		for _, body := range expectedBodies {
+			msg := NewPartText(body)
+			msg.Header.SetFrom("sender@host.com")
+			msg.Header.SetSubject("Mbox Test")
+			if err := writer.Write(&MboxMessage{Message: msg, EnvelopeDate: expectedDate}); err != nil {
+				t.Fatal("Could not write mbox message:", err)
+			}
+		}
+
+		if format != MboxCL2 && strings.Contains(buffer.String(), "\nFrom the start") {
+			t.Fatal("Mbox From line was not escaped for format", format)
+		}
+
+		reader := NewMboxReader(buffer, format)
+		for idx, body := range expectedBodies {
+			msg, err := reader.Next()
+			if err != nil {
+				t.Fatal("Could not read mbox message:", format, err)
+			}
+			if msg.EnvelopeSender != "sender@host.com" || !msg.EnvelopeDate.Equal(expectedDate) {
+				t.Fatal("Mbox envelope does not match expected values:", format, msg.EnvelopeSender, msg.EnvelopeDate)
+			}
+			// mbox messages always end with a new-line
+			actual := strings.TrimSuffix(strings.Replace(string(msg.Body), "\r\n", "\n", -1), "\n")
+			expected := strings.Replace(body, "\r\n", "\n", -1)
+			if format == MboxO && idx == 0 {
+				// mboxo can not distinguish escaped lines from lines that were already quoted
+				expected = strings.Replace(expected, ">From", "From", 1)
+			}
+			if actual != expected {
+				t.Fatalf("Mbox message body does not match expected for format %d: %q", format, actual)
+			}
+		}
+		if _, err := reader.Next(); err != io.EOF {
+			t.Fatal("Should be EOF", err)
+		}
+	}
+}
@@ -20,6 +20,48 @@ import (
 const (
 	// MaxBodyLineLength ...
 	MaxBodyLineLength = 76
+
+	// Max8BitLineLength is the longest line (excluding the CRLF) allowed in a 7bit or 8bit body
+	Max8BitLineLength = 998
+)
+
+// TransferEncoding is a Content-Transfer-Encoding that a body may be written out with.
+type TransferEncoding string
+
+const (
+	// TransferEncoding7Bit is unencoded US-ASCII, in lines of at most 998 characters
+	TransferEncoding7Bit TransferEncoding = "7bit"
+
+	// TransferEncoding8Bit is unencoded 8-bit text, in lines of at most 998 characters
+	TransferEncoding8Bit TransferEncoding = "8bit"
+
+	// TransferEncodingBinary is unencoded data of any kind
+	TransferEncodingBinary TransferEncoding = "binary"
+
+	// TransferEncodingQuotedPrintable encodes mostly US-ASCII text, escaping any other bytes
+	TransferEncodingQuotedPrintable TransferEncoding = "quoted-printable"
+
+	// TransferEncodingBase64 encodes any data as 7bit-safe base64
+	TransferEncodingBase64 TransferEncoding = "base64"
+)
+
+// EncodingPolicy decides which Content-Transfer-Encodings may be used when writing bodies,
+// depending on what the transport (such as an SMTP server) supports.
+type EncodingPolicy int
+
+const (
+	// Encoding7Bit only writes 7bit-safe bodies: text is quoted-printable encoded,
+	// and everything else is base64 encoded.
+	Encoding7Bit EncodingPolicy = iota
+
+	// Encoding8BitMIME writes text bodies unencoded as 8bit, unless they contain
+	// lines that are too long, NULs, or bare carriage returns.
+	// Requires the SMTP server to support 8BITMIME.
+	Encoding8BitMIME
+
+	// EncodingBinaryMIME writes all bodies unencoded, as 8bit text or binary.
+	// Requires the SMTP server to support BINARYMIME and CHUNKING (BDAT).
+	EncodingBinaryMIME
 )
 
 // Message represents a full email message, or a mime-message
@@ -57,8 +99,23 @@ type Message struct {
 	// whenever this message doesn't have a Content-Type of "multipart" or "message".
 	// The Body is already decoded if the Content-Transfer-Encoding was
 	// quoted-printable or base64, and will be re-encoded when written out
-	// based on the Content-Type.
+	// based on the Content-Type and an analysis of the Body.
 	Body []byte
+
+	// TransferEncoding optionally overrides the Content-Transfer-Encoding the Body
+	// is written out with, instead of one chosen by analyzing the Body.
+	// It is ignored if it is not allowed by the EncodingPolicy (such as 8bit without 8BITMIME),
+	// or if the Content-Transfer-Encoding header is set, as the Body is then assumed to already be encoded.
+	TransferEncoding TransferEncoding
+
+	// Raw optionally holds the exact bytes of this message, including its header, which signatures are verified against.
+	// It is set on the parts of a multipart/signed message, whether parsed by ParseMessage or created by a signer.
+	// It is not updated if the Header or payload are modified.
+	Raw []byte
+
+	// WriteRaw writes out the Raw bytes instead of the Header and payload, so that they never change.
+	// It is set by signers on the parts they create, and must be cleared if the part is modified.
+	WriteRaw bool
 }
 
 // Payload will return the payload of the message, which can only be one the
@@ -191,15 +248,47 @@ func (m *Message) Save() error {
 // Bytes returns the bytes representing this message.  It is a convenience
 // method that calls WriteTo on a buffer, returning its bytes.
 func (m *Message) Bytes() ([]byte, error) {
+	return m.BytesWithPolicy(Encoding7Bit)
+}
+
+// BytesWithPolicy returns the bytes representing this message, with its
+// bodies encoded according to the EncodingPolicy.
+func (m *Message) BytesWithPolicy(policy EncodingPolicy) ([]byte, error) {
 	buffer := &bytes.Buffer{}
-	_, err := m.WriteTo(buffer)
+	_, err := m.WriteToWithPolicy(buffer, policy)
 	return buffer.Bytes(), err
 }
 
+// SignedBytes returns the bytes representing this message, with its bodies encoded
+// according to the EncodingPolicy, and then signed by each MessageSigner in order
+// (such as a DKIMSigner), so that the signatures cover the exact bytes returned.
+func (m *Message) SignedBytes(policy EncodingPolicy, signers ...MessageSigner) ([]byte, error) {
+	b, err := m.BytesWithPolicy(policy)
+	if err != nil {
+		return nil, err
+	}
+	for _, signer := range signers {
+		if b, err = signer.Sign(b); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
 // WriteTo writes out this Message and its payloads, recursively.
-// Any text bodies will be quoted-printable encoded,
-// and all other bodies will be base64 encoded.
+// Each body is written as 7bit if possible, otherwise quoted-printable
+// or base64 encoded, whichever is smaller.
 func (m *Message) WriteTo(w io.Writer) (int64, error) {
+	return m.WriteToWithPolicy(w, Encoding7Bit)
+}
+
+// WriteToWithPolicy writes out this Message and its payloads, recursively,
+// with any bodies lacking a Content-Transfer-Encoding encoded according to the EncodingPolicy.
+func (m *Message) WriteToWithPolicy(w io.Writer, policy EncodingPolicy) (int64, error) {
+	if m.WriteRaw && m.Raw != nil {
+		written, err := w.Write(m.Raw)
+		return int64(written), err
+	}
 
 	total, err := m.Header.WriteTo(w)
 	if err != nil {
@@ -214,7 +303,7 @@ func (m *Message) WriteTo(w io.Writer) (int64, error) {
 	hasSubMessage := strings.HasPrefix(mediaType, "message")
 
 	if !hasParts && !hasSubMessage {
-		return m.writeBody(w, total)
+		return m.writeBody(w, total, policy)
 	}
 
 	written, err := io.WriteString(w, "\r\n")
@@ -224,16 +313,16 @@ func (m *Message) WriteTo(w io.Writer) (int64, error) {
 	}
 
 	if hasSubMessage {
-		written2, err := m.SubMessage.WriteTo(w)
+		written2, err := m.SubMessage.WriteToWithPolicy(w, policy)
 		return total + written2, err
 
 	}
 	// hasParts
-	return m.writeParts(w, mediaTypeParams["boundary"], total)
+	return m.writeParts(w, mediaTypeParams["boundary"], total, policy)
 }
 
 // writeParts ...
-func (m *Message) writeParts(w io.Writer, boundary string, total int64) (int64, error) {
+func (m *Message) writeParts(w io.Writer, boundary string, total int64, policy EncodingPolicy) (int64, error) {
 
 	if len(m.Preamble) > 0 {
 		written, err := fmt.Fprintf(w, "%s\r\n", m.Preamble)
@@ -249,7 +338,7 @@ func (m *Message) writeParts(w io.Writer, boundary string, total int64) (int64,
 		if err != nil {
 			return total, err
 		}
-		written2, err2 := part.WriteTo(w)
+		written2, err2 := part.WriteToWithPolicy(w, policy)
 		total += written2
 		if err2 != nil {
 			return total, err2
@@ -273,17 +362,28 @@ func (m *Message) writeParts(w io.Writer, boundary string, total int64) (int64,
 }
 
 // writeBody ...
-func (m *Message) writeBody(w io.Writer, total int64) (int64, error) {
+func (m *Message) writeBody(w io.Writer, total int64, policy EncodingPolicy) (int64, error) {
 	var written int
 	var err error
 
 	// Encode if we have Content-Type, and we do not have Content-Transfer-Encoding set
 	if contentType := m.Header.Get("Content-Type"); len(contentType) > 0 && !m.Header.IsSet("Content-Transfer-Encoding") {
 
-		if strings.HasPrefix(contentType, "text") {
-			return m.writeText(w, total)
+		text := strings.HasPrefix(contentType, "text")
+		encoding := m.TransferEncoding
+		if !encoding.allowed(policy) {
+			encoding = chooseTransferEncoding(m.Body, text, policy)
+		}
+
+		switch encoding {
+		case TransferEncoding7Bit, TransferEncoding8Bit:
+			return m.writeUnencoded(w, total, encoding, text)
+		case TransferEncodingBinary:
+			return m.writeBinary(w, total)
+		case TransferEncodingQuotedPrintable:
+			return m.writeQuotedPrintable(w, total, text)
 		}
-		return m.writeBase64(w, total)
+		return m.writeBase64(w, total, text)
 	}
 
 	written, err = io.WriteString(w, "\r\n")
@@ -295,8 +395,40 @@ func (m *Message) writeBody(w io.Writer, total int64) (int64, error) {
 	return total + int64(written), err
 }
 
-// writeText ...
-func (m *Message) writeText(w io.Writer, total int64) (int64, error) {
+// writeUnencoded writes a 7bit or 8bit body.
+// The Content-Transfer-Encoding is left out for 7bit, as it is the default.
+func (m *Message) writeUnencoded(w io.Writer, total int64, encoding TransferEncoding, text bool) (int64, error) {
+	header := "\r\n"
+	if encoding != TransferEncoding7Bit {
+		header = "Content-Transfer-Encoding: " + string(encoding) + "\r\n\r\n"
+	}
+	written, err := io.WriteString(w, header)
+	total += int64(written)
+	if err != nil {
+		return total, err
+	}
+	body := m.Body
+	if text {
+		// text lines must end in CRLF, just as quotedprintable would have written them
+		body = bytes.Replace(bytes.Replace(body, []byte("\r\n"), []byte("\n"), -1), []byte("\n"), []byte("\r\n"), -1)
+	}
+	written, err = w.Write(body)
+	return total + int64(written), err
+}
+
+// writeBinary ...
+func (m *Message) writeBinary(w io.Writer, total int64) (int64, error) {
+	written, err := io.WriteString(w, "Content-Transfer-Encoding: binary\r\n\r\n")
+	total += int64(written)
+	if err != nil {
+		return total, err
+	}
+	written, err = w.Write(m.Body)
+	return total + int64(written), err
+}
+
+// writeQuotedPrintable ...
+func (m *Message) writeQuotedPrintable(w io.Writer, total int64, text bool) (int64, error) {
 	written, err := io.WriteString(w, "Content-Transfer-Encoding: quoted-printable\r\n\r\n")
 	total += int64(written)
 	if err != nil {
@@ -304,21 +436,124 @@ func (m *Message) writeText(w io.Writer, total int64) (int64, error) {
 	}
 	// quotedprintable takes care of wrapping content at a good line length already
 	qpWriter := quotedprintable.NewWriter(w)
+	// Line breaks in text become CRLF, but must be kept exactly as they are in anything else
+	qpWriter.Binary = !text
 	written, err = qpWriter.Write(m.Body)
 	qpWriter.Close() // Must remember to close the wrapper, as it needs to flush to underlying writer
 	return total + int64(written), err
 }
 
 // writeBase64 ...
-func (m *Message) writeBase64(w io.Writer, total int64) (int64, error) {
+func (m *Message) writeBase64(w io.Writer, total int64, text bool) (int64, error) {
 	written, err := io.WriteString(w, "Content-Transfer-Encoding: base64\r\n\r\n")
 	total += int64(written)
 	if err != nil {
 		return total, err
 	}
+	body := m.Body
+	if text {
+		// text must be in its canonical form, with CRLF line breaks, before it is encoded
+		body = bytes.Replace(bytes.Replace(body, []byte("\r\n"), []byte("\n"), -1), []byte("\n"), []byte("\r\n"), -1)
+	}
 	// must wrap content at 76 characters
 	b64Writer := base64.NewEncoder(base64.StdEncoding, &base64Writer{w: w, maxLineLen: MaxBodyLineLength})
-	written, err = b64Writer.Write(m.Body)
+	written, err = b64Writer.Write(body)
 	b64Writer.Close() // Must remember to close the wrapper, as it needs to flush to underlying writer
 	return total + int64(written), err
 }
+
+// allowed returns true if this TransferEncoding is set, and is allowed by the EncodingPolicy.
+func (e TransferEncoding) allowed(policy EncodingPolicy) bool {
+	switch e {
+	case TransferEncoding7Bit, TransferEncodingQuotedPrintable, TransferEncodingBase64:
+		return true
+	case TransferEncoding8Bit:
+		return policy >= Encoding8BitMIME
+	case TransferEncodingBinary:
+		return policy >= EncodingBinaryMIME
+	}
+	return false
+}
+
+// bodyStats is the analysis of a body, used to choose its TransferEncoding.
+type bodyStats struct {
+	escaped       int  // number of bytes quoted-printable must escape
+	nonASCII      int  // number of bytes with the high bit set
+	longestLine   int  // longest line, excluding its line break
+	nul           bool // has a NUL byte
+	bareCR        bool // has a carriage return that is not part of a CRLF
+	bareLF        bool // has a line feed that is not part of a CRLF
+	trailingSpace bool // has a space or tab at the end of a line, which transports may strip
+}
+
+// analyzeBody returns the bodyStats of a body.
+// Line breaks are counted as escaped unless the body is text,
+// as only text line breaks may be canonicalized to CRLF.
+func analyzeBody(body []byte, text bool) bodyStats {
+	var s bodyStats
+	lineLen := 0
+	for i, c := range body {
+		switch {
+		case c == '\r' && i+1 < len(body) && body[i+1] == '\n':
+			// part of a CRLF, ended by the line feed
+			if !text {
+				s.escaped++
+			}
+			continue
+		case c == '\n':
+			end := i
+			if i > 0 && body[i-1] == '\r' {
+				end--
+			} else {
+				s.bareLF = true
+			}
+			if end > 0 && (body[end-1] == ' ' || body[end-1] == '\t') {
+				s.trailingSpace = true
+			}
+			if !text {
+				s.escaped++
+			}
+			lineLen = 0
+			continue
+		case c == '\r':
+			s.bareCR = true
+			s.escaped++
+		case c == 0:
+			s.nul = true
+			s.escaped++
+		case c >= 0x80:
+			s.nonASCII++
+			s.escaped++
+		case c == '=' || c == 0x7F || (c < ' ' && c != '\t'):
+			s.escaped++
+		}
+		lineLen++
+		if lineLen > s.longestLine {
+			s.longestLine = lineLen
+		}
+	}
+	if last := len(body) - 1; last >= 0 && (body[last] == ' ' || body[last] == '\t') {
+		s.trailingSpace = true
+	}
+	return s
+}
+
+// chooseTransferEncoding analyzes the body and returns the best TransferEncoding allowed by the EncodingPolicy:
+// 7bit or 8bit if the body may be sent unencoded, binary if allowed,
+// and otherwise quoted-printable or base64, whichever would be smaller.
+func chooseTransferEncoding(body []byte, text bool, policy EncodingPolicy) TransferEncoding {
+	s := analyzeBody(body, text)
+	unencoded := !s.nul && !s.bareCR && !s.trailingSpace && s.longestLine <= Max8BitLineLength && (text || !s.bareLF)
+	switch {
+	case unencoded && s.nonASCII == 0:
+		return TransferEncoding7Bit
+	case unencoded && policy >= Encoding8BitMIME:
+		return TransferEncoding8Bit
+	case policy >= EncodingBinaryMIME:
+		return TransferEncodingBinary
+	case len(body)+2*s.escaped <= (len(body)+2)/3*4:
+		// quoted-printable escapes take 3 bytes, while base64 takes 4 bytes for every 3
+		return TransferEncodingQuotedPrintable
+	}
+	return TransferEncodingBase64
+}
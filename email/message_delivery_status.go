@@ -10,8 +10,76 @@ import (
 	"errors"
 	"io"
 	"net/textproto"
+	"strings"
+	"time"
 )
 
+// DeliveryStatus represents the machine-readable part of a
+// Delivery Status Notification (DSN), as defined by RFC 3464.
+type DeliveryStatus struct {
+	// Per-message fields:
+
+	// ReportingMTA is the MTA that generated the DSN, such as "dns; mx.example.com".
+	ReportingMTA string
+
+	// OriginalEnvelopeID is the envelope ID of the original message, if any.
+	OriginalEnvelopeID string
+
+	// DSNGateway is the gateway that translated a foreign notification, if any.
+	DSNGateway string
+
+	// ReceivedFromMTA is the MTA the original message was received from.
+	ReceivedFromMTA string
+
+	// ArrivalDate is the time the original message arrived at the Reporting-MTA.
+	ArrivalDate time.Time
+
+	// Recipients are the per-recipient fields, one for each recipient reported on.
+	Recipients []RecipientStatus
+
+	// Header contains all per-message fields, including any that are not
+	// represented above (such as extension fields).
+	Header Header
+}
+
+// RecipientStatus represents the per-recipient fields of a
+// Delivery Status Notification (DSN), as defined by RFC 3464.
+type RecipientStatus struct {
+	// OriginalRecipient is the recipient as originally specified by the sender,
+	// such as "rfc822; user@example.com".
+	OriginalRecipient string
+
+	// FinalRecipient is the recipient the Reporting-MTA attempted delivery to,
+	// such as "rfc822; user@example.com".
+	FinalRecipient string
+
+	// Action is one of "failed", "delayed", "delivered", "relayed", or "expanded".
+	Action string
+
+	// Status is the transport-independent status code, such as "5.1.1".
+	Status string
+
+	// RemoteMTA is the MTA that reported the delivery status, if any.
+	RemoteMTA string
+
+	// DiagnosticCode is the status code reported by the Remote-MTA,
+	// such as "smtp; 550 5.1.1 User unknown".
+	DiagnosticCode string
+
+	// LastAttemptDate is the time of the last delivery attempt.
+	LastAttemptDate time.Time
+
+	// FinalLogID is the ID of the final log entry for this recipient.
+	FinalLogID string
+
+	// WillRetryUntil is the time after which delivery will no longer be attempted.
+	WillRetryUntil time.Time
+
+	// Header contains all per-recipient fields, including any that are not
+	// represented above (such as extension fields).
+	Header Header
+}
+
 // HasDeliveryStatusMessage returns true if this Message has a
 // content type of "message/delivery-status" and has a non-nil SubMessage
 // containing the delivery status information.
@@ -51,3 +119,104 @@ func (m *Message) DeliveryStatusRecipientDNS() ([]Header, error) {
 	}
 	return recipientDNS, nil
 }
+
+// DeliveryStatus parses and returns the per-message and per-recipient
+// delivery status fields, or an error if HasDeliveryStatusMessage would
+// return false. Fields which can not be parsed (such as a malformed
+// Arrival-Date) are left empty, but remain available in the Header fields.
+func (m *Message) DeliveryStatus() (*DeliveryStatus, error) {
+	messageDNS, err := m.DeliveryStatusMessageDNS()
+	if err != nil {
+		return nil, err
+	}
+	recipientDNS, err := m.DeliveryStatusRecipientDNS()
+	if err != nil {
+		return nil, err
+	}
+	status := &DeliveryStatus{
+		ReportingMTA:       messageDNS.Get("Reporting-Mta"),
+		OriginalEnvelopeID: messageDNS.Get("Original-Envelope-Id"),
+		DSNGateway:         messageDNS.Get("Dsn-Gateway"),
+		ReceivedFromMTA:    messageDNS.Get("Received-From-Mta"),
+		ArrivalDate:        parseDateOrZero(messageDNS.Get("Arrival-Date")),
+		Header:             messageDNS,
+	}
+	for _, h := range recipientDNS {
+		if len(h) == 0 {
+			continue
+		}
+		status.Recipients = append(status.Recipients, RecipientStatus{
+			OriginalRecipient: h.Get("Original-Recipient"),
+			FinalRecipient:    h.Get("Final-Recipient"),
+			Action:            strings.ToLower(h.Get("Action")),
+			Status:            h.Get("Status"),
+			RemoteMTA:         h.Get("Remote-Mta"),
+			DiagnosticCode:    h.Get("Diagnostic-Code"),
+			LastAttemptDate:   parseDateOrZero(h.Get("Last-Attempt-Date")),
+			FinalLogID:        h.Get("Final-Log-Id"),
+			WillRetryUntil:    parseDateOrZero(h.Get("Will-Retry-Until")),
+			Header:            h,
+		})
+	}
+	return status, nil
+}
+
+// DeliveryStatusOriginal returns the original message attached to a
+// multipart/report of report-type delivery-status, or an error if there
+// is none. If only the headers of the original message were attached
+// (as text/rfc822-headers), the returned Message will only have a Header.
+func (m *Message) DeliveryStatusOriginal() (*Message, error) {
+	return m.reportOriginal("delivery-status")
+}
+
+// fields returns the per-message fields, merged with any extension fields in Header.
+func (s *DeliveryStatus) fields() Header {
+	h := copyHeader(s.Header)
+	setIfNotEmpty(h, "Reporting-MTA", s.ReportingMTA)
+	setIfNotEmpty(h, "Original-Envelope-Id", s.OriginalEnvelopeID)
+	setIfNotEmpty(h, "DSN-Gateway", s.DSNGateway)
+	setIfNotEmpty(h, "Received-From-MTA", s.ReceivedFromMTA)
+	setDateIfNotZero(h, "Arrival-Date", s.ArrivalDate)
+	return h
+}
+
+// fields returns the per-recipient fields, merged with any extension fields in Header.
+func (r *RecipientStatus) fields() Header {
+	h := copyHeader(r.Header)
+	setIfNotEmpty(h, "Original-Recipient", r.OriginalRecipient)
+	setIfNotEmpty(h, "Final-Recipient", r.FinalRecipient)
+	setIfNotEmpty(h, "Action", r.Action)
+	setIfNotEmpty(h, "Status", r.Status)
+	setIfNotEmpty(h, "Remote-MTA", r.RemoteMTA)
+	setIfNotEmpty(h, "Diagnostic-Code", r.DiagnosticCode)
+	setDateIfNotZero(h, "Last-Attempt-Date", r.LastAttemptDate)
+	setIfNotEmpty(h, "Final-Log-ID", r.FinalLogID)
+	setDateIfNotZero(h, "Will-Retry-Until", r.WillRetryUntil)
+	return h
+}
+
+// recipientBytes returns the per-recipient field groups, each separated by a blank line.
+func (s *DeliveryStatus) recipientBytes() ([]byte, error) {
+	buffer := &bytes.Buffer{}
+	for idx := range s.Recipients {
+		if idx > 0 {
+			buffer.WriteString("\r\n")
+		}
+		if _, err := s.Recipients[idx].fields().WriteTo(buffer); err != nil {
+			return nil, err
+		}
+	}
+	return buffer.Bytes(), nil
+}
+
+// parseDateOrZero ...
+func parseDateOrZero(date string) time.Time {
+	if len(date) == 0 {
+		return time.Time{}
+	}
+	parsed, err := ParseDate(date)
+	if err != nil {
+		return time.Time{}
+	}
+	return parsed
+}
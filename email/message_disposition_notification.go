@@ -0,0 +1,201 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package email
+
+import (
+	"errors"
+	"strings"
+)
+
+// DispositionNotification represents the machine-readable part of a
+// Message Disposition Notification (MDN, or "read receipt"), as defined by RFC 8098.
+type DispositionNotification struct {
+	// ReportingUA is the user agent that generated the MDN, such as "host.com; go-email".
+	ReportingUA string
+
+	// MDNGateway is the gateway that translated a foreign notification, if any.
+	MDNGateway string
+
+	// OriginalRecipient is the recipient as originally specified by the sender,
+	// such as "rfc822; user@host.com".
+	OriginalRecipient string
+
+	// FinalRecipient is the recipient that the disposition applies to,
+	// such as "rfc822; user@host.com".
+	FinalRecipient string
+
+	// OriginalMessageID is the Message-ID of the original message, including angle brackets.
+	OriginalMessageID string
+
+	// Disposition is what happened to the original message.
+	Disposition Disposition
+
+	// Error contains any errors that occurred while generating the MDN.
+	Error []string
+
+	// Header contains all fields of the notification, including any that are not
+	// represented above (such as extension fields).
+	Header Header
+}
+
+// ErrDispositionMissing is returned when creating a Message Disposition Notification
+// without a Disposition action mode, sending mode, or type, which RFC 8098 requires.
+var ErrDispositionMissing = errors.New("Disposition notification is missing its disposition mode or type")
+
+// Disposition represents the Disposition field of a Message Disposition Notification.
+// Example: "manual-action/MDN-sent-manually; displayed"
+type Disposition struct {
+	// ActionMode is either "manual-action" or "automatic-action".
+	ActionMode string
+
+	// SendingMode is either "MDN-sent-manually" or "MDN-sent-automatically".
+	SendingMode string
+
+	// Type is one of "displayed", "deleted", "dispatched", or "processed".
+	Type string
+
+	// Modifiers are any disposition modifiers, such as "error".
+	Modifiers []string
+}
+
+// String returns the Disposition in its header field format.
+func (d Disposition) String() string {
+	s := d.ActionMode + "/" + d.SendingMode + "; " + d.Type
+	if len(d.Modifiers) > 0 {
+		s += "/" + strings.Join(d.Modifiers, ",")
+	}
+	return s
+}
+
+// parseDisposition ...
+func parseDisposition(s string) (Disposition, error) {
+	var d Disposition
+	modes, dispositionType, ok := strings.Cut(s, ";")
+	if !ok {
+		return d, errors.New("Disposition field is missing a disposition type")
+	}
+	d.ActionMode, d.SendingMode, _ = strings.Cut(removeComments(modes), "/")
+	d.ActionMode = strings.ToLower(strings.TrimSpace(d.ActionMode))
+	d.SendingMode = strings.TrimSpace(d.SendingMode)
+
+	dispositionType, modifiers, _ := strings.Cut(removeComments(dispositionType), "/")
+	d.Type = strings.ToLower(strings.TrimSpace(dispositionType))
+	for _, modifier := range strings.Split(modifiers, ",") {
+		if modifier = strings.TrimSpace(modifier); len(modifier) > 0 {
+			d.Modifiers = append(d.Modifiers, strings.ToLower(modifier))
+		}
+	}
+	if len(d.ActionMode) == 0 || len(d.SendingMode) == 0 || len(d.Type) == 0 {
+		return d, errors.New("Disposition field is malformed")
+	}
+	return d, nil
+}
+
+// HasDispositionNotificationMessage returns true if this Message has a
+// content type of "message/disposition-notification" and has a non-nil SubMessage.
+func (m *Message) HasDispositionNotificationMessage() bool {
+	contentType, _, err := m.Header.ContentType()
+	if err != nil {
+		return false
+	}
+	return contentType == "message/disposition-notification" && m.SubMessage != nil
+}
+
+// DispositionNotification parses and returns the disposition notification fields,
+// or an error if HasDispositionNotificationMessage would return false.
+func (m *Message) DispositionNotification() (*DispositionNotification, error) {
+	if !m.HasDispositionNotificationMessage() {
+		return nil, errors.New("Message does not have media content of type message/disposition-notification")
+	}
+	h := m.SubMessage.Header
+	notification := &DispositionNotification{
+		ReportingUA:       h.Get("Reporting-Ua"),
+		MDNGateway:        h.Get("Mdn-Gateway"),
+		OriginalRecipient: h.Get("Original-Recipient"),
+		FinalRecipient:    h.Get("Final-Recipient"),
+		OriginalMessageID: h.Get("Original-Message-Id"),
+		Error:             h["Error"],
+		Header:            h,
+	}
+	disposition, err := parseDisposition(h.Get("Disposition"))
+	notification.Disposition = disposition
+	if err != nil {
+		return notification, err
+	}
+	if len(notification.FinalRecipient) == 0 {
+		return notification, errors.New("Disposition notification is missing a required field (Final-Recipient)")
+	}
+	return notification, nil
+}
+
+// DispositionNotificationPart returns the message/disposition-notification part
+// of a multipart/report of report-type disposition-notification, or an error if there is none.
+func (m *Message) DispositionNotificationPart() (*Message, error) {
+	if !m.isReport("disposition-notification") {
+		return nil, errors.New("Message is not a multipart/report of report-type disposition-notification")
+	}
+	for _, part := range m.Parts {
+		if part.HasDispositionNotificationMessage() {
+			return part, nil
+		}
+	}
+	return nil, errors.New("Message does not have a message/disposition-notification part")
+}
+
+// DispositionNotificationOriginal returns the original message attached to a
+// multipart/report of report-type disposition-notification, or an error if there
+// is none. If only the headers of the original message were attached
+// (as text/rfc822-headers), the returned Message will only have a Header.
+func (m *Message) DispositionNotificationOriginal() (*Message, error) {
+	return m.reportOriginal("disposition-notification")
+}
+
+// fields returns the notification fields, merged with any extension fields in Header.
+func (n *DispositionNotification) fields() Header {
+	h := copyHeader(n.Header)
+	setIfNotEmpty(h, "Reporting-UA", n.ReportingUA)
+	setIfNotEmpty(h, "MDN-Gateway", n.MDNGateway)
+	setIfNotEmpty(h, "Original-Recipient", n.OriginalRecipient)
+	setIfNotEmpty(h, "Final-Recipient", n.FinalRecipient)
+	setIfNotEmpty(h, "Original-Message-ID", n.OriginalMessageID)
+	if len(n.Disposition.Type) > 0 {
+		h.Set("Disposition", n.Disposition.String())
+	}
+	setAllIfNotEmpty(h, "Error", n.Error)
+	return h
+}
+
+// removeComments removes any parenthesized comments from a header field value.
+func removeComments(s string) string {
+	if !strings.Contains(s, "(") {
+		return s
+	}
+	var b strings.Builder
+	depth := 0
+	escaped := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case escaped:
+			escaped = false
+			if depth == 0 {
+				b.WriteByte(c)
+			}
+			continue
+		case c == '\\':
+			escaped = true
+		case c == '(':
+			depth++
+			continue
+		case c == ')' && depth > 0:
+			depth--
+			continue
+		}
+		if depth == 0 {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
@@ -4,6 +4,69 @@
 
 package email
 
+import (
+	"bytes"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FeedbackReport represents the machine-readable part of an
+// Abuse Reporting Format (ARF) message, as defined by RFC 5965.
+type FeedbackReport struct {
+	// Required fields:
+
+	// FeedbackType is the type of feedback, such as "abuse", "fraud",
+	// "virus", "not-spam", or "other".
+	FeedbackType string
+
+	// UserAgent is the name and version of the software that generated the report.
+	UserAgent string
+
+	// Version is the version of the report format, which is currently always "1".
+	Version string
+
+	// Optional fields appearing once:
+
+	// ArrivalDate is the time the original message was received.
+	ArrivalDate time.Time
+
+	// Incidents is the number of incidents this report represents, defaulting to 1.
+	Incidents int
+
+	// OriginalEnvelopeID is the envelope ID of the original message, if any.
+	OriginalEnvelopeID string
+
+	// OriginalMailFrom is the SMTP MAIL FROM of the original message.
+	OriginalMailFrom string
+
+	// ReportingMTA is the MTA that generated the report, such as "dns; mx.example.com".
+	ReportingMTA string
+
+	// SourceIP is the IP address the original message was received from.
+	SourceIP net.IP
+
+	// Optional fields that may appear multiple times:
+
+	// AuthenticationResults are the Authentication-Results of the original message.
+	AuthenticationResults []string
+
+	// OriginalRcptTo are the SMTP RCPT TO's of the original message.
+	OriginalRcptTo []string
+
+	// ReportedDomain are the domains the report applies to.
+	ReportedDomain []string
+
+	// ReportedURI are the URI's the report applies to.
+	ReportedURI []string
+
+	// Header contains all fields of the report, including any that are not
+	// represented above (such as extension fields).
+	Header Header
+}
+
 // HasFeedbackReportMessage returns true if this Message has a
 // content type of "message/feedback-report" and has a non-nil SubMessage.
 func (m *Message) HasFeedbackReportMessage() bool {
@@ -14,4 +77,141 @@ func (m *Message) HasFeedbackReportMessage() bool {
 	return contentType == "message/feedback-report" && m.SubMessage != nil
 }
 
-// TODO: wip
+// FeedbackReport parses and returns the feedback report fields,
+// or an error if HasFeedbackReportMessage would return false.
+// Fields which can not be parsed (such as a malformed Arrival-Date)
+// are left empty, but remain available in the FeedbackReport's Header.
+func (m *Message) FeedbackReport() (*FeedbackReport, error) {
+	if !m.HasFeedbackReportMessage() {
+		return nil, errors.New("Message does not have media content of type message/feedback-report")
+	}
+	return parseFeedbackReport(m.SubMessage.Header)
+}
+
+// FeedbackReportOriginal returns the original message attached to a
+// multipart/report of report-type feedback-report, or an error if there
+// is none. If only the headers of the original message were attached
+// (as text/rfc822-headers), the returned Message will only have a Header.
+func (m *Message) FeedbackReportOriginal() (*Message, error) {
+	return m.reportOriginal("feedback-report")
+}
+
+// FeedbackReportPart returns the message/feedback-report part of a
+// multipart/report of report-type feedback-report, or an error if there is none.
+func (m *Message) FeedbackReportPart() (*Message, error) {
+	if !m.isReport("feedback-report") {
+		return nil, errors.New("Message is not a multipart/report of report-type feedback-report")
+	}
+	for _, part := range m.Parts {
+		if part.HasFeedbackReportMessage() {
+			return part, nil
+		}
+	}
+	return nil, errors.New("Message does not have a message/feedback-report part")
+}
+
+// parseFeedbackReport ...
+func parseFeedbackReport(h Header) (*FeedbackReport, error) {
+	report := &FeedbackReport{
+		FeedbackType:          strings.ToLower(h.Get("Feedback-Type")),
+		UserAgent:             h.Get("User-Agent"),
+		Version:               h.Get("Version"),
+		Incidents:             1,
+		OriginalEnvelopeID:    h.Get("Original-Envelope-Id"),
+		OriginalMailFrom:      trimAngleBrackets(h.Get("Original-Mail-From")),
+		ReportingMTA:          h.Get("Reporting-Mta"),
+		AuthenticationResults: h["Authentication-Results"],
+		ReportedDomain:        h["Reported-Domain"],
+		ReportedURI:           h["Reported-Uri"],
+		Header:                h,
+	}
+	for _, rcpt := range h["Original-Rcpt-To"] {
+		report.OriginalRcptTo = append(report.OriginalRcptTo, trimAngleBrackets(rcpt))
+	}
+	report.ArrivalDate = parseDateOrZero(h.Get("Arrival-Date"))
+	if incidents, err := strconv.Atoi(h.Get("Incidents")); err == nil && incidents > 0 {
+		report.Incidents = incidents
+	}
+	report.SourceIP = net.ParseIP(h.Get("Source-Ip"))
+
+	if len(report.FeedbackType) == 0 || len(report.UserAgent) == 0 || len(report.Version) == 0 {
+		return report, errors.New("Feedback report is missing a required field (Feedback-Type, User-Agent, or Version)")
+	}
+	return report, nil
+}
+
+// fields returns the report fields, merged with any extension fields in Header.
+func (r *FeedbackReport) fields() Header {
+	h := copyHeader(r.Header)
+	setIfNotEmpty(h, "Feedback-Type", r.FeedbackType)
+	setIfNotEmpty(h, "User-Agent", r.UserAgent)
+	setIfNotEmpty(h, "Version", r.Version)
+	setDateIfNotZero(h, "Arrival-Date", r.ArrivalDate)
+	if r.Incidents > 1 {
+		h.Set("Incidents", strconv.Itoa(r.Incidents))
+	}
+	setIfNotEmpty(h, "Original-Envelope-Id", r.OriginalEnvelopeID)
+	if len(r.OriginalMailFrom) > 0 {
+		h.Set("Original-Mail-From", "<"+r.OriginalMailFrom+">")
+	}
+	setIfNotEmpty(h, "Reporting-MTA", r.ReportingMTA)
+	if r.SourceIP != nil {
+		h.Set("Source-IP", r.SourceIP.String())
+	}
+	setAllIfNotEmpty(h, "Authentication-Results", r.AuthenticationResults)
+	if len(r.OriginalRcptTo) > 0 {
+		h.Del("Original-Rcpt-To")
+		for _, rcpt := range r.OriginalRcptTo {
+			h.Add("Original-Rcpt-To", "<"+rcpt+">")
+		}
+	}
+	setAllIfNotEmpty(h, "Reported-Domain", r.ReportedDomain)
+	setAllIfNotEmpty(h, "Reported-URI", r.ReportedURI)
+	return h
+}
+
+// isReport returns true if this Message is a multipart/report of this report-type.
+func (m *Message) isReport(reportType string) bool {
+	mediaType, params, err := m.Header.ContentType()
+	if err != nil {
+		return false
+	}
+	return mediaType == "multipart/report" && strings.EqualFold(params["report-type"], reportType)
+}
+
+// reportOriginal returns the original message (message/rfc822), or the
+// original headers (text/rfc822-headers) as a Message, that is attached
+// to a multipart/report of this report-type.
+func (m *Message) reportOriginal(reportType string) (*Message, error) {
+	if !m.isReport(reportType) {
+		return nil, errors.New("Message is not a multipart/report of report-type " + reportType)
+	}
+	for _, part := range m.Parts {
+		mediaType, _, err := part.Header.ContentType()
+		if err != nil {
+			continue
+		}
+		switch mediaType {
+		case "message/rfc822":
+			if part.SubMessage != nil {
+				return part.SubMessage, nil
+			}
+		case "text/rfc822-headers":
+			h, err := ParseHeader(bytes.NewReader(part.Body))
+			if err != nil {
+				return nil, err
+			}
+			return &Message{Header: h}, nil
+		}
+	}
+	return nil, errors.New("Message does not have an attached original message or headers")
+}
+
+// trimAngleBrackets ...
+func trimAngleBrackets(s string) string {
+	s = strings.TrimSpace(s)
+	if len(s) >= 2 && s[0] == '<' && s[len(s)-1] == '>' {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
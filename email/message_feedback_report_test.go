@@ -0,0 +1,108 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package email
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testFeedbackReport = "From: <abusedesk@example.com>\r\n" +
+	"Date: Thu, 8 Mar 2005 17:40:36 EDT\r\n" +
+	"Subject: FW: Earn money\r\n" +
+	"To: <abuse@example.net>\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/report; report-type=feedback-report;\r\n" +
+	"     boundary=\"part1_13d.2e68ed54_boundary\"\r\n" +
+	"\r\n" +
+	"--part1_13d.2e68ed54_boundary\r\n" +
+	"Content-Type: text/plain; charset=\"US-ASCII\"\r\n" +
+	"Content-Transfer-Encoding: 7bit\r\n" +
+	"\r\n" +
+	"This is an email abuse report for an email message received from IP\r\n" +
+	"192.0.2.1 on Thu, 8 Mar 2005 14:00:00 EDT.\r\n" +
+	"\r\n" +
+	"--part1_13d.2e68ed54_boundary\r\n" +
+	"Content-Type: message/feedback-report\r\n" +
+	"\r\n" +
+	"Feedback-Type: abuse\r\n" +
+	"User-Agent: SomeGenerator/1.0\r\n" +
+	"Version: 1\r\n" +
+	"Original-Mail-From: <somespammer@example.net>\r\n" +
+	"Original-Rcpt-To: <user@example.com>\r\n" +
+	"Arrival-Date: Thu, 8 Mar 2005 14:00:00 EDT\r\n" +
+	"Reporting-MTA: dns; mail.example.com\r\n" +
+	"Source-IP: 192.0.2.1\r\n" +
+	"Authentication-Results: mail.example.com;\r\n" +
+	"               spf=fail smtp.mail=somespammer@example.com\r\n" +
+	"Reported-Domain: example.net\r\n" +
+	"Reported-Uri: http://example.net/earn_money.html\r\n" +
+	"Reported-Uri: mailto:user@example.com\r\n" +
+	"Removal-Recipient: user@example.com\r\n" +
+	"\r\n" +
+	"--part1_13d.2e68ed54_boundary\r\n" +
+	"Content-Type: message/rfc822\r\n" +
+	"Content-Disposition: inline\r\n" +
+	"\r\n" +
+	"From: <somespammer@example.net>\r\n" +
+	"Received: from mailserver.example.net (mailserver.example.net\r\n" +
+	"        [192.0.2.1]) by example.com with ESMTP id M63d4137594e46;\r\n" +
+	"        Thu, 08 Mar 2005 14:00:00 -0400\r\n" +
+	"To: <Undisclosed Recipients>\r\n" +
+	"Subject: Earn money\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-type: text/plain\r\n" +
+	"Message-ID: 8787KJKJ3K4J3K4J3K4J3.mail@example.net\r\n" +
+	"Date: Thu, 02 Sep 2004 12:31:03 -0500\r\n" +
+	"\r\n" +
+	"Spam Spam Spam\r\n" +
+	"Spam Spam Spam\r\n" +
+	"--part1_13d.2e68ed54_boundary--\r\n"
+
+// TestFeedbackReportParsing ...
+func TestFeedbackReportParsing(t *testing.T) {
+	t.Parallel()
+
+	msg, err := ParseMessage(strings.NewReader(testFeedbackReport))
+	if err != nil {
+		t.Fatal("Could not parse feedback report:", err)
+	}
+
+	part, err := msg.FeedbackReportPart()
+	if err != nil {
+		t.Fatal("Could not find feedback report part:", err)
+	}
+	report, err := part.FeedbackReport()
+	if err != nil {
+		t.Fatal("Could not parse feedback report fields:", err)
+	}
+
+	if report.FeedbackType != "abuse" || report.UserAgent != "SomeGenerator/1.0" || report.Version != "1" ||
+		report.OriginalMailFrom != "somespammer@example.net" ||
+		!reflect.DeepEqual(report.OriginalRcptTo, []string{"user@example.com"}) ||
+		report.ReportingMTA != "dns; mail.example.com" || report.Incidents != 1 {
+		t.Fatal("Feedback report fields do not match expected values:", report)
+	}
+	if report.SourceIP.String() != "192.0.2.1" || report.ArrivalDate.IsZero() {
+		t.Fatal("Feedback report Source-IP or Arrival-Date not parsed:", report.SourceIP, report.ArrivalDate)
+	}
+	if !reflect.DeepEqual(report.ReportedDomain, []string{"example.net"}) ||
+		!reflect.DeepEqual(report.ReportedURI, []string{"http://example.net/earn_money.html", "mailto:user@example.com"}) ||
+		len(report.AuthenticationResults) != 1 {
+		t.Fatal("Feedback report multi-value fields do not match expected values:", report)
+	}
+	if report.Header.Get("Removal-Recipient") != "user@example.com" {
+		t.Fatal("Feedback report extension fields should remain in the Header")
+	}
+
+	original, err := msg.FeedbackReportOriginal()
+	if err != nil {
+		t.Fatal("Could not find original message:", err)
+	}
+	if original.Header.Subject() != "Earn money" || !strings.HasPrefix(string(original.Body), "Spam Spam Spam") {
+		t.Fatal("Original message does not match expected content")
+	}
+}
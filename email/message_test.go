@@ -0,0 +1,216 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package email
+
+import (
+	"bytes"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+var encodingRegexp = regexp.MustCompile(`Content-Transfer-Encoding: (.+)\r\n`)
+
+// TestTransferEncodingSelection ...
+func TestTransferEncodingSelection(t *testing.T) {
+	t.Parallel()
+
+	longLine := strings.Repeat("a", Max8BitLineLength+1)
+
+	testCases := []struct {
+		contentType string
+		body        string
+		override    TransferEncoding
+		policy      EncodingPolicy
+		expected    TransferEncoding
+	}{
+		{"text/plain", "plain ascii\nlines", "", Encoding7Bit, TransferEncoding7Bit},
+		{"text/plain", "trailing space \r\n", "", Encoding7Bit, TransferEncodingQuotedPrintable},
+		{"text/plain", "mostly ascii, with a little unicode: é", "", Encoding7Bit, TransferEncodingQuotedPrintable},
+		{"text/plain", "非常感谢你", "", Encoding7Bit, TransferEncodingBase64},
+		{"text/plain", "非常感谢你", "", Encoding8BitMIME, TransferEncoding8Bit},
+		{"text/plain", longLine, "", Encoding7Bit, TransferEncodingQuotedPrintable},
+		{"text/plain", longLine, "", Encoding8BitMIME, TransferEncodingQuotedPrintable},
+		{"text/plain", longLine, "", EncodingBinaryMIME, TransferEncodingBinary},
+		{"application/json", `{"key": "value"}`, "", Encoding7Bit, TransferEncoding7Bit},
+		{"image/svg+xml", "<svg>\n</svg>\n", "", Encoding7Bit, TransferEncodingQuotedPrintable},
+		{"application/octet-stream", "\x00\x01\x02\r\xFF", "", Encoding7Bit, TransferEncodingBase64},
+		{"application/octet-stream", "\x00\x01\x02\r\xFF", "", Encoding8BitMIME, TransferEncodingBase64},
+		{"application/octet-stream", "\x00\x01\x02\r\xFF", "", EncodingBinaryMIME, TransferEncodingBinary},
+		{"text/plain", "plain ascii", TransferEncodingBase64, Encoding7Bit, TransferEncodingBase64},
+		{"text/plain", "非常感谢你", TransferEncoding8Bit, Encoding7Bit, TransferEncodingBase64},
+	}
+
+	for idx, tc := range testCases {
+		msg := NewPartMultipart("mixed", newPartFromBytes([]byte(tc.body), tc.contentType, "", ""))
+		msg.Parts[0].TransferEncoding = tc.override
+
+		b, err := msg.BytesWithPolicy(tc.policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expectedHeader := "Content-Transfer-Encoding: " + string(tc.expected) + "\r\n"
+		hasHeader := bytes.Contains(b, []byte("Content-Transfer-Encoding:"))
+		if tc.expected == TransferEncoding7Bit && hasHeader || tc.expected != TransferEncoding7Bit && !bytes.Contains(b, []byte(expectedHeader)) {
+			t.Errorf("Case %d: expected %s; got:\n%s", idx, tc.expected, b)
+		}
+
+		parsed, err := ParseMessage(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectedBody := tc.body
+		if strings.HasPrefix(tc.contentType, "text") {
+			expectedBody = strings.Replace(strings.Replace(expectedBody, "\r\n", "\n", -1), "\n", "\r\n", -1)
+		}
+		if string(parsed.Parts[0].Body) != expectedBody {
+			t.Errorf("Case %d: expected body %q; got %q", idx, expectedBody, parsed.Parts[0].Body)
+		}
+	}
+
+	// Each policy applied to a whole message
+	binary := []byte{0, 1, 2, '\r', 0xFF}
+	policyCases := []struct {
+		policy   EncodingPolicy
+		expected []string // Content-Transfer-Encoding of: text, long text, attachment
+	}{
+		{Encoding7Bit, []string{"base64", "quoted-printable", "base64"}},
+		{Encoding8BitMIME, []string{"8bit", "quoted-printable", "base64"}},
+		{EncodingBinaryMIME, []string{"8bit", "binary", "binary"}},
+	}
+
+	for _, tc := range policyCases {
+		msg := NewPartMultipart("mixed",
+			NewPartText("非常感谢你\nthank you"),
+			NewPartText(longLine),
+			NewPartAttachmentFromBytes(binary, "test.bin"))
+
+		b, err := msg.BytesWithPolicy(tc.policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed, err := ParseMessage(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var encodings []string
+		for _, match := range encodingRegexp.FindAllSubmatch(b, -1) {
+			encodings = append(encodings, string(match[1]))
+		}
+		if !reflect.DeepEqual(encodings, tc.expected) {
+			t.Errorf("Policy %d: expected encodings %v; got %v", tc.policy, tc.expected, encodings)
+		}
+		if string(parsed.Parts[0].Body) != "非常感谢你\r\nthank you" {
+			t.Errorf("Policy %d: text body does not match; got %q", tc.policy, parsed.Parts[0].Body)
+		}
+		if string(parsed.Parts[1].Body) != longLine {
+			t.Errorf("Policy %d: long text body does not match", tc.policy)
+		}
+		if !bytes.Equal(parsed.Parts[2].Body, binary) {
+			t.Errorf("Policy %d: attachment body does not match; got %v", tc.policy, parsed.Parts[2].Body)
+		}
+	}
+}
+
+// TestDeterministicSerialization ...
+func TestDeterministicSerialization(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC)
+	newTestMessage := func() *Message {
+		return NewMessage(NewHeader("from@host.com", "Test Subject", "to@host.com"),
+			"Test text", "<p>Test html</p>",
+			NewPartAttachmentFromBytes([]byte("attachment contents"), "test.txt"))
+	}
+
+	first := newTestMessage()
+	second := newTestMessage()
+	for _, msg := range []*Message{first, second} {
+		if err := msg.SaveWith(NewDeterministicGenerator(now, "example.com")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	firstBytes, err := first.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	secondBytes, err := second.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(firstBytes, secondBytes) {
+		t.Fatalf("Deterministic messages do not match:\n%s\n%s", firstBytes, secondBytes)
+	}
+	if first.Header.Get("Message-Id") != "<1@example.com>" || first.Header.Get("Date") != now.Format(time.RFC1123Z) {
+		t.Errorf("Unexpected Message-Id or Date: %v", first.Header)
+	}
+
+	// A randomly generated message only differs in its boundaries
+	random := newTestMessage()
+	random.Header.Set("Message-Id", first.Header.Get("Message-Id"))
+	random.Header.Set("Date", first.Header.Get("Date"))
+	if err = random.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if !EqualIgnoringBoundaries(first, random) {
+		t.Error("Messages should be equal when ignoring boundaries")
+	}
+	random.Parts[1].Body = []byte("different contents")
+	if EqualIgnoringBoundaries(first, random) {
+		t.Error("Messages with different bodies should not be equal")
+	}
+}
+
+// TestParseSignedRawParts ...
+func TestParseSignedRawParts(t *testing.T) {
+	t.Parallel()
+
+	signedPart := "Content-Type: text/plain;\n charset=\"UTF-8\"\nContent-Transfer-Encoding: quoted-printable\nSubject: =?UTF-8?Q?caf=C3=A9?=\n\nSee you at dinner.=20\nJoe"
+	signaturePart := "Content-Type: application/pgp-signature\n\n-----BEGIN PGP SIGNATURE-----\n...\n-----END PGP SIGNATURE-----\n"
+	raw := "From: joe@example.com\nContent-Type: multipart/mixed; boundary=outer\n\n--outer\n" +
+		"Content-Type: multipart/signed; protocol=\"application/pgp-signature\"; micalg=pgp-sha256; boundary=inner\n\n" +
+		"This is a signed message\n--inner\n" + signedPart + "\n--inner \n" + signaturePart + "\n--inner--\n\n--outer--\n"
+
+	msg, err := ParseMessage(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msg.Parts) != 1 || len(msg.Parts[0].Parts) != 2 {
+		t.Fatalf("Unexpected parts: %+v", msg)
+	}
+	signed := msg.Parts[0]
+	if msg.Parts[0].Raw != nil || string(signed.Parts[0].Body) != "See you at dinner. \nJoe" {
+		t.Errorf("Expected the signed part to still be parsed and decoded: %+v", signed.Parts[0])
+	}
+	crlf := func(s string) string { return strings.Replace(s, "\n", "\r\n", -1) }
+	if string(signed.Parts[0].Raw) != crlf(signedPart) {
+		t.Errorf("Expected raw signed part %q; got %q", crlf(signedPart), signed.Parts[0].Raw)
+	}
+	if string(signed.Parts[1].Raw) != crlf(signaturePart) {
+		t.Errorf("Expected raw signature part %q; got %q", crlf(signaturePart), signed.Parts[1].Raw)
+	}
+
+	// Edits to the parsed parts are written out, unless their Raw bytes are asked for
+	signed.Parts[0].Body = []byte("See you at lunch.")
+	signed.Parts[0].Header.Set("X-Edited", "yes")
+	b, err := msg.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(b, []byte("X-Edited: yes\r\n")) || !bytes.Contains(b, []byte("See you at lunch.")) || bytes.Contains(b, []byte("dinner")) {
+		t.Errorf("Expected the edited signed part to be written out:\n%s", b)
+	}
+	signed.Parts[0].WriteRaw = true
+	if b, err = msg.Bytes(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(b, []byte("\r\n"+crlf(signedPart)+"\r\n--")) {
+		t.Errorf("Expected the raw signed part to be written out:\n%s", b)
+	}
+}
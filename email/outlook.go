@@ -0,0 +1,324 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package email
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/mail"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Outlook .msg constants, from MS-OXMSG
+const (
+	outlookPropertiesStream  = "__properties_version1.0"
+	outlookRecipientPrefix   = "__recip_version1.0_#"
+	outlookAttachmentPrefix  = "__attach_version1.0_#"
+	outlookEmbeddedMessage   = "__substg1.0_3701000D"
+	outlookTopHeaderSize     = 32
+	outlookEmbeddedSize      = 24
+	outlookSubHeaderSize     = 8
+	outlookAttachEmbeddedMsg = 5
+
+	// MAPI property ID's, in addition to those used by TNEF
+	mapiClientSubmitTime         = 0x0039
+	mapiSentRepresentingName     = 0x0042
+	mapiSentRepresentingEmail    = 0x0065
+	mapiTransportMessageHeaders  = 0x007D
+	mapiRecipientType            = 0x0C15
+	mapiSenderName               = 0x0C1A
+	mapiSenderEmail              = 0x0C1F
+	mapiMessageDeliveryTime      = 0x0E06
+	mapiInternetReferences       = 0x1039
+	mapiInReplyToID              = 0x1042
+	mapiEmailAddress             = 0x3003
+	mapiAttachMethod             = 0x3705
+	mapiSMTPAddress              = 0x39FE
+	mapiInternetCodepage         = 0x3FDE
+	mapiSenderSMTPAddress        = 0x5D01
+	mapiSentRepresentingSMTPAddr = 0x5D02
+
+	// filetimeUnixOffset is the number of 100 nanosecond intervals between 1601 and 1970.
+	filetimeUnixOffset = 116444736000000000
+)
+
+// outlookCodepages maps Windows codepages to their MIME charset names.
+var outlookCodepages = map[uint32]string{
+	1250:  "windows-1250",
+	1251:  "windows-1251",
+	1252:  "windows-1252",
+	20127: "us-ascii",
+	28591: "iso-8859-1",
+	28592: "iso-8859-2",
+	65001: "UTF-8",
+}
+
+// outlookStorage is a storage of an Outlook .msg file, representing a
+// message, recipient, or attachment, with its fixed-length properties.
+type outlookStorage struct {
+	*cfbStorage
+	fixed map[uint16][]byte
+}
+
+// ParseOutlookMessage parses and returns a Message from an io.Reader
+// containing an Outlook .msg file (a Compound File Binary file, as
+// defined by MS-OXMSG).
+// The original internet headers are used if Outlook kept them, otherwise
+// the headers are created from the message's properties.
+// The plain text, html, and rtf bodies (if any) become a
+// "multipart/alternative" part, followed by any attachments, with
+// embedded messages attached as "message/rfc822" parts.
+func ParseOutlookMessage(r io.Reader) (*Message, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	cf, err := parseCompoundFile(data)
+	if err != nil {
+		return nil, err
+	}
+	root, err := cf.root()
+	if err != nil {
+		return nil, err
+	}
+	return outlookMessage(root, outlookTopHeaderSize, 0)
+}
+
+// newOutlookStorage reads the fixed-length properties of a storage,
+// whose properties stream starts with a header of this size.
+func newOutlookStorage(storage *cfbStorage, headerSize int) *outlookStorage {
+	s := &outlookStorage{cfbStorage: storage, fixed: map[uint16][]byte{}}
+	properties := storage.streams[outlookPropertiesStream]
+	for i := headerSize; i+16 <= len(properties); i += 16 {
+		tag := binary.LittleEndian.Uint32(properties[i:])
+		s.fixed[uint16(tag>>16)] = properties[i+8 : i+16]
+	}
+	return s
+}
+
+// string returns a string property, whether stored as unicode or 8-bit.
+func (s *outlookStorage) string(id uint16) string {
+	if b, ok := s.streams[fmt.Sprintf("__substg1.0_%04X%04X", id, mapiTypeUnicode)]; ok {
+		return decodeUTF16(b)
+	}
+	return string(trimNulls(s.streams[fmt.Sprintf("__substg1.0_%04X%04X", id, mapiTypeString8)]))
+}
+
+// binary returns a binary property.
+func (s *outlookStorage) binary(id uint16) []byte {
+	return s.streams[fmt.Sprintf("__substg1.0_%04X%04X", id, mapiTypeBinary)]
+}
+
+// uint32 returns a 32-bit integer property.
+func (s *outlookStorage) uint32(id uint16) (uint32, bool) {
+	value, ok := s.fixed[id]
+	if !ok {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint32(value), true
+}
+
+// time returns a time property.
+func (s *outlookStorage) time(id uint16) time.Time {
+	value, ok := s.fixed[id]
+	if !ok {
+		return time.Time{}
+	}
+	filetime := int64(binary.LittleEndian.Uint64(value))
+	if filetime <= filetimeUnixOffset {
+		return time.Time{}
+	}
+	return time.Unix(0, (filetime-filetimeUnixOffset)*100).UTC()
+}
+
+// substorages returns the child storages with this name prefix, in order.
+func (s *outlookStorage) substorages(prefix string) []*cfbStorage {
+	names := make([]string, 0, len(s.storages))
+	for name := range s.storages {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	storages := make([]*cfbStorage, 0, len(names))
+	for _, name := range names {
+		storages = append(storages, s.storages[name])
+	}
+	return storages
+}
+
+// outlookMessage converts a message storage into a Message.
+func outlookMessage(storage *cfbStorage, headerSize int, depth int) (*Message, error) {
+	s := newOutlookStorage(storage, headerSize)
+	headers := s.headers()
+
+	var bodies []*Message
+	if text := s.string(mapiBody); len(text) > 0 {
+		bodies = append(bodies, NewPartText(text))
+	}
+	html := s.binary(mapiBodyHTML)
+	if len(html) == 0 {
+		html = []byte(s.string(mapiBodyHTML))
+	}
+	if rtf := s.binary(mapiRTFCompressed); len(html) == 0 && len(rtf) > 0 {
+		if decompressed, err := DecompressRTF(rtf); err == nil {
+			bodies = append(bodies, newPartFromBytes(decompressed, "application/rtf", "", ""))
+		}
+	}
+
+	var inlines, attachments []*Message
+	for _, sub := range s.substorages(outlookAttachmentPrefix) {
+		part, err := outlookAttachment(sub, depth)
+		if err != nil {
+			return nil, err
+		}
+		if part.Header.IsSet("Content-ID") && len(html) > 0 {
+			inlines = append(inlines, part)
+		} else {
+			attachments = append(attachments, part)
+		}
+	}
+
+	if len(html) > 0 {
+		htmlPart := NewPartHTML(string(trimNulls(html)))
+		if codepage, ok := s.uint32(mapiInternetCodepage); ok && len(s.binary(mapiBodyHTML)) > 0 {
+			if charset, ok := outlookCodepages[codepage]; ok {
+				htmlPart.Header.Set("Content-Type", mime.FormatMediaType("text/html", map[string]string{"charset": charset}))
+			}
+		}
+		if len(inlines) > 0 {
+			htmlPart = NewPartMultipart("related", append([]*Message{htmlPart}, inlines...)...)
+		}
+		bodies = append(bodies, htmlPart)
+	}
+
+	parts := make([]*Message, 0, 1+len(attachments))
+	if len(bodies) == 1 {
+		parts = append(parts, bodies[0])
+	} else if len(bodies) > 1 {
+		parts = append(parts, NewPartMultipart("alternative", bodies...))
+	}
+	parts = append(parts, attachments...)
+
+	headers.Set("Content-Type", "multipart/mixed; boundary=\""+randomBoundary()+"\"")
+	return &Message{Header: headers, Parts: parts}, nil
+}
+
+// headers returns the original internet headers of a message storage,
+// if Outlook kept them, filling in any missing fields from its properties.
+func (s *outlookStorage) headers() Header {
+	headers := Header{}
+	if transport := s.string(mapiTransportMessageHeaders); len(transport) > 0 {
+		if parsed, err := ParseHeader(strings.NewReader(transport + "\r\n\r\n")); err == nil {
+			headers = parsed
+		}
+	}
+	// The structure of the message is rebuilt, so the original content fields no longer apply
+	for _, field := range []string{"Content-Type", "Content-Transfer-Encoding", "Content-Disposition"} {
+		headers.Del(field)
+	}
+	headers.Set("MIME-Version", "1.0")
+
+	if !headers.IsSet("From") {
+		if from := s.address(mapiSenderName, mapiSenderSMTPAddress, mapiSenderEmail); len(from) > 0 {
+			headers.SetFrom(from)
+		} else if from = s.address(mapiSentRepresentingName, mapiSentRepresentingSMTPAddr, mapiSentRepresentingEmail); len(from) > 0 {
+			headers.SetFrom(from)
+		}
+	}
+	if !headers.IsSet("To") && !headers.IsSet("Cc") && !headers.IsSet("Bcc") {
+		recipients := map[uint32][]string{}
+		for _, sub := range s.substorages(outlookRecipientPrefix) {
+			recipient := newOutlookStorage(sub, outlookSubHeaderSize)
+			recipientType, _ := recipient.uint32(mapiRecipientType)
+			if address := recipient.address(mapiDisplayName, mapiSMTPAddress, mapiEmailAddress); len(address) > 0 {
+				recipients[recipientType] = append(recipients[recipientType], address)
+			}
+		}
+		if len(recipients[1]) > 0 {
+			headers.SetTo(recipients[1]...)
+		}
+		if len(recipients[2]) > 0 {
+			headers.SetCc(recipients[2]...)
+		}
+		if len(recipients[3]) > 0 {
+			headers.SetBcc(recipients[3]...)
+		}
+	}
+	if subject := s.string(mapiSubject); !headers.IsSet("Subject") && len(subject) > 0 {
+		headers.SetSubject(subject)
+	}
+	if !headers.IsSet("Date") {
+		date := s.time(mapiClientSubmitTime)
+		if date.IsZero() {
+			date = s.time(mapiMessageDeliveryTime)
+		}
+		setDateIfNotZero(headers, "Date", date)
+	}
+	for field, id := range map[string]uint16{"Message-Id": mapiInternetMessageID, "In-Reply-To": mapiInReplyToID, "References": mapiInternetReferences} {
+		if value := s.string(id); !headers.IsSet(field) && len(value) > 0 {
+			headers.Set(field, value)
+		}
+	}
+	return headers
+}
+
+// address returns a formatted address from the name and address properties,
+// preferring the SMTP address over the (possibly Exchange X.500) email address.
+func (s *outlookStorage) address(nameID uint16, smtpID uint16, emailID uint16) string {
+	address := s.string(smtpID)
+	if !strings.Contains(address, "@") {
+		address = s.string(emailID)
+	}
+	if !strings.Contains(address, "@") {
+		return ""
+	}
+	name := s.string(nameID)
+	if len(name) == 0 || name == address {
+		return address
+	}
+	return (&mail.Address{Name: name, Address: address}).String()
+}
+
+// outlookAttachment converts an attachment storage into a part.
+func outlookAttachment(storage *cfbStorage, depth int) (*Message, error) {
+	s := newOutlookStorage(storage, outlookSubHeaderSize)
+
+	if method, _ := s.uint32(mapiAttachMethod); method == outlookAttachEmbeddedMsg {
+		embedded, ok := s.storages[outlookEmbeddedMessage]
+		if ok && depth < 32 {
+			msg, err := outlookMessage(embedded, outlookEmbeddedSize, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			return NewPartRFC822(msg), nil
+		}
+	}
+
+	filename := s.string(mapiAttachLongFilename)
+	if len(filename) == 0 {
+		filename = s.string(mapiAttachFilename)
+	}
+	if len(filename) == 0 {
+		filename = s.string(mapiDisplayName)
+	}
+	contentType := s.string(mapiAttachMIMETag)
+	if len(contentType) == 0 {
+		contentType = mime.TypeByExtension(filepath.Ext(filename))
+	}
+	contentID := trimAngleBrackets(s.string(mapiAttachContentID))
+	disposition := "attachment"
+	if len(contentID) > 0 {
+		disposition = "inline"
+	}
+	return newPartFromBytes(s.binary(mapiAttachDataObj), contentType,
+		mime.FormatMediaType(disposition, map[string]string{"filename": filename}), contentID), nil
+}
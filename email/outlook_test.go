@@ -0,0 +1,376 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package email
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+	"unicode/utf16"
+)
+
+// cfbTestStorage is a storage to be written by cfbTestFile, with its streams and child storages by name.
+type cfbTestStorage struct {
+	streams  map[string][]byte
+	storages map[string]*cfbTestStorage
+}
+
+// cfbTestFile returns a version 3 Compound File Binary file containing the root storage.
+// The FAT sectors come first, followed by the directory, and then each stream in order.
+// The mini stream is not used, so every stream is stored in regular sectors.
+func cfbTestFile(root *cfbTestStorage) []byte {
+	type entry struct {
+		name        string
+		objectType  byte
+		child       uint32
+		right       uint32
+		data        []byte
+		startSector uint32
+	}
+	entries := []entry{{name: "Root Entry", objectType: cfbTypeRoot, child: cfbNoStream, right: cfbNoStream}}
+	var add func(s *cfbTestStorage, parent int)
+	add = func(s *cfbTestStorage, parent int) {
+		var names []string
+		for name := range s.streams {
+			names = append(names, name)
+		}
+		for name := range s.storages {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		previous := -1
+		for _, name := range names {
+			idx := len(entries)
+			e := entry{name: name, objectType: cfbTypeStream, child: cfbNoStream, right: cfbNoStream, data: s.streams[name]}
+			if _, ok := s.storages[name]; ok {
+				e.objectType = cfbTypeStorage
+			}
+			entries = append(entries, e)
+			if previous < 0 {
+				entries[parent].child = uint32(idx)
+			} else {
+				entries[previous].right = uint32(idx)
+			}
+			previous = idx
+			if sub, ok := s.storages[name]; ok {
+				add(sub, idx)
+			}
+		}
+	}
+	add(root, 0)
+
+	const sectorSize = 512
+	sectors := func(size int) int { return (size + sectorSize - 1) / sectorSize }
+	dirSectors := sectors(len(entries) * cfbDirEntrySize)
+	numSectors := dirSectors
+	for _, e := range entries {
+		numSectors += sectors(len(e.data))
+	}
+	numFATSectors := 1
+	for numFATSectors*sectorSize/4 < numSectors+numFATSectors {
+		numFATSectors++
+	}
+
+	fat := make([]uint32, numFATSectors*sectorSize/4)
+	for i := range fat {
+		fat[i] = cfbFreeSect
+		if i < numFATSectors {
+			fat[i] = 0xFFFFFFFD // FATSECT
+		}
+	}
+	next := uint32(numFATSectors)
+	allocate := func(count int) uint32 {
+		if count == 0 {
+			return cfbEndOfChain
+		}
+		start := next
+		for i := 0; i < count; i++ {
+			fat[next] = next + 1
+			if i == count-1 {
+				fat[next] = cfbEndOfChain
+			}
+			next++
+		}
+		return start
+	}
+	firstDirSector := allocate(dirSectors)
+	for i := range entries {
+		entries[i].startSector = allocate(sectors(len(entries[i].data)))
+	}
+
+	header := make([]byte, cfbHeaderSize)
+	copy(header, cfbSignature)
+	binary.LittleEndian.PutUint16(header[24:], 0x003E)
+	binary.LittleEndian.PutUint16(header[26:], 3)
+	binary.LittleEndian.PutUint16(header[28:], 0xFFFE)
+	binary.LittleEndian.PutUint16(header[30:], 9)
+	binary.LittleEndian.PutUint16(header[32:], 6)
+	binary.LittleEndian.PutUint32(header[44:], uint32(numFATSectors))
+	binary.LittleEndian.PutUint32(header[48:], firstDirSector)
+	binary.LittleEndian.PutUint32(header[60:], cfbEndOfChain)
+	binary.LittleEndian.PutUint32(header[68:], cfbEndOfChain)
+	for i := 0; i < cfbHeaderDIFATLen; i++ {
+		sector := uint32(cfbFreeSect)
+		if i < numFATSectors {
+			sector = uint32(i)
+		}
+		binary.LittleEndian.PutUint32(header[76+i*4:], sector)
+	}
+
+	buffer := bytes.NewBuffer(header)
+	binary.Write(buffer, binary.LittleEndian, fat)
+	dir := make([]byte, dirSectors*sectorSize)
+	for i, e := range entries {
+		b := dir[i*cfbDirEntrySize:]
+		name := cfbTestUnicode(e.name)
+		copy(b, name)
+		binary.LittleEndian.PutUint16(b[64:], uint16(len(name)))
+		b[66] = e.objectType
+		b[67] = 1 // black
+		binary.LittleEndian.PutUint32(b[68:], cfbNoStream)
+		binary.LittleEndian.PutUint32(b[72:], e.right)
+		binary.LittleEndian.PutUint32(b[76:], e.child)
+		binary.LittleEndian.PutUint32(b[116:], e.startSector)
+		binary.LittleEndian.PutUint64(b[120:], uint64(len(e.data)))
+	}
+	buffer.Write(dir)
+	for _, e := range entries {
+		buffer.Write(e.data)
+		buffer.Write(make([]byte, sectors(len(e.data))*sectorSize-len(e.data)))
+	}
+	return buffer.Bytes()
+}
+
+// cfbTestUnicode returns the string as null-terminated little-endian UTF-16.
+func cfbTestUnicode(s string) []byte {
+	b := &bytes.Buffer{}
+	binary.Write(b, binary.LittleEndian, utf16.Encode([]rune(s+"\x00")))
+	return b.Bytes()
+}
+
+// outlookTestProperties returns a properties stream, with a header of this size, and the fixed-length properties.
+func outlookTestProperties(headerSize int, integers map[uint16]uint32, times map[uint16]time.Time) []byte {
+	b := bytes.NewBuffer(make([]byte, headerSize))
+	for id, value := range integers {
+		binary.Write(b, binary.LittleEndian, []uint32{uint32(id)<<16 | 0x0003, 0, value, 0})
+	}
+	for id, value := range times {
+		binary.Write(b, binary.LittleEndian, []uint32{uint32(id)<<16 | 0x0040, 0})
+		binary.Write(b, binary.LittleEndian, uint64(value.UnixNano()/100+filetimeUnixOffset))
+	}
+	return b.Bytes()
+}
+
+// outlookTestFile returns an Outlook .msg file with plain text and html bodies, two recipients,
+// an attachment, an inline image, and an embedded message with internet headers and an rtf body.
+func outlookTestFile() []byte {
+	// Example from MS-OXRTFCP section 4.1
+	rtf := bytesOrPanic(hex.DecodeString("2d0000002b0000004c5a4675f1c5c7a703000a00726370673132" +
+		"3542320af32068656c090020627705b06c647d0a800fa0"))
+
+	embedded := &cfbTestStorage{streams: map[string][]byte{
+		outlookPropertiesStream: outlookTestProperties(outlookEmbeddedSize, nil, nil),
+		"__substg1.0_007D001F": cfbTestUnicode("From: Dave <dave@example.com>\r\nTo: erin@example.com\r\n" +
+			"Subject: Original\r\nContent-Type: text/plain\r\n"),
+		"__substg1.0_0037001F": cfbTestUnicode("Property Subject"),
+		"__substg1.0_10090102": rtf,
+	}}
+
+	return cfbTestFile(&cfbTestStorage{
+		streams: map[string][]byte{
+			outlookPropertiesStream: outlookTestProperties(outlookTopHeaderSize,
+				map[uint16]uint32{mapiInternetCodepage: 65001},
+				map[uint16]time.Time{mapiClientSubmitTime: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)}),
+			"__substg1.0_0037001F": cfbTestUnicode("Quarterly Report"),
+			"__substg1.0_0C1A001F": cfbTestUnicode("Alice Sender"),
+			"__substg1.0_5D01001F": cfbTestUnicode("alice@example.com"),
+			"__substg1.0_1035001F": cfbTestUnicode("<report@example.com>"),
+			"__substg1.0_1000001F": cfbTestUnicode("Plain body"),
+			"__substg1.0_10130102": []byte(`<p>HTML body <img src="cid:logo@example.com"></p>`),
+		},
+		storages: map[string]*cfbTestStorage{
+			"__recip_version1.0_#00000000": {streams: map[string][]byte{
+				outlookPropertiesStream: outlookTestProperties(outlookSubHeaderSize, map[uint16]uint32{mapiRecipientType: 1}, nil),
+				"__substg1.0_3001001F":  cfbTestUnicode("Bob"),
+				"__substg1.0_39FE001F":  cfbTestUnicode("bob@example.com"),
+			}},
+			"__recip_version1.0_#00000001": {streams: map[string][]byte{
+				outlookPropertiesStream: outlookTestProperties(outlookSubHeaderSize, map[uint16]uint32{mapiRecipientType: 2}, nil),
+				"__substg1.0_3003001F":  cfbTestUnicode("carol@example.com"),
+			}},
+			"__attach_version1.0_#00000000": {streams: map[string][]byte{
+				outlookPropertiesStream: outlookTestProperties(outlookSubHeaderSize, map[uint16]uint32{mapiAttachMethod: 1}, nil),
+				"__substg1.0_3707001F":  cfbTestUnicode("report.csv"),
+				"__substg1.0_370E001F":  cfbTestUnicode("text/csv"),
+				"__substg1.0_37010102":  []byte("a,b\r\n1,2\r\n"),
+			}},
+			"__attach_version1.0_#00000001": {streams: map[string][]byte{
+				outlookPropertiesStream: outlookTestProperties(outlookSubHeaderSize, map[uint16]uint32{mapiAttachMethod: 1}, nil),
+				"__substg1.0_3707001F":  cfbTestUnicode("logo.png"),
+				"__substg1.0_3712001F":  cfbTestUnicode("<logo@example.com>"),
+				"__substg1.0_37010102":  []byte("\x89PNG\r\n\x1a\n"),
+			}},
+			"__attach_version1.0_#00000002": {
+				streams: map[string][]byte{
+					outlookPropertiesStream: outlookTestProperties(outlookSubHeaderSize, map[uint16]uint32{mapiAttachMethod: outlookAttachEmbeddedMsg}, nil),
+				},
+				storages: map[string]*cfbTestStorage{outlookEmbeddedMessage: embedded},
+			},
+		},
+	})
+}
+
+// TestParseOutlookMessage ...
+func TestParseOutlookMessage(t *testing.T) {
+	t.Parallel()
+
+	msg, err := ParseOutlookMessage(bytes.NewReader(outlookTestFile()))
+	if err != nil {
+		t.Fatal("Could not parse Outlook message:", err)
+	}
+
+	// Headers from the message's properties
+	if msg.Header.Subject() != "Quarterly Report" || msg.Header.From() != `"Alice Sender" <alice@example.com>` ||
+		msg.Header.Get("To") != `"Bob" <bob@example.com>` || msg.Header.Get("Cc") != "carol@example.com" ||
+		msg.Header.Get("Message-Id") != "<report@example.com>" {
+		t.Fatal("Outlook message headers do not match expected values:", msg.Header)
+	}
+	if date, err := msg.Header.Date(); err != nil || !date.Equal(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)) {
+		t.Fatal("Outlook message has the wrong date:", msg.Header.Get("Date"))
+	}
+	if !confirmHasParts(msg, 3, false, false) {
+		t.Fatal("Outlook message does not have the expected parts")
+	}
+
+	// Plain text and html bodies, with the inline image related to the html
+	alternative := msg.Parts[0]
+	if contentType, _, _ := alternative.Header.ContentType(); contentType != "multipart/alternative" || len(alternative.Parts) != 2 {
+		t.Fatal("Outlook message bodies are not an alternative part:", alternative.Header)
+	}
+	if string(alternative.Parts[0].Body) != "Plain body" {
+		t.Fatalf("Outlook message has the wrong plain text body: %q", alternative.Parts[0].Body)
+	}
+	related := alternative.Parts[1]
+	if contentType, _, _ := related.Header.ContentType(); contentType != "multipart/related" || len(related.Parts) != 2 {
+		t.Fatal("Outlook message html body is not a related part:", related.Header)
+	}
+	if _, params, _ := related.Parts[0].Header.ContentType(); params["charset"] != "UTF-8" ||
+		string(related.Parts[0].Body) != `<p>HTML body <img src="cid:logo@example.com"></p>` {
+		t.Fatalf("Outlook message has the wrong html body: %q", related.Parts[0].Body)
+	}
+	inline := related.Parts[1]
+	if contentType, _, _ := inline.Header.ContentType(); contentType != "image/png" || inline.Header.Get("Content-ID") != "<logo@example.com>" {
+		t.Fatal("Outlook message inline attachment does not match expected values:", inline.Header)
+	}
+	if disposition, params, _ := inline.Header.ContentDisposition(); disposition != "inline" || params["filename"] != "logo.png" {
+		t.Fatal("Outlook message inline attachment has the wrong disposition:", inline.Header)
+	}
+
+	// Regular attachment
+	attachment := msg.Parts[1]
+	if contentType, _, _ := attachment.Header.ContentType(); contentType != "text/csv" || string(attachment.Body) != "a,b\r\n1,2\r\n" {
+		t.Fatal("Outlook message attachment does not match expected values:", attachment.Header)
+	}
+	if disposition, params, _ := attachment.Header.ContentDisposition(); disposition != "attachment" || params["filename"] != "report.csv" {
+		t.Fatal("Outlook message attachment has the wrong disposition:", attachment.Header)
+	}
+
+	// Embedded message, with its transport headers preferred over its properties, and an rtf body
+	embedded := msg.Parts[2]
+	if contentType, _, _ := embedded.Header.ContentType(); contentType != "message/rfc822" || embedded.SubMessage == nil {
+		t.Fatal("Outlook message embedded message is not a message/rfc822 part:", embedded.Header)
+	}
+	sub := embedded.SubMessage
+	if sub.Header.Subject() != "Original" || sub.Header.From() != "Dave <dave@example.com>" || sub.Header.Get("To") != "erin@example.com" {
+		t.Fatal("Embedded message headers do not match expected values:", sub.Header)
+	}
+	if contentType, _, _ := sub.Header.ContentType(); contentType != "multipart/mixed" || len(sub.Parts) != 1 {
+		t.Fatal("Embedded message does not have the expected parts:", sub.Header)
+	}
+	if contentType, _, _ := sub.Parts[0].Header.ContentType(); contentType != "application/rtf" ||
+		string(sub.Parts[0].Body) != "{\\rtf1\\ansi\\ansicpg1252\\pard hello world}\r\n" {
+		t.Fatalf("Embedded message has the wrong rtf body: %q", sub.Parts[0].Body)
+	}
+
+	if _, err = msg.Bytes(); err != nil {
+		t.Fatal("Could not write Outlook message:", err)
+	}
+}
+
+// TestParseCompoundFileErrors ...
+func TestParseCompoundFileErrors(t *testing.T) {
+	t.Parallel()
+
+	valid := outlookTestFile()
+	firstDirSector := binary.LittleEndian.Uint32(valid[48:])
+	fatEntry := func(sector uint32) int { return cfbHeaderSize + int(sector)*4 }
+
+	testCases := []struct {
+		name   string
+		modify func(b []byte) []byte
+	}{
+		{"truncated header", func(b []byte) []byte { return b[:cfbHeaderSize-1] }},
+		{"truncated sector chain", func(b []byte) []byte { return b[:len(b)-cfbHeaderSize] }},
+		{"sector chain out of range", func(b []byte) []byte {
+			binary.LittleEndian.PutUint32(b[fatEntry(firstDirSector):], 100)
+			return b
+		}},
+		{"cyclic sector chain", func(b []byte) []byte {
+			binary.LittleEndian.PutUint32(b[fatEntry(firstDirSector):], firstDirSector)
+			return b
+		}},
+		{"huge number of FAT sectors", func(b []byte) []byte {
+			binary.LittleEndian.PutUint32(b[44:], 0xFFFFFFF0)
+			return b
+		}},
+		{"bad DIFAT sector", func(b []byte) []byte {
+			binary.LittleEndian.PutUint32(b[76:], 0x00FFFFFF)
+			return b
+		}},
+		{"bad DIFAT chain", func(b []byte) []byte {
+			binary.LittleEndian.PutUint32(b[68:], 0x00FFFFFF)
+			return b
+		}},
+		{"cyclic DIFAT chain", func(b []byte) []byte {
+			binary.LittleEndian.PutUint32(b[68:], 0)
+			binary.LittleEndian.PutUint32(b[fatEntry(0)+cfbHeaderSize-4:], 0)
+			return b
+		}},
+	}
+	for _, tc := range testCases {
+		data := tc.modify(append([]byte{}, valid...))
+		if _, err := ParseOutlookMessage(bytes.NewReader(data)); err == nil {
+			t.Errorf("Case %s: expected an error", tc.name)
+		}
+	}
+
+	// Storages that share their children, at every level, must not be loaded again for each of them
+	const levels = 26
+	streams := map[string][]byte{}
+	for i := 0; i < 2*levels; i++ {
+		streams[fmt.Sprintf("s%02d", i)] = nil
+	}
+	shared := cfbTestFile(&cfbTestStorage{streams: streams})
+	dir := shared[(binary.LittleEndian.Uint32(shared[48:])+1)*cfbHeaderSize:]
+	for i := 0; i < levels; i++ {
+		child := uint32(cfbNoStream)
+		if i < levels-1 {
+			child = uint32(2*i + 3)
+		}
+		for j, right := range []uint32{uint32(2*i + 2), cfbNoStream} {
+			e := dir[(2*i+1+j)*cfbDirEntrySize:]
+			e[66] = cfbTypeStorage
+			binary.LittleEndian.PutUint32(e[72:], right)
+			binary.LittleEndian.PutUint32(e[76:], child)
+		}
+	}
+	if _, err := ParseOutlookMessage(bytes.NewReader(shared)); err == nil {
+		t.Error("Expected an error for storages that share their children")
+	}
+}
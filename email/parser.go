@@ -15,6 +15,7 @@ import (
 	"mime/multipart"
 	"mime/quotedprintable"
 	"net/mail"
+	"net/textproto"
 	"strings"
 )
 
@@ -22,26 +23,46 @@ import (
 // containing the raw text of an email message.
 // (If the raw email is a string or []byte, use strings.NewReader()
 // or bytes.NewReader() to create a reader.)
-// Any "quoted-printable" or "base64" encoded bodies will be decoded.
+// Any "quoted-printable", "base64", or "x-uuencode" encoded bodies will be decoded.
+// The parts of any multipart/signed message also keep their exact bytes as Raw,
+// so that their signatures can be verified, but are written out again from their Header and payload.
 func ParseMessage(r io.Reader) (*Message, error) {
 	msg, err := mail.ReadMessage(&leftTrimReader{r: bufioReader(r)})
 	if err != nil {
 		return nil, err
 	}
-	// decode any Q-encoded values
-	for _, values := range msg.Header {
+	decodeHeader(msg.Header)
+	return parseMessageWithHeader(Header(msg.Header), msg.Body)
+}
+
+// ParseHeader parses and returns only the Header from an io.Reader
+// containing the raw text of an email message's header fields, such as
+// the content of a "text/rfc822-headers" part.
+// Any Q-encoded values will be decoded.
+func ParseHeader(r io.Reader) (Header, error) {
+	tp := textproto.NewReader(bufio.NewReader(&leftTrimReader{r: bufioReader(r)}))
+	h, err := tp.ReadMIMEHeader()
+	if err != nil && (err != io.EOF || len(h) == 0) {
+		return nil, err
+	}
+	decodeHeader(h)
+	return Header(h), nil
+}
+
+// decodeHeader decodes any Q-encoded values
+func decodeHeader(h map[string][]string) {
+	for _, values := range h {
 		for idx, val := range values {
 			values[idx] = decodeRFC2047(val)
 		}
 	}
-	return parseMessageWithHeader(Header(msg.Header), msg.Body)
 }
 
 // parseMessageWithHeader parses and returns a Message from an already filled
 // Header, and an io.Reader containing the raw text of the body/payload.
 // (If the raw body is a string or []byte, use strings.NewReader()
 // or bytes.NewReader() to create a reader.)
-// Any "quoted-printable" or "base64" encoded bodies will be decoded.
+// Any "quoted-printable", "base64", or "x-uuencode" encoded bodies will be decoded.
 func parseMessageWithHeader(headers Header, bodyReader io.Reader) (*Message, error) {
 
 	bufferedReader := contentReader(headers, bodyReader)
@@ -65,6 +86,18 @@ func parseMessageWithHeader(headers Header, bodyReader io.Reader) (*Message, err
 	// Can only have one of the following: Parts, SubMessage, or Body
 	if strings.HasPrefix(mediaType, "multipart") {
 		boundary := mediaTypeParams["boundary"]
+
+		// The parts of a signed message are parsed from a copy, so their exact bytes can be kept for verification
+		var raw [][]byte
+		if mediaType == "multipart/signed" {
+			var content []byte
+			if content, err = ioutil.ReadAll(bufferedReader); err != nil {
+				return nil, err
+			}
+			raw = rawParts(content, boundary)
+			bufferedReader = bufioReader(bytes.NewReader(content))
+		}
+
 		preamble, err = readPreamble(bufferedReader, boundary)
 		if err == nil {
 			parts, err = readParts(bufferedReader, boundary)
@@ -72,6 +105,11 @@ func parseMessageWithHeader(headers Header, bodyReader io.Reader) (*Message, err
 				epilogue, err = readEpilogue(bufferedReader)
 			}
 		}
+		if err == nil && raw != nil && len(raw) == len(parts) {
+			for idx, part := range parts {
+				part.Raw = raw[idx]
+			}
+		}
 
 	} else if strings.HasPrefix(mediaType, "message") {
 		subMessage, err = ParseMessage(bufferedReader)
@@ -113,6 +151,39 @@ func readParts(bodyReader io.Reader, boundary string) ([]*Message, error) {
 	return parts, nil
 }
 
+// rawParts returns the exact bytes of each part of a multipart body, as they are signed in a multipart/signed message:
+// everything after the line break ending a boundary delimiter line, up to the line break before the next one.
+// Bare line feeds are made CRLF, as they would have been when the message was signed and sent.
+func rawParts(body []byte, boundary string) [][]byte {
+	delimiter := []byte("--" + boundary)
+	var parts [][]byte
+	start := -1
+	for offset := 0; offset < len(body); {
+		next := len(body)
+		if idx := bytes.IndexByte(body[offset:], '\n'); idx >= 0 {
+			next = offset + idx + 1
+		}
+		// Delimiter lines may have trailing whitespace, and the final delimiter ends with --
+		line := bytes.TrimRight(body[offset:next], " \t\r\n")
+		if bytes.HasPrefix(line, delimiter) && (len(line) == len(delimiter) || string(line[len(delimiter):]) == "--") {
+			if start >= 0 {
+				end := max(start, offset-1)
+				if end > start && body[end-1] == '\r' {
+					end--
+				}
+				part := bytes.Replace(body[start:end], []byte("\r\n"), []byte("\n"), -1)
+				parts = append(parts, bytes.Replace(part, []byte("\n"), []byte("\r\n"), -1))
+			}
+			if len(line) > len(delimiter) {
+				break
+			}
+			start = next
+		}
+		offset = next
+	}
+	return parts
+}
+
 // readEpilogue ...
 func readEpilogue(r io.Reader) ([]byte, error) {
 	epilogue, err := ioutil.ReadAll(r)
@@ -187,6 +258,11 @@ func contentReader(headers Header, bodyReader io.Reader) *bufio.Reader {
 		headers.Del("Content-Transfer-Encoding")
 		return bufioReader(base64.NewDecoder(base64.StdEncoding, bodyReader))
 	}
+	switch strings.ToLower(headers.Get("Content-Transfer-Encoding")) {
+	case "x-uuencode", "x-uue", "uuencode", "x-uu":
+		headers.Del("Content-Transfer-Encoding")
+		return bufioReader(newUUDecodeReader(bodyReader))
+	}
 	return bufioReader(bodyReader)
 }
 
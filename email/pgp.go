@@ -0,0 +1,339 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package email
+
+import (
+	"bytes"
+	"crypto"
+	"errors"
+	"mime"
+	"strings"
+)
+
+// The armor lines of inline PGP blocks
+const (
+	pgpSignedMessageBegin = "-----BEGIN PGP SIGNED MESSAGE-----"
+	pgpSignatureBegin     = "-----BEGIN PGP SIGNATURE-----"
+	pgpSignatureEnd       = "-----END PGP SIGNATURE-----"
+	pgpMessageBegin       = "-----BEGIN PGP MESSAGE-----"
+	pgpMessageEnd         = "-----END PGP MESSAGE-----"
+)
+
+// PGPKeyring performs the OpenPGP operations needed by PGP/MIME, as defined by RFC 3156,
+// with the keys it holds. It allows any OpenPGP implementation to be used,
+// such as golang.org/x/crypto/openpgp or github.com/ProtonMail/go-crypto/openpgp.
+// Signatures and encrypted messages are ASCII armored when created,
+// and should be accepted either armored or binary.
+type PGPKeyring interface {
+	// Sign returns a detached signature of the data, by the key of the signer,
+	// which is usually an email address, and the hash algorithm that the signature used.
+	Sign(signer string, data []byte) (signature []byte, hash crypto.Hash, err error)
+
+	// Verify verifies a detached signature of the data, returning the identity of the signer,
+	// such as the user ID or fingerprint of the key, or an error if it did not verify.
+	Verify(data []byte, signature []byte) (signer string, err error)
+
+	// Encrypt returns the data encrypted for the keys of each of the recipients,
+	// which are usually email addresses.
+	Encrypt(recipients []string, data []byte) ([]byte, error)
+
+	// Decrypt returns the data decrypted with one of the keyring's private keys.
+	Decrypt(data []byte) ([]byte, error)
+}
+
+// PGPSigner signs messages with PGP/MIME, creating multipart/signed messages
+// with a detached application/pgp-signature.
+type PGPSigner struct {
+	Keyring PGPKeyring
+
+	// Signer identifies the key to sign with, and is usually the From address.
+	Signer string
+}
+
+// PGPEncrypter encrypts messages with PGP/MIME, creating multipart/encrypted messages.
+type PGPEncrypter struct {
+	Keyring PGPKeyring
+
+	// Recipients identify the keys to encrypt for, and are usually the email addresses of the recipients.
+	// Senders usually include their own, so that they can read their sent mail.
+	Recipients []string
+}
+
+// PGPVerifier verifies PGP/MIME signed messages, and inline PGP signed text.
+type PGPVerifier struct {
+	Keyring PGPKeyring
+}
+
+// PGPDecrypter decrypts PGP/MIME encrypted messages, and inline PGP encrypted text.
+type PGPDecrypter struct {
+	Keyring PGPKeyring
+}
+
+// PGPVerification is the result of successfully verifying a PGP signed message.
+type PGPVerification struct {
+	// Signer identifies the key that made the signature, as returned by the PGPKeyring.
+	Signer string
+
+	// Content is what was signed: the exact bytes of the signed part of a PGP/MIME message,
+	// or the text of an inline signed message, without the PGP armor and dash-escaping.
+	Content []byte
+}
+
+// Sign returns a new multipart/signed message, whose first part is the content of the message
+// (its Content-* header fields and payload), followed by the signature of its exact bytes.
+// The other header fields, such as From and Subject, are moved to the new message unsigned.
+// The signed part's Raw bytes and WriteRaw are set, so that it is written out exactly as signed.
+func (s *PGPSigner) Sign(m *Message) (*Message, error) {
+	signed, outer := splitContent(m)
+	// Signed parts must be 7bit, with trailing whitespace encoded, which quoted-printable always does
+	content, err := signed.BytesWithPolicy(Encoding7Bit)
+	if err != nil {
+		return nil, err
+	}
+	signed.Raw, signed.WriteRaw = content, true
+	signature, hash, err := s.Keyring.Sign(s.Signer, content)
+	if err != nil {
+		return nil, err
+	}
+
+	outer.Header.Set("Content-Type", mime.FormatMediaType("multipart/signed", map[string]string{
+		"protocol": "application/pgp-signature", "micalg": pgpMicalg(hash), "boundary": randomBoundary()}))
+	outer.Parts = []*Message{signed, {
+		Header: Header{
+			"Content-Type":        []string{"application/pgp-signature; name=\"signature.asc\""},
+			"Content-Description": []string{"OpenPGP digital signature"},
+			"Content-Disposition": []string{"attachment; filename=\"signature.asc\""},
+		},
+		Body: signature,
+	}}
+	return outer, nil
+}
+
+// Encrypt returns a new multipart/encrypted message, whose second part is the content of the message
+// (its Content-* header fields and payload) encrypted for each of the Recipients.
+// The other header fields, such as From and Subject, are moved to the new message unencrypted.
+// To both sign and encrypt a message, sign it first, and then encrypt the signed message.
+func (e *PGPEncrypter) Encrypt(m *Message) (*Message, error) {
+	if len(e.Recipients) == 0 {
+		return nil, errors.New("PGP encryption requires at least one Recipient")
+	}
+	inner, outer := splitContent(m)
+	content, err := inner.BytesWithPolicy(Encoding7Bit)
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := e.Keyring.Encrypt(e.Recipients, content)
+	if err != nil {
+		return nil, err
+	}
+
+	outer.Header.Set("Content-Type", mime.FormatMediaType("multipart/encrypted", map[string]string{
+		"protocol": "application/pgp-encrypted", "boundary": randomBoundary()}))
+	outer.Parts = []*Message{{
+		Header: Header{
+			"Content-Type":        []string{"application/pgp-encrypted"},
+			"Content-Description": []string{"PGP/MIME version identification"},
+		},
+		Body: []byte("Version: 1\r\n"),
+	}, {
+		Header: Header{
+			"Content-Type":        []string{"application/octet-stream; name=\"encrypted.asc\""},
+			"Content-Description": []string{"OpenPGP encrypted message"},
+			"Content-Disposition": []string{"inline; filename=\"encrypted.asc\""},
+		},
+		Body: encrypted,
+	}}
+	return outer, nil
+}
+
+// HasPGPSignature returns true if this Message is a PGP/MIME multipart/signed message.
+func (m *Message) HasPGPSignature() bool {
+	mediaType, params, err := m.Header.ContentType()
+	return err == nil && mediaType == "multipart/signed" && strings.ToLower(params["protocol"]) == "application/pgp-signature"
+}
+
+// HasPGPEncryption returns true if this Message is a PGP/MIME multipart/encrypted message.
+func (m *Message) HasPGPEncryption() bool {
+	mediaType, params, err := m.Header.ContentType()
+	return err == nil && mediaType == "multipart/encrypted" && strings.ToLower(params["protocol"]) == "application/pgp-encrypted"
+}
+
+// HasInlinePGP returns true if this Message is text, whose Body contains
+// an inline PGP signed message or encrypted message, rather than using PGP/MIME.
+func (m *Message) HasInlinePGP() bool {
+	if !m.isText() {
+		return false
+	}
+	for _, line := range strings.Split(string(m.Body), "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if line == pgpSignedMessageBegin || line == pgpMessageBegin {
+			return true
+		}
+	}
+	return false
+}
+
+// isText returns true if this Message has a body of text, which is the default without a Content-Type.
+func (m *Message) isText() bool {
+	if !m.Header.IsSet("Content-Type") {
+		return true
+	}
+	mediaType, _, err := m.Header.ContentType()
+	return err == nil && strings.HasPrefix(mediaType, "text/")
+}
+
+// Verify verifies the signature of a PGP/MIME multipart/signed message, or of an inline PGP signed text message,
+// returning an error if it did not verify. The signature of a PGP/MIME message is of the exact bytes
+// of the signed part, which are its Raw bytes, as kept by ParseMessage and set by PGPSigner,
+// or if not set, the part written out again.
+func (v *PGPVerifier) Verify(m *Message) (*PGPVerification, error) {
+	var content, signature []byte
+	switch {
+	case m.HasPGPSignature():
+		if len(m.Parts) != 2 {
+			return nil, errors.New("PGP multipart/signed message must have 2 parts")
+		}
+		if signatureType, _, err := m.Parts[1].Header.ContentType(); err != nil || signatureType != "application/pgp-signature" {
+			return nil, errors.New("PGP multipart/signed message is missing its signature part")
+		}
+		var err error
+		if content, err = signedBytes(m.Parts[0]); err != nil {
+			return nil, err
+		}
+		signature = m.Parts[1].Body
+	case m.HasInlinePGP():
+		var ok bool
+		if content, signature, ok = parsePGPClearSigned(m.Body); !ok {
+			return nil, errors.New("Message has no inline PGP signed text")
+		}
+	default:
+		return nil, errors.New("Message is not a PGP signed message")
+	}
+
+	signer, err := v.Keyring.Verify(content, signature)
+	if err != nil {
+		return nil, errors.New("PGP signature did not verify: " + err.Error())
+	}
+	return &PGPVerification{Signer: signer, Content: content}, nil
+}
+
+// Decrypt decrypts a PGP/MIME multipart/encrypted message, returning the parsed message that was encrypted,
+// with any header fields of the encrypted message that are not in the decrypted message, such as From and Subject.
+// It also decrypts inline PGP encrypted text, returning a copy of the message with each
+// PGP encrypted block replaced by its decrypted text.
+func (d *PGPDecrypter) Decrypt(m *Message) (*Message, error) {
+	if m.HasInlinePGP() {
+		return d.decryptInline(m)
+	}
+	if !m.HasPGPEncryption() {
+		return nil, errors.New("Message is not a PGP encrypted message")
+	}
+	if len(m.Parts) != 2 {
+		return nil, errors.New("PGP multipart/encrypted message must have 2 parts")
+	}
+	if versionType, _, err := m.Parts[0].Header.ContentType(); err != nil || versionType != "application/pgp-encrypted" ||
+		!bytes.Contains(m.Parts[0].Body, []byte("Version: 1")) {
+		return nil, errors.New("PGP multipart/encrypted message is missing its version part")
+	}
+
+	content, err := d.Keyring.Decrypt(m.Parts[1].Body)
+	if err != nil {
+		return nil, errors.New("PGP message did not decrypt: " + err.Error())
+	}
+	decrypted, err := ParseMessage(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	restoreHeader(decrypted, m)
+	return decrypted, nil
+}
+
+// decryptInline returns a copy of a text message, with each inline PGP encrypted block replaced by its decrypted text.
+func (d *PGPDecrypter) decryptInline(m *Message) (*Message, error) {
+	var body []byte
+	var found bool
+	for rest := m.Body; len(rest) > 0; {
+		start, end := pgpBlock(rest, pgpMessageBegin, pgpMessageEnd)
+		if start < 0 {
+			body = append(body, rest...)
+			break
+		}
+		decrypted, err := d.Keyring.Decrypt(rest[start:end])
+		if err != nil {
+			return nil, errors.New("PGP message did not decrypt: " + err.Error())
+		}
+		body = append(append(body, rest[:start]...), decrypted...)
+		rest = rest[end:]
+		found = true
+	}
+	if !found {
+		return nil, errors.New("Message has no inline PGP encrypted text")
+	}
+	return &Message{Header: copyHeader(m.Header), Body: body, TransferEncoding: m.TransferEncoding}, nil
+}
+
+// pgpBlock returns the start and end of the first armored block between the begin and end lines,
+// including the end line and its line break, or -1 if there is none.
+func pgpBlock(text []byte, begin string, end string) (int, int) {
+	for offset := 0; offset < len(text); {
+		lineEnd := bytes.IndexByte(text[offset:], '\n') + 1
+		if lineEnd == 0 {
+			lineEnd = len(text) - offset
+		}
+		line := strings.TrimRight(string(text[offset:offset+lineEnd]), " \t\r\n")
+		if line == begin {
+			for blockEnd := offset + lineEnd; blockEnd < len(text); {
+				next := bytes.IndexByte(text[blockEnd:], '\n') + 1
+				if next == 0 {
+					next = len(text) - blockEnd
+				}
+				if strings.TrimRight(string(text[blockEnd:blockEnd+next]), " \t\r\n") == end {
+					return offset, blockEnd + next
+				}
+				blockEnd += next
+			}
+			return -1, -1
+		}
+		offset += lineEnd
+	}
+	return -1, -1
+}
+
+// parsePGPClearSigned parses the first inline PGP signed message in the text, as defined by RFC 4880 section 7,
+// returning the signed text, and the armored signature. The signed text is dash-unescaped,
+// has trailing whitespace removed from each line, and CRLF line breaks, except after the last line.
+func parsePGPClearSigned(text []byte) ([]byte, []byte, bool) {
+	start, _ := pgpBlock(text, pgpSignedMessageBegin, pgpSignatureBegin)
+	if start < 0 {
+		return nil, nil, false
+	}
+	lines := strings.Split(string(text[start:]), "\n")
+	for idx := range lines {
+		lines[idx] = strings.TrimRight(lines[idx], "\r")
+	}
+
+	// The armor headers, such as the Hash, end with a blank line
+	idx := 1
+	for idx < len(lines) && strings.TrimSpace(lines[idx]) != "" {
+		idx++
+	}
+	var signed []string
+	for idx++; idx < len(lines) && strings.TrimRight(lines[idx], " \t") != pgpSignatureBegin; idx++ {
+		signed = append(signed, strings.TrimRight(strings.TrimPrefix(lines[idx], "- "), " \t"))
+	}
+	var signature []string
+	for ; idx < len(lines); idx++ {
+		signature = append(signature, lines[idx])
+		if strings.TrimRight(lines[idx], " \t") == pgpSignatureEnd {
+			return []byte(strings.Join(signed, "\r\n")), []byte(strings.Join(signature, "\r\n") + "\r\n"), true
+		}
+	}
+	return nil, nil, false
+}
+
+// pgpMicalg returns the micalg parameter of a PGP/MIME signature with the hash, such as pgp-sha256.
+func pgpMicalg(hash crypto.Hash) string {
+	return "pgp-" + strings.ToLower(strings.Replace(hash.String(), "-", "", -1))
+}
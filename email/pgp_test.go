@@ -0,0 +1,190 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package email
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// pgpTestKeyring is a PGPKeyring for tests, which signs with ed25519,
+// and "encrypts" with base64, only decrypting messages for its owner.
+type pgpTestKeyring struct {
+	owner string
+	keys  map[string]ed25519.PrivateKey
+}
+
+// Sign ...
+func (k *pgpTestKeyring) Sign(signer string, data []byte) ([]byte, crypto.Hash, error) {
+	key, ok := k.keys[signer]
+	if !ok {
+		return nil, 0, errors.New("no key for " + signer)
+	}
+	signature := signer + " " + base64.StdEncoding.EncodeToString(ed25519.Sign(key, data))
+	return []byte(pgpSignatureBegin + "\r\n\r\n" + signature + "\r\n" + pgpSignatureEnd + "\r\n"), crypto.SHA512, nil
+}
+
+// Verify ...
+func (k *pgpTestKeyring) Verify(data []byte, signature []byte) (string, error) {
+	fields := pgpTestArmored(signature)
+	if len(fields) != 2 {
+		return "", errors.New("malformed signature")
+	}
+	signer := fields[0]
+	raw, _ := base64.StdEncoding.DecodeString(fields[1])
+	key, ok := k.keys[signer]
+	if !ok || !ed25519.Verify(key.Public().(ed25519.PublicKey), data, raw) {
+		return "", errors.New("bad signature")
+	}
+	return signer, nil
+}
+
+// Encrypt ...
+func (k *pgpTestKeyring) Encrypt(recipients []string, data []byte) ([]byte, error) {
+	encoded := base64.StdEncoding.EncodeToString(append([]byte(strings.Join(recipients, ",")+"\n"), data...))
+	return []byte(pgpMessageBegin + "\r\n\r\n" + encoded + "\r\n" + pgpMessageEnd + "\r\n"), nil
+}
+
+// Decrypt ...
+func (k *pgpTestKeyring) Decrypt(data []byte) ([]byte, error) {
+	fields := pgpTestArmored(data)
+	if len(fields) != 1 {
+		return nil, errors.New("malformed message")
+	}
+	decoded, _ := base64.StdEncoding.DecodeString(fields[0])
+	recipients, content, _ := strings.Cut(string(decoded), "\n")
+	for _, recipient := range strings.Split(recipients, ",") {
+		if recipient == k.owner {
+			return []byte(content), nil
+		}
+	}
+	return nil, errors.New("not a recipient")
+}
+
+// pgpTestArmored returns the fields of the armored data, without its armor lines.
+func pgpTestArmored(data []byte) []string {
+	var fields []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if !strings.HasPrefix(line, "-----") {
+			fields = append(fields, strings.Fields(line)...)
+		}
+	}
+	return fields
+}
+
+// newPGPTestKeyrings returns keyrings for joe and jim, who know each other's signing keys.
+func newPGPTestKeyrings() (*pgpTestKeyring, *pgpTestKeyring) {
+	keys := map[string]ed25519.PrivateKey{
+		"joe@example.com": ed25519.NewKeyFromSeed(bytes.Repeat([]byte{1}, ed25519.SeedSize)),
+		"jim@example.com": ed25519.NewKeyFromSeed(bytes.Repeat([]byte{2}, ed25519.SeedSize)),
+	}
+	return &pgpTestKeyring{owner: "joe@example.com", keys: keys}, &pgpTestKeyring{owner: "jim@example.com", keys: keys}
+}
+
+// TestPGPMIME ...
+func TestPGPMIME(t *testing.T) {
+	t.Parallel()
+
+	joe, jim := newPGPTestKeyrings()
+	m := &Message{Header: Header{}, Body: []byte("Hi Jim,\r\nSee you at dinner.  \r\n")}
+	m.Header.SetFrom("joe@example.com")
+	m.Header.SetTo("jim@example.com")
+	m.Header.SetSubject("Dinner")
+
+	signed, err := (&PGPSigner{Keyring: joe, Signer: "joe@example.com"}).Sign(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, params, _ := signed.Header.ContentType()
+	if !signed.HasPGPSignature() || params["micalg"] != "pgp-sha512" || signed.Header.Subject() != "Dinner" {
+		t.Errorf("Unexpected signed message: %+v", signed.Header)
+	}
+	encrypted, err := (&PGPEncrypter{Keyring: joe, Recipients: []string{"jim@example.com"}}).Encrypt(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !encrypted.HasPGPEncryption() || encrypted.Header.Subject() != "Dinner" || len(encrypted.Parts) != 2 {
+		t.Errorf("Unexpected encrypted message: %+v", encrypted.Header)
+	}
+
+	b, err := encrypted.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	received, err := ParseMessage(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = (&PGPDecrypter{Keyring: joe}).Decrypt(received); err == nil {
+		t.Error("Expected an error decrypting without being a recipient")
+	}
+	decrypted, err := (&PGPDecrypter{Keyring: jim}).Decrypt(received)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !decrypted.HasPGPSignature() || decrypted.Header.Subject() != "Dinner" {
+		t.Fatalf("Expected a signed message; got %+v", decrypted.Header)
+	}
+	verification, err := (&PGPVerifier{Keyring: jim}).Verify(decrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verification.Signer != "joe@example.com" || !bytes.Contains(verification.Content, []byte("Content-Transfer-Encoding: quoted-printable")) {
+		t.Errorf("Unexpected verification: %s %q", verification.Signer, verification.Content)
+	}
+
+	decrypted.Parts[0].Raw = nil
+	decrypted.Parts[0].Body = []byte("Hi Jim,\r\nSee you at lunch.\r\n")
+	if _, err = (&PGPVerifier{Keyring: jim}).Verify(decrypted); err == nil {
+		t.Error("Expected modified content not to verify")
+	}
+	if _, err = (&PGPVerifier{Keyring: jim}).Verify(m); err == nil {
+		t.Error("Expected an error verifying an unsigned message")
+	}
+}
+
+// TestInlinePGP ...
+func TestInlinePGP(t *testing.T) {
+	t.Parallel()
+
+	joe, jim := newPGPTestKeyrings()
+	signature, _, _ := joe.Sign("joe@example.com", []byte("Hi Jim,\r\n--\r\nFrom Joe"))
+	clearSigned := "Before\r\n" + pgpSignedMessageBegin + "\r\nHash: SHA512\r\n\r\nHi Jim,  \r\n- -- \r\nFrom Joe\r\n" + string(signature) + "After\r\n"
+	m := &Message{Header: Header{"Content-Type": {"text/plain"}}, Body: []byte(clearSigned)}
+	if !m.HasInlinePGP() {
+		t.Fatal("Expected inline PGP to be detected")
+	}
+	verification, err := (&PGPVerifier{Keyring: jim}).Verify(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verification.Signer != "joe@example.com" || string(verification.Content) != "Hi Jim,\r\n--\r\nFrom Joe" {
+		t.Errorf("Unexpected verification: %s %q", verification.Signer, verification.Content)
+	}
+	m.Body = bytes.Replace(m.Body, []byte("From Joe"), []byte("From Jim"), 1)
+	if _, err = (&PGPVerifier{Keyring: jim}).Verify(m); err == nil {
+		t.Error("Expected modified inline text not to verify")
+	}
+
+	encrypted, _ := joe.Encrypt([]string{"jim@example.com"}, []byte("The code is 1234.\r\n"))
+	m = &Message{Header: Header{"Subject": {"Secret"}}, Body: append([]byte("Hi Jim,\r\n\r\n"), encrypted...)}
+	decrypted, err := (&PGPDecrypter{Keyring: jim}).Decrypt(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decrypted.Body) != "Hi Jim,\r\n\r\nThe code is 1234.\r\n" || decrypted.Header.Subject() != "Secret" {
+		t.Errorf("Unexpected decrypted message: %+v %q", decrypted.Header, decrypted.Body)
+	}
+
+	attachment := &Message{Header: Header{"Content-Type": {"application/octet-stream"}}, Body: encrypted}
+	if attachment.HasInlinePGP() {
+		t.Error("Expected inline PGP to only be detected in text")
+	}
+}
@@ -5,14 +5,27 @@
 package email
 
 import (
+	"crypto/tls"
 	"errors"
+	"fmt"
+	"net"
 	"net/mail"
 	"net/smtp"
 )
 
 // Send this email using the SMTP Address:Port, and optionally any SMTP Auth.
 // Send will call Save() on the message before sending.
+// The bodies are encoded according to the extensions the SMTP server supports:
+// unencoded binary (sent with BDAT) if it supports BINARYMIME and CHUNKING,
+// 8bit text if it supports 8BITMIME, and otherwise only 7bit-safe encodings.
 func (m *Message) Send(smtpAddressPort string, auth smtp.Auth) error {
+	return m.SendSigned(smtpAddressPort, auth)
+}
+
+// SendSigned is like Send, except the message is signed by each MessageSigner
+// (such as a DKIMSigner) after it has been encoded for the SMTP server,
+// so that the signatures cover the exact bytes transmitted.
+func (m *Message) SendSigned(smtpAddressPort string, auth smtp.Auth, signers ...MessageSigner) error {
 
 	to := m.Header.To()
 	cc := m.Header.Cc()
@@ -46,10 +59,116 @@ func (m *Message) Send(smtpAddressPort string, auth smtp.Auth) error {
 		return err
 	}
 
-	b, err := m.Bytes()
+	c, err := smtp.Dial(smtpAddressPort)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		host, _, _ := net.SplitHostPort(smtpAddressPort)
+		if err = c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+
+	if auth != nil {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return errors.New("smtp: server doesn't support AUTH")
+		}
+		if err = c.Auth(auth); err != nil {
+			return err
+		}
+	}
+
+	policy := negotiateEncodingPolicy(c)
+	b, err := m.SignedBytes(policy, signers...)
 	if err != nil {
 		return err
 	}
 
-	return smtp.SendMail(smtpAddressPort, auth, from.Address, all, b)
+	if policy == EncodingBinaryMIME {
+		// net/smtp's Mail would declare BODY=8BITMIME instead
+		if err = smtpCmd(c, 250, "MAIL FROM:<%s> BODY=BINARYMIME", from.Address); err != nil {
+			return err
+		}
+	} else if err = c.Mail(from.Address); err != nil {
+		return err
+	}
+
+	for _, address := range all {
+		if err = c.Rcpt(address); err != nil {
+			return err
+		}
+	}
+
+	if policy == EncodingBinaryMIME {
+		err = smtpBDAT(c, b)
+	} else {
+		err = smtpData(c, b)
+	}
+	if err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// negotiateEncodingPolicy returns the EncodingPolicy allowed by the SMTP server's EHLO extensions.
+func negotiateEncodingPolicy(c *smtp.Client) EncodingPolicy {
+	binaryMIME, _ := c.Extension("BINARYMIME")
+	chunking, _ := c.Extension("CHUNKING")
+	if binaryMIME && chunking {
+		return EncodingBinaryMIME
+	}
+	if eightBitMIME, _ := c.Extension("8BITMIME"); eightBitMIME {
+		return Encoding8BitMIME
+	}
+	return Encoding7Bit
+}
+
+// smtpCmd sends a command, and reads the response, which must have the expected code.
+func smtpCmd(c *smtp.Client, expectCode int, format string, args ...interface{}) error {
+	id, err := c.Text.Cmd(format, args...)
+	if err != nil {
+		return err
+	}
+	c.Text.StartResponse(id)
+	defer c.Text.EndResponse(id)
+	_, _, err = c.Text.ReadResponse(expectCode)
+	return err
+}
+
+// smtpData sends the message with the DATA command.
+func smtpData(c *smtp.Client, b []byte) error {
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(b); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// smtpBDAT sends the message as a single chunk with the BDAT command (RFC 3030),
+// which does not need dot-stuffing, and so may contain binary data.
+func smtpBDAT(c *smtp.Client, b []byte) error {
+	id := c.Text.Next()
+	c.Text.StartRequest(id)
+	_, err := fmt.Fprintf(c.Text.W, "BDAT %d LAST\r\n", len(b))
+	if err == nil {
+		_, err = c.Text.W.Write(b)
+	}
+	if err == nil {
+		err = c.Text.W.Flush()
+	}
+	c.Text.EndRequest(id)
+	if err != nil {
+		return err
+	}
+	c.Text.StartResponse(id)
+	defer c.Text.EndResponse(id)
+	_, _, err = c.Text.ReadResponse(250)
+	return err
 }
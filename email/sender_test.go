@@ -0,0 +1,164 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package email
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// smtpTestSession is what a smtpTestServer received from a client.
+type smtpTestSession struct {
+	commands []string
+	data     []byte
+}
+
+// smtpTestServer accepts a single SMTP session on a local port, advertising these EHLO extensions,
+// and returns its address and a channel that receives the session once the client quits.
+// If failBDAT is true, BDAT commands are rejected after the chunk is read.
+func smtpTestServer(t *testing.T, extensions []string, failBDAT bool) (string, <-chan smtpTestSession) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions := make(chan smtpTestSession, 1)
+	go func() {
+		defer listener.Close()
+		var session smtpTestSession
+		defer func() { sessions <- session }()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// A client that sends the wrong number of bytes fails rather than hangs
+		conn.SetDeadline(time.Now().Add(10 * time.Second))
+		r := bufio.NewReader(conn)
+		reply := func(lines ...string) {
+			for idx, line := range lines {
+				separator := "-"
+				if idx == len(lines)-1 {
+					separator = " "
+				}
+				io.WriteString(conn, line[:3]+separator+line[3:]+"\r\n")
+			}
+		}
+
+		reply("220test ESMTP")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			command := strings.TrimSuffix(line, "\r\n")
+			session.commands = append(session.commands, command)
+			verb := strings.ToUpper(strings.SplitN(command, " ", 2)[0])
+			switch verb {
+			case "EHLO":
+				lines := []string{"250test"}
+				for _, extension := range extensions {
+					lines = append(lines, "250"+extension)
+				}
+				reply(lines...)
+			case "MAIL", "RCPT":
+				reply("250OK")
+			case "DATA":
+				reply("354Go ahead")
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if line == ".\r\n" {
+						break
+					}
+					session.data = append(session.data, strings.TrimPrefix(line, ".")...)
+				}
+				reply("250OK")
+			case "BDAT":
+				fields := strings.Fields(command)
+				size, _ := strconv.Atoi(fields[1])
+				chunk := make([]byte, size)
+				if _, err := io.ReadFull(r, chunk); err != nil {
+					return
+				}
+				session.data = append(session.data, chunk...)
+				if failBDAT {
+					reply("554Rejected")
+				} else {
+					reply("250OK")
+				}
+			case "QUIT":
+				reply("221Bye")
+				return
+			default:
+				reply("500Unknown command")
+			}
+		}
+	}()
+	return listener.Addr().String(), sessions
+}
+
+// TestSendEncodingPolicy ...
+func TestSendEncodingPolicy(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		extensions []string
+		policy     EncodingPolicy
+		mailFrom   string
+		bdat       bool
+	}{
+		{nil, Encoding7Bit, "MAIL FROM:<from@host.com>", false},
+		{[]string{"BINARYMIME"}, Encoding7Bit, "MAIL FROM:<from@host.com>", false},
+		{[]string{"8BITMIME"}, Encoding8BitMIME, "MAIL FROM:<from@host.com> BODY=8BITMIME", false},
+		{[]string{"8BITMIME", "BINARYMIME", "CHUNKING"}, EncodingBinaryMIME, "MAIL FROM:<from@host.com> BODY=BINARYMIME", true},
+	}
+
+	for idx, tc := range testCases {
+		msg := NewMessage(NewHeader("from@host.com", "Send Test", "to@host.com"), "非常感谢你\nthank you", "<p>非常感谢你</p>",
+			NewPartAttachmentFromBytes([]byte("\x00binary\r\n.\r\n\xFF"), "test.bin"))
+
+		address, sessions := smtpTestServer(t, tc.extensions, false)
+		if err := msg.Send(address, nil); err != nil {
+			t.Fatalf("Case %d: could not send: %v", idx, err)
+		}
+		session := <-sessions
+
+		expected, err := msg.SignedBytes(tc.policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dataCommand := "DATA"
+		if tc.bdat {
+			dataCommand = "BDAT " + strconv.Itoa(len(expected)) + " LAST"
+		} else if !bytes.HasSuffix(expected, []byte("\r\n")) {
+			// DATA always ends with a line break before the final dot
+			expected = append(expected, "\r\n"...)
+		}
+		expectedCommands := []string{"EHLO localhost", tc.mailFrom, "RCPT TO:<to@host.com>", dataCommand, "QUIT"}
+		if !reflect.DeepEqual(session.commands, expectedCommands) {
+			t.Errorf("Case %d: expected commands %q; got %q", idx, expectedCommands, session.commands)
+		}
+		if !bytes.Equal(session.data, expected) {
+			t.Errorf("Case %d: received message does not match:\n%q\n%q", idx, session.data, expected)
+		}
+	}
+
+	// A rejected chunk must fail the send
+	msg := NewMessage(NewHeader("from@host.com", "Send Test", "to@host.com"), "text", "")
+	address, sessions := smtpTestServer(t, []string{"BINARYMIME", "CHUNKING"}, true)
+	if err := msg.Send(address, nil); err == nil {
+		t.Error("Expected an error when the server rejects the BDAT chunk")
+	}
+	<-sessions
+}
@@ -0,0 +1,346 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package email
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"mime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SMIMESigner signs messages with S/MIME, as defined by RFC 8551,
+// creating multipart/signed messages with a detached application/pkcs7-signature.
+type SMIMESigner struct {
+	// Certificate is the signer's certificate, which should be for the From address,
+	// and allow email protection.
+	Certificate *x509.Certificate
+
+	// Intermediates are any intermediate certificates needed to verify the Certificate,
+	// which are included in the signature.
+	Intermediates []*x509.Certificate
+
+	// Key is the private key of the Certificate: an *rsa.PrivateKey,
+	// *ecdsa.PrivateKey, or ed25519.PrivateKey.
+	Key crypto.Signer
+
+	// Now returns the current time, for the signing time. If nil, time.Now is used.
+	Now func() time.Time
+}
+
+// SMIMEVerification is the result of successfully verifying an S/MIME signed message.
+type SMIMEVerification struct {
+	// Signers are the certificates of the signers, which were each verified to chain to a root.
+	// Callers should check that a signer's EmailAddresses include the From address.
+	Signers []*x509.Certificate
+
+	// SigningTime is when the message was signed, according to the signer, if given.
+	SigningTime time.Time
+}
+
+// SMIMEVerifier verifies S/MIME signed messages, as defined by RFC 8551.
+type SMIMEVerifier struct {
+	// Roots are the trusted root certificates. If nil, the system's roots are used.
+	Roots *x509.CertPool
+
+	// Intermediates are any intermediate certificates that may be needed,
+	// in addition to those included in the signature.
+	Intermediates *x509.CertPool
+
+	// Now returns the current time, for checking certificate validity. If nil, time.Now is used.
+	Now func() time.Time
+}
+
+// Sign returns a new multipart/signed message, whose first part is the content of the message
+// (its Content-* header fields and payload), followed by the signature of its exact bytes.
+// The other header fields, such as From and Subject, are moved to the new message unsigned.
+// The signed part's Raw bytes and WriteRaw are set, so that it is written out exactly as signed.
+func (s *SMIMESigner) Sign(m *Message) (*Message, error) {
+	if s.Certificate == nil || s.Key == nil {
+		return nil, errors.New("S/MIME signing requires a Certificate and Key")
+	}
+	hash := crypto.SHA256
+	if _, ok := s.Key.(ed25519.PrivateKey); ok {
+		// RFC 8419 requires SHA-512 with Ed25519
+		hash = crypto.SHA512
+	}
+
+	signed, outer := splitContent(m)
+	// Signed parts must survive any mail transport unchanged, so are always 7bit
+	content, err := signed.BytesWithPolicy(Encoding7Bit)
+	if err != nil {
+		return nil, err
+	}
+	signed.Raw, signed.WriteRaw = content, true
+	signature, err := s.sign(content, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	micalg := "sha-256"
+	if hash == crypto.SHA512 {
+		micalg = "sha-512"
+	}
+	outer.Header.Set("Content-Type", mime.FormatMediaType("multipart/signed", map[string]string{
+		"protocol": "application/pkcs7-signature", "micalg": micalg, "boundary": randomBoundary()}))
+	outer.Parts = []*Message{signed, {
+		Header: Header{
+			"Content-Type":        []string{"application/pkcs7-signature; name=\"smime.p7s\""},
+			"Content-Disposition": []string{"attachment; filename=\"smime.p7s\""},
+		},
+		Body:             signature,
+		TransferEncoding: TransferEncodingBase64,
+	}}
+	return outer, nil
+}
+
+// sign returns the DER of a detached CMS SignedData signature of the content.
+func (s *SMIMESigner) sign(content []byte, hash crypto.Hash) ([]byte, error) {
+	var signatureAlgorithm asn1.ObjectIdentifier
+	switch s.Certificate.PublicKeyAlgorithm {
+	case x509.RSA:
+		signatureAlgorithm = oidRSAEncryption
+	case x509.ECDSA:
+		signatureAlgorithm = oidECDSAWithSHA256
+		if hash == crypto.SHA512 {
+			signatureAlgorithm = oidECDSAWithSHA512
+		}
+	case x509.Ed25519:
+		signatureAlgorithm = oidEd25519
+	default:
+		return nil, errors.New("Unsupported S/MIME certificate key type")
+	}
+
+	now := time.Now
+	if s.Now != nil {
+		now = s.Now
+	}
+	digest := hash.New()
+	digest.Write(content)
+	attributes, err := cmsSignedAttributes(map[string]interface{}{
+		oidAttributeContentType.String():   oidData,
+		oidAttributeSigningTime.String():   now().UTC(),
+		oidAttributeMessageDigest.String(): digest.Sum(nil),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The signature is of the attributes as a DER SET, although they are encoded as [0] IMPLICIT
+	signedAttributes, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: attributes})
+	if err != nil {
+		return nil, err
+	}
+	var signature []byte
+	if signatureAlgorithm.Equal(oidEd25519) {
+		signature, err = s.Key.Sign(rand.Reader, signedAttributes, crypto.Hash(0))
+	} else {
+		attributesDigest := hash.New()
+		attributesDigest.Write(signedAttributes)
+		signature, err = s.Key.Sign(rand.Reader, attributesDigest.Sum(nil), hash)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sid, err := cmsIssuerAndSerialOf(s.Certificate)
+	if err != nil {
+		return nil, err
+	}
+	certificates := append([]byte(nil), s.Certificate.Raw...)
+	for _, intermediate := range s.Intermediates {
+		certificates = append(certificates, intermediate.Raw...)
+	}
+	digestAlgorithm := pkix.AlgorithmIdentifier{Algorithm: cmsDigestAlgorithm(hash)}
+	signedData, err := asn1.Marshal(cmsSignedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{digestAlgorithm},
+		EncapContentInfo: cmsContentInfo{ContentType: oidData},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: certificates},
+		SignerInfos: []cmsSignerInfo{{
+			Version:            1,
+			SID:                sid,
+			DigestAlgorithm:    digestAlgorithm,
+			SignedAttrs:        asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: attributes},
+			SignatureAlgorithm: pkix.AlgorithmIdentifier{Algorithm: signatureAlgorithm},
+			Signature:          signature,
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cmsWrap(oidSignedData, signedData)
+}
+
+// HasSMIMESignature returns true if this Message is an S/MIME multipart/signed message.
+func (m *Message) HasSMIMESignature() bool {
+	mediaType, params, err := m.Header.ContentType()
+	return err == nil && mediaType == "multipart/signed" && isSMIMESignatureType(params["protocol"])
+}
+
+// Verify verifies the signatures of an S/MIME multipart/signed message, and that each signer's
+// certificate chains to a trusted root and allows email protection, returning an error if not.
+// The signature is of the exact bytes of the signed part, which are its Raw bytes,
+// as kept by ParseMessage and set by SMIMESigner, or if not set, the part written out again.
+func (v *SMIMEVerifier) Verify(m *Message) (*SMIMEVerification, error) {
+	if !m.HasSMIMESignature() {
+		return nil, errors.New("Message is not an S/MIME multipart/signed message")
+	}
+	if len(m.Parts) != 2 {
+		return nil, errors.New("S/MIME multipart/signed message must have 2 parts")
+	}
+	if signatureType, _, err := m.Parts[1].Header.ContentType(); err != nil || !isSMIMESignatureType(signatureType) {
+		return nil, errors.New("S/MIME multipart/signed message is missing its signature part")
+	}
+	content, err := signedBytes(m.Parts[0])
+	if err != nil {
+		return nil, err
+	}
+	return v.verify(content, m.Parts[1].Body)
+}
+
+// verify verifies a detached CMS SignedData signature of the content.
+func (v *SMIMEVerifier) verify(content []byte, signature []byte) (*SMIMEVerification, error) {
+	der, err := cmsUnwrap(signature, oidSignedData)
+	if err != nil {
+		return nil, err
+	}
+	var signedData cmsSignedData
+	if _, err = asn1.Unmarshal(der, &signedData); err != nil {
+		return nil, errors.New("Invalid S/MIME signature: " + err.Error())
+	}
+	if len(signedData.SignerInfos) == 0 {
+		return nil, errors.New("S/MIME signature has no signers")
+	}
+	certificates, err := x509.ParseCertificates(signedData.Certificates.Bytes)
+	if err != nil {
+		return nil, errors.New("Invalid S/MIME signature certificates: " + err.Error())
+	}
+
+	intermediates := x509.NewCertPool()
+	if v.Intermediates != nil {
+		intermediates = v.Intermediates.Clone()
+	}
+	for _, cert := range certificates {
+		intermediates.AddCert(cert)
+	}
+	now := time.Now
+	if v.Now != nil {
+		now = v.Now
+	}
+
+	verification := &SMIMEVerification{}
+	for _, signer := range signedData.SignerInfos {
+		var cert *x509.Certificate
+		for _, candidate := range certificates {
+			if cmsMatchesCertificate(signer.SID, candidate) {
+				cert = candidate
+				break
+			}
+		}
+		if cert == nil {
+			return nil, errors.New("S/MIME signature is missing the signer's certificate")
+		}
+		signingTime, err := v.verifySigner(signer, cert, content)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = cert.Verify(x509.VerifyOptions{Roots: v.Roots, Intermediates: intermediates, CurrentTime: now(),
+			KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection}}); err != nil {
+			return nil, errors.New("S/MIME signer's certificate is not trusted: " + err.Error())
+		}
+		verification.Signers = append(verification.Signers, cert)
+		if verification.SigningTime.IsZero() {
+			verification.SigningTime = signingTime
+		}
+	}
+	return verification, nil
+}
+
+// verifySigner verifies a single signer's signature of the content, returning the signing time, if any.
+func (v *SMIMEVerifier) verifySigner(signer cmsSignerInfo, cert *x509.Certificate, content []byte) (time.Time, error) {
+	var signingTime time.Time
+	hash := cmsDigestHash(signer.DigestAlgorithm.Algorithm)
+	if hash == 0 || !hash.Available() {
+		return signingTime, errors.New("Unsupported S/MIME digest algorithm: " + signer.DigestAlgorithm.Algorithm.String())
+	}
+	algorithm := cmsSignatureAlgorithm(signer.SignatureAlgorithm.Algorithm, hash)
+	if algorithm == x509.UnknownSignatureAlgorithm {
+		return signingTime, errors.New("Unsupported S/MIME signature algorithm: " + signer.SignatureAlgorithm.Algorithm.String())
+	}
+
+	signed := content
+	if len(signer.SignedAttrs.FullBytes) > 0 {
+		digest := hash.New()
+		digest.Write(content)
+		var matched bool
+		for rest := signer.SignedAttrs.Bytes; len(rest) > 0; {
+			var attribute cmsAttribute
+			var err error
+			if rest, err = asn1.Unmarshal(rest, &attribute); err != nil {
+				return signingTime, errors.New("Invalid S/MIME signed attributes: " + err.Error())
+			}
+			if len(attribute.Values) != 1 {
+				continue
+			}
+			switch {
+			case attribute.Type.Equal(oidAttributeMessageDigest):
+				var messageDigest []byte
+				if _, err = asn1.Unmarshal(attribute.Values[0].FullBytes, &messageDigest); err != nil {
+					return signingTime, errors.New("Invalid S/MIME message digest: " + err.Error())
+				}
+				matched = bytes.Equal(messageDigest, digest.Sum(nil))
+			case attribute.Type.Equal(oidAttributeSigningTime):
+				asn1.Unmarshal(attribute.Values[0].FullBytes, &signingTime)
+			}
+		}
+		if !matched {
+			return signingTime, errors.New("S/MIME signature does not match the content")
+		}
+		// The signature is of the attributes as a DER SET, rather than as [0] IMPLICIT
+		signed = append([]byte{0x31}, signer.SignedAttrs.FullBytes[1:]...)
+	}
+	if err := cert.CheckSignature(algorithm, signed, signer.Signature); err != nil {
+		return signingTime, errors.New("S/MIME signature did not verify: " + err.Error())
+	}
+	return signingTime, nil
+}
+
+// cmsSignedAttributes returns the DER of the attributes, sorted as a DER SET OF requires,
+// from a map of attribute type (as a string) to value.
+func cmsSignedAttributes(values map[string]interface{}) ([]byte, error) {
+	var encoded [][]byte
+	for _, oid := range []asn1.ObjectIdentifier{oidAttributeContentType, oidAttributeSigningTime, oidAttributeMessageDigest} {
+		value, ok := values[oid.String()]
+		if !ok {
+			continue
+		}
+		der, err := asn1.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		attribute, err := asn1.Marshal(cmsAttribute{Type: oid, Values: []asn1.RawValue{{FullBytes: der}}})
+		if err != nil {
+			return nil, err
+		}
+		encoded = append(encoded, attribute)
+	}
+	sort.Slice(encoded, func(i, j int) bool { return bytes.Compare(encoded[i], encoded[j]) < 0 })
+	return bytes.Join(encoded, nil), nil
+}
+
+// isSMIMESignatureType returns true for the S/MIME signature media type, or its older name.
+func isSMIMESignatureType(mediaType string) bool {
+	mediaType = strings.ToLower(mediaType)
+	return mediaType == "application/pkcs7-signature" || mediaType == "application/x-pkcs7-signature"
+}
@@ -0,0 +1,559 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package email
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+)
+
+// SMIMECipher is the algorithm that the content of an S/MIME encrypted message is encrypted with.
+type SMIMECipher int
+
+// The content encryption algorithms supported by SMIMEEncrypter
+const (
+	// SMIMECipherAES256GCM is AES-256 in GCM mode, which also authenticates the content,
+	// creating authEnveloped-data as defined by RFC 5083. It is the default.
+	SMIMECipherAES256GCM SMIMECipher = iota
+
+	// SMIMECipherAES128GCM is AES-128 in GCM mode, creating authEnveloped-data.
+	SMIMECipherAES128GCM
+
+	// SMIMECipherAES256CBC is AES-256 in CBC mode, creating enveloped-data,
+	// for recipients whose clients do not support authEnveloped-data.
+	SMIMECipherAES256CBC
+
+	// SMIMECipherAES128CBC is AES-128 in CBC mode, creating enveloped-data.
+	SMIMECipherAES128CBC
+)
+
+// SMIMEEncrypter encrypts messages with S/MIME, as defined by RFC 8551,
+// creating application/pkcs7-mime messages that only the recipients can decrypt.
+type SMIMEEncrypter struct {
+	// Recipients are the certificates of the recipients, whose public keys must be RSA or ECDSA.
+	// The content encryption key is encrypted with RSA-OAEP for RSA keys,
+	// and wrapped with a key agreed with ECDH for ECDSA keys.
+	// Senders usually include their own certificate, so that they can read their sent mail.
+	Recipients []*x509.Certificate
+
+	// Cipher is the content encryption algorithm, which defaults to AES-256-GCM.
+	Cipher SMIMECipher
+}
+
+// SMIMEDecrypter decrypts S/MIME encrypted messages, as defined by RFC 8551.
+type SMIMEDecrypter struct {
+	// Certificate is the recipient's certificate, which identifies which recipient's key to decrypt.
+	Certificate *x509.Certificate
+
+	// Key is the private key of the Certificate: an *ecdsa.PrivateKey,
+	// or a crypto.Decrypter of an RSA key, such as an *rsa.PrivateKey.
+	Key crypto.PrivateKey
+}
+
+// Encrypt returns a new application/pkcs7-mime message, whose body is the content of the message
+// (its Content-* header fields and payload) encrypted for each of the Recipients.
+// The other header fields, such as From and Subject, are moved to the new message unencrypted.
+// To both sign and encrypt a message, sign it first, and then encrypt the signed message.
+func (e *SMIMEEncrypter) Encrypt(m *Message) (*Message, error) {
+	if len(e.Recipients) == 0 {
+		return nil, errors.New("S/MIME encryption requires at least one Recipient")
+	}
+	var contentAlgorithm asn1.ObjectIdentifier
+	var keySize int
+	switch e.Cipher {
+	case SMIMECipherAES256GCM:
+		contentAlgorithm, keySize = oidAES256GCM, 32
+	case SMIMECipherAES128GCM:
+		contentAlgorithm, keySize = oidAES128GCM, 16
+	case SMIMECipherAES256CBC:
+		contentAlgorithm, keySize = oidAES256CBC, 32
+	case SMIMECipherAES128CBC:
+		contentAlgorithm, keySize = oidAES128CBC, 16
+	default:
+		return nil, errors.New("Unsupported S/MIME cipher")
+	}
+
+	inner, outer := splitContent(m)
+	content, err := inner.BytesWithPolicy(Encoding7Bit)
+	if err != nil {
+		return nil, err
+	}
+	key := make([]byte, keySize)
+	if _, err = io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+
+	var recipientInfos []asn1.RawValue
+	version := 0
+	for _, cert := range e.Recipients {
+		var recipientInfo asn1.RawValue
+		switch publicKey := cert.PublicKey.(type) {
+		case *rsa.PublicKey:
+			recipientInfo, err = smimeKeyTransRecipient(cert, publicKey, key)
+		case *ecdsa.PublicKey:
+			recipientInfo, err = smimeKeyAgreeRecipient(cert, publicKey, key)
+			// Enveloped data with any key agreement recipients is version 2
+			version = 2
+		default:
+			err = errors.New("Unsupported S/MIME recipient key type")
+		}
+		if err != nil {
+			return nil, err
+		}
+		recipientInfos = append(recipientInfos, recipientInfo)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	var der []byte
+	smimeType := "enveloped-data"
+	if e.Cipher == SMIMECipherAES256GCM || e.Cipher == SMIMECipherAES128GCM {
+		smimeType = "authEnveloped-data"
+		gcm, err := cipher.NewGCMWithTagSize(block, 16)
+		if err != nil {
+			return nil, err
+		}
+		nonce := make([]byte, gcm.NonceSize())
+		if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+			return nil, err
+		}
+		params, err := asn1.Marshal(cmsGCMParameters{Nonce: nonce, ICVLen: 16})
+		if err != nil {
+			return nil, err
+		}
+		sealed := gcm.Seal(nil, nonce, content, nil)
+		encrypted := sealed[:len(sealed)-16]
+		if der, err = asn1.Marshal(cmsAuthEnvelopedData{
+			RecipientInfos:           recipientInfos,
+			AuthEncryptedContentInfo: smimeEncryptedContentInfo(contentAlgorithm, params, encrypted),
+			MAC:                      sealed[len(sealed)-16:],
+		}); err != nil {
+			return nil, err
+		}
+		if der, err = cmsWrap(oidAuthEnvelopedData, der); err != nil {
+			return nil, err
+		}
+	} else {
+		iv := make([]byte, aes.BlockSize)
+		if _, err = io.ReadFull(rand.Reader, iv); err != nil {
+			return nil, err
+		}
+		params, err := asn1.Marshal(iv)
+		if err != nil {
+			return nil, err
+		}
+		// PKCS #7 padding always adds 1 to 16 bytes of the padding length
+		padding := aes.BlockSize - len(content)%aes.BlockSize
+		encrypted := append(append([]byte(nil), content...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, encrypted)
+		if der, err = asn1.Marshal(cmsEnvelopedData{
+			Version:              version,
+			RecipientInfos:       recipientInfos,
+			EncryptedContentInfo: smimeEncryptedContentInfo(contentAlgorithm, params, encrypted),
+		}); err != nil {
+			return nil, err
+		}
+		if der, err = cmsWrap(oidEnvelopedData, der); err != nil {
+			return nil, err
+		}
+	}
+
+	outer.Header.Set("Content-Type", "application/pkcs7-mime; smime-type="+smimeType+"; name=\"smime.p7m\"")
+	outer.Header.Set("Content-Disposition", "attachment; filename=\"smime.p7m\"")
+	outer.Body = der
+	outer.TransferEncoding = TransferEncodingBase64
+	return outer, nil
+}
+
+// smimeEncryptedContentInfo returns the EncryptedContentInfo of data content encrypted with the algorithm.
+func smimeEncryptedContentInfo(algorithm asn1.ObjectIdentifier, params []byte, encrypted []byte) cmsEncryptedContentInfo {
+	return cmsEncryptedContentInfo{
+		ContentType:                oidData,
+		ContentEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: algorithm, Parameters: asn1.RawValue{FullBytes: params}},
+		EncryptedContent:           asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, Bytes: encrypted},
+	}
+}
+
+// smimeKeyTransRecipient returns a KeyTransRecipientInfo, with the key encrypted by RSA-OAEP with SHA-256.
+func smimeKeyTransRecipient(cert *x509.Certificate, publicKey *rsa.PublicKey, key []byte) (asn1.RawValue, error) {
+	encryptedKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, publicKey, key, nil)
+	if err != nil {
+		return asn1.RawValue{}, err
+	}
+	rid, err := cmsIssuerAndSerialOf(cert)
+	if err != nil {
+		return asn1.RawValue{}, err
+	}
+	sha256Algorithm, err := asn1.Marshal(pkix.AlgorithmIdentifier{Algorithm: oidSHA256})
+	if err != nil {
+		return asn1.RawValue{}, err
+	}
+	params, err := asn1.Marshal(cmsRSAOAEPParameters{
+		HashFunc:    pkix.AlgorithmIdentifier{Algorithm: oidSHA256},
+		MaskGenFunc: pkix.AlgorithmIdentifier{Algorithm: oidMGF1, Parameters: asn1.RawValue{FullBytes: sha256Algorithm}},
+	})
+	if err != nil {
+		return asn1.RawValue{}, err
+	}
+	der, err := asn1.Marshal(cmsKeyTransRecipientInfo{
+		RID:                    rid,
+		KeyEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidRSAESOAEP, Parameters: asn1.RawValue{FullBytes: params}},
+		EncryptedKey:           encryptedKey,
+	})
+	return asn1.RawValue{FullBytes: der}, err
+}
+
+// smimeKeyAgreeRecipient returns a [1] KeyAgreeRecipientInfo, with the key wrapped by AES-256 key wrap,
+// with a key agreed by ECDH between an ephemeral key and the recipient's key, derived with SHA-256.
+func smimeKeyAgreeRecipient(cert *x509.Certificate, publicKey *ecdsa.PublicKey, key []byte) (asn1.RawValue, error) {
+	recipientKey, err := publicKey.ECDH()
+	if err != nil {
+		return asn1.RawValue{}, err
+	}
+	ephemeral, err := recipientKey.Curve().GenerateKey(rand.Reader)
+	if err != nil {
+		return asn1.RawValue{}, err
+	}
+	secret, err := ephemeral.ECDH(recipientKey)
+	if err != nil {
+		return asn1.RawValue{}, err
+	}
+	wrapAlgorithm, err := asn1.Marshal(pkix.AlgorithmIdentifier{Algorithm: oidAES256Wrap})
+	if err != nil {
+		return asn1.RawValue{}, err
+	}
+	kek, err := smimeKeyAgreementKEK(crypto.SHA256, secret, wrapAlgorithm, nil, 32)
+	if err != nil {
+		return asn1.RawValue{}, err
+	}
+	encryptedKey, err := cmsKeyWrap(kek, key)
+	if err != nil {
+		return asn1.RawValue{}, err
+	}
+
+	point := ephemeral.PublicKey().Bytes()
+	originator, err := asn1.MarshalWithParams(cmsOriginatorPublicKey{
+		Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidECPublicKey},
+		PublicKey: asn1.BitString{Bytes: point, BitLength: len(point) * 8},
+	}, "tag:1")
+	if err != nil {
+		return asn1.RawValue{}, err
+	}
+	rid, err := cmsIssuerAndSerialOf(cert)
+	if err != nil {
+		return asn1.RawValue{}, err
+	}
+	der, err := asn1.MarshalWithParams(cmsKeyAgreeRecipientInfo{
+		Version:                3,
+		Originator:             asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: originator},
+		KeyEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidECDHSinglePassSHA256KDF, Parameters: asn1.RawValue{FullBytes: wrapAlgorithm}},
+		RecipientEncryptedKeys: []cmsRecipientEncryptedKey{{RID: rid, EncryptedKey: encryptedKey}},
+	}, "tag:1")
+	return asn1.RawValue{FullBytes: der}, err
+}
+
+// smimeKeyAgreementKEK derives the key encryption key of a key agreement, as defined by RFC 5753.
+func smimeKeyAgreementKEK(hash crypto.Hash, secret []byte, wrapAlgorithm []byte, ukm []byte, size int) ([]byte, error) {
+	suppPubInfo := make([]byte, 4)
+	binary.BigEndian.PutUint32(suppPubInfo, uint32(size*8))
+	sharedInfo, err := asn1.Marshal(cmsECCSharedInfo{KeyInfo: asn1.RawValue{FullBytes: wrapAlgorithm}, EntityUInfo: ukm, SuppPubInfo: suppPubInfo})
+	if err != nil {
+		return nil, err
+	}
+	return cmsKDF(hash, secret, sharedInfo, size), nil
+}
+
+// HasSMIMEEncryption returns true if this Message is an S/MIME encrypted application/pkcs7-mime message.
+func (m *Message) HasSMIMEEncryption() bool {
+	mediaType, params, err := m.Header.ContentType()
+	if err != nil || mediaType != "application/pkcs7-mime" && mediaType != "application/x-pkcs7-mime" {
+		return false
+	}
+	smimeType := strings.ToLower(params["smime-type"])
+	// Some older clients omit the smime-type
+	return smimeType == "enveloped-data" || smimeType == "authenveloped-data" || smimeType == ""
+}
+
+// Decrypt decrypts an S/MIME encrypted message, returning the parsed message that was encrypted.
+// Any header fields of the encrypted message that are not in the decrypted message, such as From and Subject,
+// are copied to it, so that it has the same header fields as before it was encrypted.
+func (d *SMIMEDecrypter) Decrypt(m *Message) (*Message, error) {
+	if !m.HasSMIMEEncryption() {
+		return nil, errors.New("Message is not an S/MIME encrypted message")
+	}
+	if d.Certificate == nil || d.Key == nil {
+		return nil, errors.New("S/MIME decryption requires a Certificate and Key")
+	}
+	contentType, der, err := cmsParse(m.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var recipientInfos []asn1.RawValue
+	var contentInfo cmsEncryptedContentInfo
+	var mac []byte
+	switch {
+	case contentType.Equal(oidEnvelopedData):
+		var envelopedData cmsEnvelopedData
+		if _, err = asn1.Unmarshal(der, &envelopedData); err != nil {
+			return nil, errors.New("Invalid S/MIME enveloped data: " + err.Error())
+		}
+		recipientInfos, contentInfo = envelopedData.RecipientInfos, envelopedData.EncryptedContentInfo
+	case contentType.Equal(oidAuthEnvelopedData):
+		var authEnvelopedData cmsAuthEnvelopedData
+		if _, err = asn1.Unmarshal(der, &authEnvelopedData); err != nil {
+			return nil, errors.New("Invalid S/MIME authenticated enveloped data: " + err.Error())
+		}
+		if len(authEnvelopedData.AuthAttrs.FullBytes) > 0 {
+			return nil, errors.New("Unsupported S/MIME authenticated attributes")
+		}
+		recipientInfos, contentInfo, mac = authEnvelopedData.RecipientInfos, authEnvelopedData.AuthEncryptedContentInfo, authEnvelopedData.MAC
+	default:
+		return nil, errors.New("Unexpected S/MIME content type: " + contentType.String())
+	}
+
+	key, err := d.contentKey(recipientInfos)
+	if err != nil {
+		return nil, err
+	}
+	content, err := smimeDecryptContent(contentInfo, key, mac)
+	if err != nil {
+		return nil, err
+	}
+	decrypted, err := ParseMessage(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	restoreHeader(decrypted, m)
+	return decrypted, nil
+}
+
+// contentKey returns the content encryption key, decrypted from the recipient info for the Certificate.
+func (d *SMIMEDecrypter) contentKey(recipientInfos []asn1.RawValue) ([]byte, error) {
+	for _, recipientInfo := range recipientInfos {
+		switch {
+		case recipientInfo.Class == asn1.ClassUniversal && recipientInfo.Tag == asn1.TagSequence:
+			var keyTrans cmsKeyTransRecipientInfo
+			if _, err := asn1.Unmarshal(recipientInfo.FullBytes, &keyTrans); err != nil {
+				return nil, errors.New("Invalid S/MIME recipient: " + err.Error())
+			}
+			if cmsMatchesCertificate(keyTrans.RID, d.Certificate) {
+				return d.decryptKeyTrans(keyTrans)
+			}
+		case recipientInfo.Class == asn1.ClassContextSpecific && recipientInfo.Tag == 1:
+			var keyAgree cmsKeyAgreeRecipientInfo
+			if _, err := asn1.UnmarshalWithParams(recipientInfo.FullBytes, &keyAgree, "tag:1"); err != nil {
+				return nil, errors.New("Invalid S/MIME recipient: " + err.Error())
+			}
+			for _, recipient := range keyAgree.RecipientEncryptedKeys {
+				rid := recipient.RID
+				if rid.Class == asn1.ClassContextSpecific && rid.Tag == 0 {
+					// A RecipientKeyIdentifier starts with the subject key identifier
+					var subjectKeyID []byte
+					if _, err := asn1.Unmarshal(rid.Bytes, &subjectKeyID); err != nil {
+						continue
+					}
+					rid = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, Bytes: subjectKeyID}
+				}
+				if cmsMatchesCertificate(rid, d.Certificate) {
+					return d.decryptKeyAgree(keyAgree, recipient.EncryptedKey)
+				}
+			}
+		}
+	}
+	return nil, errors.New("S/MIME message is not encrypted for this certificate")
+}
+
+// decryptKeyTrans decrypts a content encryption key encrypted with the recipient's RSA public key.
+func (d *SMIMEDecrypter) decryptKeyTrans(keyTrans cmsKeyTransRecipientInfo) ([]byte, error) {
+	decrypter, ok := d.Key.(crypto.Decrypter)
+	if _, isRSA := d.Certificate.PublicKey.(*rsa.PublicKey); !ok || !isRSA {
+		return nil, errors.New("S/MIME key transport requires an RSA Key")
+	}
+	var opts crypto.DecrypterOpts
+	switch algorithm := keyTrans.KeyEncryptionAlgorithm; {
+	case algorithm.Algorithm.Equal(oidRSAEncryption):
+		opts = &rsa.PKCS1v15DecryptOptions{}
+	case algorithm.Algorithm.Equal(oidRSAESOAEP):
+		var params cmsRSAOAEPParameters
+		if len(algorithm.Parameters.FullBytes) > 0 {
+			if _, err := asn1.Unmarshal(algorithm.Parameters.FullBytes, &params); err != nil {
+				return nil, errors.New("Invalid S/MIME RSA-OAEP parameters: " + err.Error())
+			}
+		}
+		hash, mgfHash := crypto.SHA1, crypto.SHA1
+		if len(params.HashFunc.Algorithm) > 0 {
+			hash = cmsDigestHash(params.HashFunc.Algorithm)
+		}
+		if len(params.MaskGenFunc.Algorithm) > 0 {
+			var mgfAlgorithm pkix.AlgorithmIdentifier
+			if !params.MaskGenFunc.Algorithm.Equal(oidMGF1) {
+				return nil, errors.New("Unsupported S/MIME RSA-OAEP mask generation function")
+			}
+			if _, err := asn1.Unmarshal(params.MaskGenFunc.Parameters.FullBytes, &mgfAlgorithm); err != nil {
+				return nil, errors.New("Invalid S/MIME RSA-OAEP parameters: " + err.Error())
+			}
+			mgfHash = cmsDigestHash(mgfAlgorithm.Algorithm)
+		}
+		if hash == 0 || mgfHash == 0 || len(params.PSourceFunc.Algorithm) > 0 {
+			return nil, errors.New("Unsupported S/MIME RSA-OAEP parameters")
+		}
+		opts = &rsa.OAEPOptions{Hash: hash, MGFHash: mgfHash}
+	default:
+		return nil, errors.New("Unsupported S/MIME key encryption algorithm: " + algorithm.Algorithm.String())
+	}
+	key, err := decrypter.Decrypt(rand.Reader, keyTrans.EncryptedKey, opts)
+	if err != nil {
+		return nil, errors.New("S/MIME content key did not decrypt: " + err.Error())
+	}
+	return key, nil
+}
+
+// decryptKeyAgree unwraps a content encryption key wrapped with a key agreed with the recipient's ECDSA public key.
+func (d *SMIMEDecrypter) decryptKeyAgree(keyAgree cmsKeyAgreeRecipientInfo, encryptedKey []byte) ([]byte, error) {
+	privateKey, ok := d.Key.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("S/MIME key agreement requires an ECDSA Key")
+	}
+	var hash crypto.Hash
+	switch algorithm := keyAgree.KeyEncryptionAlgorithm.Algorithm; {
+	case algorithm.Equal(oidECDHSinglePassSHA1KDF):
+		hash = crypto.SHA1
+	case algorithm.Equal(oidECDHSinglePassSHA256KDF):
+		hash = crypto.SHA256
+	case algorithm.Equal(oidECDHSinglePassSHA384KDF):
+		hash = crypto.SHA384
+	case algorithm.Equal(oidECDHSinglePassSHA512KDF):
+		hash = crypto.SHA512
+	default:
+		return nil, errors.New("Unsupported S/MIME key agreement algorithm: " + algorithm.String())
+	}
+	wrapAlgorithm := keyAgree.KeyEncryptionAlgorithm.Parameters.FullBytes
+	var wrap pkix.AlgorithmIdentifier
+	if _, err := asn1.Unmarshal(wrapAlgorithm, &wrap); err != nil {
+		return nil, errors.New("Invalid S/MIME key wrap algorithm: " + err.Error())
+	}
+	var size int
+	switch {
+	case wrap.Algorithm.Equal(oidAES128Wrap):
+		size = 16
+	case wrap.Algorithm.Equal(oidAES192Wrap):
+		size = 24
+	case wrap.Algorithm.Equal(oidAES256Wrap):
+		size = 32
+	default:
+		return nil, errors.New("Unsupported S/MIME key wrap algorithm: " + wrap.Algorithm.String())
+	}
+
+	// Only an originator public key is possible, as the originator's key must be ephemeral
+	var originator cmsOriginatorPublicKey
+	if _, err := asn1.UnmarshalWithParams(keyAgree.Originator.Bytes, &originator, "tag:1"); err != nil {
+		return nil, errors.New("Unsupported S/MIME key agreement originator: " + err.Error())
+	}
+	recipientKey, err := privateKey.ECDH()
+	if err != nil {
+		return nil, err
+	}
+	originatorKey, err := recipientKey.Curve().NewPublicKey(originator.PublicKey.Bytes)
+	if err != nil {
+		return nil, errors.New("Invalid S/MIME key agreement originator: " + err.Error())
+	}
+	secret, err := recipientKey.ECDH(originatorKey)
+	if err != nil {
+		return nil, err
+	}
+	kek, err := smimeKeyAgreementKEK(hash, secret, wrapAlgorithm, keyAgree.UKM, size)
+	if err != nil {
+		return nil, err
+	}
+	key, err := cmsKeyUnwrap(kek, encryptedKey)
+	if err != nil {
+		return nil, errors.New("S/MIME content key did not decrypt: " + err.Error())
+	}
+	return key, nil
+}
+
+// smimeDecryptContent decrypts the content with the content encryption key, and authenticates it with the mac, if any.
+func smimeDecryptContent(contentInfo cmsEncryptedContentInfo, key []byte, mac []byte) ([]byte, error) {
+	encrypted := contentInfo.EncryptedContent.Bytes
+	if contentInfo.EncryptedContent.IsCompound {
+		var err error
+		if encrypted, err = cmsJoinOctetStrings(encrypted); err != nil {
+			return nil, errors.New("Invalid S/MIME encrypted content: " + err.Error())
+		}
+	}
+
+	algorithm := contentInfo.ContentEncryptionAlgorithm
+	var keySize int
+	gcm := mac != nil
+	switch {
+	case algorithm.Algorithm.Equal(oidAES128CBC), algorithm.Algorithm.Equal(oidAES128GCM):
+		keySize = 16
+	case algorithm.Algorithm.Equal(oidAES192CBC), algorithm.Algorithm.Equal(oidAES192GCM):
+		keySize = 24
+	case algorithm.Algorithm.Equal(oidAES256CBC), algorithm.Algorithm.Equal(oidAES256GCM):
+		keySize = 32
+	}
+	isGCM := algorithm.Algorithm.Equal(oidAES128GCM) || algorithm.Algorithm.Equal(oidAES192GCM) || algorithm.Algorithm.Equal(oidAES256GCM)
+	if keySize == 0 || isGCM != gcm {
+		return nil, errors.New("Unsupported S/MIME content encryption algorithm: " + algorithm.Algorithm.String())
+	}
+	if len(key) != keySize {
+		return nil, errors.New("S/MIME content key is the wrong length")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if gcm {
+		var params cmsGCMParameters
+		if _, err = asn1.Unmarshal(algorithm.Parameters.FullBytes, &params); err != nil {
+			return nil, errors.New("Invalid S/MIME AES-GCM parameters: " + err.Error())
+		}
+		if len(params.Nonce) != 12 || params.ICVLen != len(mac) {
+			return nil, errors.New("Unsupported S/MIME AES-GCM parameters")
+		}
+		aead, err := cipher.NewGCMWithTagSize(block, params.ICVLen)
+		if err != nil {
+			return nil, err
+		}
+		content, err := aead.Open(nil, params.Nonce, append(append([]byte(nil), encrypted...), mac...), nil)
+		if err != nil {
+			return nil, errors.New("S/MIME content failed to authenticate")
+		}
+		return content, nil
+	}
+
+	var iv []byte
+	if _, err = asn1.Unmarshal(algorithm.Parameters.FullBytes, &iv); err != nil || len(iv) != aes.BlockSize {
+		return nil, errors.New("Invalid S/MIME AES-CBC parameters")
+	}
+	if len(encrypted) == 0 || len(encrypted)%aes.BlockSize != 0 {
+		return nil, errors.New("S/MIME encrypted content is the wrong length")
+	}
+	content := make([]byte, len(encrypted))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(content, encrypted)
+	padding := int(content[len(content)-1])
+	if padding == 0 || padding > aes.BlockSize || !bytes.Equal(content[len(content)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return nil, errors.New("S/MIME content did not decrypt")
+	}
+	return content[:len(content)-padding], nil
+}
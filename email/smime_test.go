@@ -0,0 +1,294 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package email
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+// smimeTestNow is the time the test certificates and signatures are made at
+var smimeTestNow = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+// smimeTestCertificate returns a new certificate and key, signed by the parent, or self-signed if the parent is nil.
+func smimeTestCertificate(t *testing.T, template *x509.Certificate, key crypto.Signer, parent *x509.Certificate, parentKey crypto.Signer) *x509.Certificate {
+	t.Helper()
+	template.NotBefore = smimeTestNow.Add(-time.Hour)
+	template.NotAfter = smimeTestNow.Add(time.Hour)
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// smimeTestCA returns a new self-signed certificate authority and its key.
+func smimeTestCA(t *testing.T) (*x509.Certificate, crypto.Signer) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return smimeTestCertificate(t, &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "Test CA"},
+		IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign}, key, nil, nil), key
+}
+
+// TestSMIMESignAndVerify ...
+func TestSMIMESignAndVerify(t *testing.T) {
+	t.Parallel()
+
+	ca, caKey := smimeTestCA(t)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	now := func() time.Time { return smimeTestNow }
+	verifier := &SMIMEVerifier{Roots: roots, Now: now}
+
+	ecdsaKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, ed25519Key, _ := ed25519.GenerateKey(rand.Reader)
+	for idx, tc := range []struct {
+		key    crypto.Signer
+		micalg string
+	}{{ecdsaKey, "sha-256"}, {ed25519Key, "sha-512"}} {
+		key := tc.key
+		cert := smimeTestCertificate(t, &x509.Certificate{SerialNumber: big.NewInt(int64(idx + 2)), Subject: pkix.Name{CommonName: "Joe"},
+			EmailAddresses: []string{"joe@example.com"}, KeyUsage: x509.KeyUsageDigitalSignature,
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection}}, key, ca, caKey)
+		signer := &SMIMESigner{Certificate: cert, Key: key, Now: now}
+
+		m := &Message{Header: Header{}, Body: []byte("Hi Jim,\r\nSee you at dinner.\r\n")}
+		m.Header.SetFrom("joe@example.com")
+		m.Header.SetTo("jim@example.com")
+		m.Header.SetSubject("Dinner")
+		m.Header.Set("Content-Type", "text/plain; charset=\"UTF-8\"")
+		signed, err := signer.Sign(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !signed.HasSMIMESignature() || signed.Header.Subject() != "Dinner" || signed.Parts[0].Header.IsSet("Subject") ||
+			!strings.HasPrefix(signed.Parts[0].Header.Get("Content-Type"), "text/plain") {
+			t.Errorf("Case %d: unexpected signed message: %+v", idx, signed)
+		}
+		_, params, _ := signed.Header.ContentType()
+		if params["micalg"] != tc.micalg {
+			t.Errorf("Case %d: expected micalg %s; got %s", idx, tc.micalg, params["micalg"])
+		}
+
+		verification, err := verifier.Verify(signed)
+		if err != nil {
+			t.Fatalf("Case %d: %v", idx, err)
+		}
+		if len(verification.Signers) != 1 || !verification.Signers[0].Equal(cert) || !verification.SigningTime.Equal(smimeTestNow) {
+			t.Errorf("Case %d: unexpected verification: %+v", idx, verification)
+		}
+
+		// The signature still verifies after being written out and parsed again
+		b, err := signed.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed, err := ParseMessage(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = verifier.Verify(parsed); err != nil {
+			t.Errorf("Case %d: expected the parsed message to verify; got %v", idx, err)
+		}
+
+		// Parsing keeps the exact bytes of the signed part, and modifying them breaks the signature
+		if !bytes.Equal(parsed.Parts[0].Raw, signed.Parts[0].Raw) {
+			t.Errorf("Case %d: expected the parsed signed part to keep its raw bytes; got %q", idx, parsed.Parts[0].Raw)
+		}
+		parsed.Parts[0].Raw = bytes.Replace(parsed.Parts[0].Raw, []byte("dinner"), []byte("lunch"), 1)
+		if _, err = verifier.Verify(parsed); err == nil || !strings.Contains(err.Error(), "does not match the content") {
+			t.Errorf("Case %d: expected a content mismatch; got %v", idx, err)
+		}
+	}
+
+	// Signers that do not chain to a trusted root are rejected
+	untrusted, untrustedKey := smimeTestCA(t)
+	signer := &SMIMESigner{Certificate: untrusted, Key: untrustedKey, Now: now}
+	signed, err := signer.Sign(&Message{Header: Header{}, Body: []byte("Hello\r\n")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = verifier.Verify(signed); err == nil || !strings.Contains(err.Error(), "not trusted") {
+		t.Errorf("Expected an untrusted signer; got %v", err)
+	}
+	if _, err = verifier.Verify(&Message{Header: Header{}, Body: []byte("Hello\r\n")}); err == nil {
+		t.Error("Expected an error verifying an unsigned message")
+	}
+}
+
+// TestCMSKeyWrap ...
+func TestCMSKeyWrap(t *testing.T) {
+	t.Parallel()
+
+	// RFC 3394 section 4.1
+	kek, _ := hex.DecodeString("000102030405060708090A0B0C0D0E0F")
+	key, _ := hex.DecodeString("00112233445566778899AABBCCDDEEFF")
+	wrapped, err := cmsKeyWrap(kek, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "1fa68b0a8112b447aef34bd8fb5a7b829d3e862371d2cfe5"; hex.EncodeToString(wrapped) != expected {
+		t.Errorf("Expected %s; got %x", expected, wrapped)
+	}
+	if unwrapped, err := cmsKeyUnwrap(kek, wrapped); err != nil || !bytes.Equal(unwrapped, key) {
+		t.Errorf("Expected %x; got %x %v", key, unwrapped, err)
+	}
+	wrapped[0] ^= 1
+	if _, err := cmsKeyUnwrap(kek, wrapped); err == nil {
+		t.Error("Expected an integrity check failure")
+	}
+}
+
+// TestCMSBERToDER ...
+func TestCMSBERToDER(t *testing.T) {
+	t.Parallel()
+
+	// An indefinite length sequence, holding a long form length integer and a constructed octet string
+	ber, _ := hex.DecodeString("3080" + "028101" + "05" + "2480" + "04026869" + "0401" + "21" + "0000" + "0000")
+	der, err := cmsBERToDER(ber)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "30080201050403686921"; hex.EncodeToString(der) != expected {
+		t.Errorf("Expected %s; got %x", expected, der)
+	}
+	if _, err := cmsBERToDER(ber[:6]); err == nil {
+		t.Error("Expected an error converting truncated BER")
+	}
+
+	// Nesting is converted up to a limit, beyond which an error is returned rather than exhausting the stack
+	nested := append(bytes.Repeat([]byte{0x30, 0x80}, cmsMaxBERDepth), bytes.Repeat([]byte{0, 0}, cmsMaxBERDepth)...)
+	if der, err = cmsBERToDER(nested); err != nil || len(der) != 2*cmsMaxBERDepth || !bytes.HasSuffix(der, []byte{0x30, 0x00}) {
+		t.Errorf("Could not convert nested BER: %v", err)
+	}
+	if _, err = cmsBERToDER(bytes.Repeat([]byte{0x30, 0x80}, 4<<20)); err == nil {
+		t.Error("Expected an error converting deeply nested BER")
+	}
+}
+
+// TestSMIMEEncryptAndDecrypt ...
+func TestSMIMEEncryptAndDecrypt(t *testing.T) {
+	t.Parallel()
+
+	ca, caKey := smimeTestCA(t)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecdsaKey, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	recipient := func(serial int64, key crypto.Signer) *x509.Certificate {
+		return smimeTestCertificate(t, &x509.Certificate{SerialNumber: big.NewInt(serial), Subject: pkix.Name{CommonName: "Jim"},
+			EmailAddresses: []string{"jim@example.com"}, KeyUsage: x509.KeyUsageKeyEncipherment | x509.KeyUsageKeyAgreement,
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection}}, key, ca, caKey)
+	}
+	rsaCert, ecdsaCert := recipient(2, rsaKey), recipient(3, ecdsaKey)
+	otherCert := recipient(4, ecdsaKey)
+
+	m := &Message{Header: Header{}, Body: []byte("Hi Jim,\r\nThe code is 1234.\r\n")}
+	m.Header.SetFrom("joe@example.com")
+	m.Header.SetTo("jim@example.com")
+	m.Header.SetSubject("Secret")
+
+	for _, cipher := range []SMIMECipher{SMIMECipherAES256GCM, SMIMECipherAES128GCM, SMIMECipherAES256CBC, SMIMECipherAES128CBC} {
+		encrypter := &SMIMEEncrypter{Recipients: []*x509.Certificate{rsaCert, ecdsaCert}, Cipher: cipher}
+		encrypted, err := encrypter.Encrypt(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !encrypted.HasSMIMEEncryption() || encrypted.Header.Subject() != "Secret" || bytes.Contains(encrypted.Body, []byte("1234")) {
+			t.Errorf("Cipher %d: unexpected encrypted message: %+v", cipher, encrypted.Header)
+		}
+
+		b, err := encrypted.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed, err := ParseMessage(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, decrypter := range []*SMIMEDecrypter{{Certificate: rsaCert, Key: rsaKey}, {Certificate: ecdsaCert, Key: ecdsaKey}} {
+			decrypted, err := decrypter.Decrypt(parsed)
+			if err != nil {
+				t.Fatalf("Cipher %d: %v", cipher, err)
+			}
+			if string(decrypted.Body) != string(m.Body) || decrypted.Header.Subject() != "Secret" ||
+				decrypted.Header.Get("Content-Type") != "text/plain; charset=\"UTF-8\"" {
+				t.Errorf("Cipher %d: unexpected decrypted message: %+v %q", cipher, decrypted.Header, decrypted.Body)
+			}
+		}
+
+		if _, err = (&SMIMEDecrypter{Certificate: otherCert, Key: ecdsaKey}).Decrypt(parsed); err == nil || !strings.Contains(err.Error(), "not encrypted for this certificate") {
+			t.Errorf("Cipher %d: expected an error decrypting for another certificate; got %v", cipher, err)
+		}
+	}
+
+	// Authenticated encryption detects any modification
+	encrypted, err := (&SMIMEEncrypter{Recipients: []*x509.Certificate{ecdsaCert}}).Encrypt(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted.Body[len(encrypted.Body)-20] ^= 1
+	if _, err = (&SMIMEDecrypter{Certificate: ecdsaCert, Key: ecdsaKey}).Decrypt(encrypted); err == nil || !strings.Contains(err.Error(), "failed to authenticate") {
+		t.Errorf("Expected an authentication failure; got %v", err)
+	}
+}
+
+// TestSMIMESignThenEncrypt ...
+func TestSMIMESignThenEncrypt(t *testing.T) {
+	t.Parallel()
+
+	ca, caKey := smimeTestCA(t)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	cert := smimeTestCertificate(t, &x509.Certificate{SerialNumber: big.NewInt(2), Subject: pkix.Name{CommonName: "Joe"},
+		EmailAddresses: []string{"joe@example.com"}, KeyUsage: x509.KeyUsageDigitalSignature | x509.KeyUsageKeyAgreement,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection}}, key, ca, caKey)
+	now := func() time.Time { return smimeTestNow }
+
+	m := &Message{Header: Header{}, Body: []byte("Note to self\r\n")}
+	m.Header.SetSubject("Reminder")
+	signed, err := (&SMIMESigner{Certificate: cert, Key: key, Now: now}).Sign(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := (&SMIMEEncrypter{Recipients: []*x509.Certificate{cert}}).Encrypt(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := (&SMIMEDecrypter{Certificate: cert, Key: key}).Decrypt(encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !decrypted.HasSMIMESignature() || decrypted.Header.Subject() != "Reminder" {
+		t.Fatalf("Expected a signed message; got %+v", decrypted.Header)
+	}
+	if _, err = (&SMIMEVerifier{Roots: roots, Now: now}).Verify(decrypted); err != nil {
+		t.Error(err)
+	}
+}
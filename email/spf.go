@@ -0,0 +1,709 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package email
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// spfMaxLookups is the most mechanisms and modifiers that query DNS an SPF check may use,
+	// including those of every included and redirected record, as defined by RFC 7208.
+	spfMaxLookups = 10
+
+	// spfMaxVoidLookups is the most lookups an SPF check may make that find no records.
+	spfMaxVoidLookups = 2
+
+	// spfMaxNames is the most MX or PTR names a single mechanism may look up the addresses of.
+	spfMaxNames = 10
+
+	// spfMaxDomainLength is the longest a domain may be after macro expansion.
+	spfMaxDomainLength = 253
+)
+
+// SPFVerification is the result of checking whether a host is authorized
+// to send mail for a domain, with the Sender Policy Framework (SPF).
+type SPFVerification struct {
+	// Result is none (the domain has no SPF record), neutral, pass, fail, softfail,
+	// temperror (such as when DNS is unavailable), or permerror (such as when the record is invalid).
+	Result AuthResult
+
+	// Reason describes why the host did not pass.
+	Reason string
+
+	// Sender is the identity that was checked: the MAIL FROM address,
+	// or "postmaster@" followed by the HELO domain, if the MAIL FROM was empty.
+	Sender string
+
+	// Domain is the domain of the Sender, whose SPF record was checked.
+	Domain string
+
+	// Mechanism is the mechanism that determined the result, such as "ip4:192.0.2.0/24" or "-all", if any.
+	Mechanism string
+
+	// Explanation is the domain's explanation (exp=) of a fail result, if any.
+	// It is untrusted text from the domain, and may be given to the client when rejecting.
+	Explanation string
+
+	// helo is true if the HELO identity was checked, because the MAIL FROM was empty.
+	helo bool
+}
+
+// SPFVerifier checks whether hosts are authorized to send mail for a domain,
+// with the Sender Policy Framework (SPF), as defined by RFC 7208.
+type SPFVerifier struct {
+	// Resolver looks up SPF records and the addresses they refer to. If nil, DNSResolver is used.
+	Resolver Resolver
+
+	// Hostname is the name of the receiving server, for explanations. Defaults to "unknown".
+	Hostname string
+
+	// Now returns the current time, for explanations. If nil, time.Now is used.
+	Now func() time.Time
+}
+
+// Verify checks whether the host with this IP address is authorized to send mail
+// with this MAIL FROM address (the envelope sender). If the MAIL FROM is empty, such as
+// for a bounce, the HELO domain is checked instead.
+func (v *SPFVerifier) Verify(ip net.IP, helo string, mailFrom string) *SPFVerification {
+	verification := &SPFVerification{Sender: trimAngleBrackets(strings.TrimSpace(mailFrom))}
+	if len(verification.Sender) == 0 {
+		verification.Sender, verification.helo = "postmaster@"+helo, true
+	}
+	local, domain := "postmaster", verification.Sender
+	if idx := strings.LastIndexByte(verification.Sender, '@'); idx >= 0 {
+		local, domain = verification.Sender[:idx], verification.Sender[idx+1:]
+		if len(local) == 0 {
+			local = "postmaster"
+			verification.Sender = local + "@" + domain
+		}
+	} else {
+		verification.Sender = local + "@" + domain
+	}
+	verification.Domain = strings.TrimSuffix(domain, ".")
+
+	c := &spfCheck{resolver: v.Resolver, ip: ip, sender: verification.Sender, local: local,
+		senderDomain: verification.Domain, helo: helo, hostname: v.Hostname}
+	if c.resolver == nil {
+		c.resolver = DNSResolver{}
+	}
+	if len(c.hostname) == 0 {
+		c.hostname = "unknown"
+	}
+	if v.Now != nil {
+		c.now = v.Now()
+	} else {
+		c.now = time.Now()
+	}
+	if c.ip.To4() != nil {
+		c.ip = c.ip.To4()
+	}
+	verification.Result, verification.Mechanism, verification.Reason = c.checkHost(verification.Domain, true)
+	verification.Explanation = c.explanation
+	return verification
+}
+
+// AuthenticationResult returns this verification as a method result for an
+// Authentication-Results header field, such as "spf=pass smtp.mailfrom=user@example.com".
+func (v *SPFVerification) AuthenticationResult() *AuthenticationResult {
+	result := &AuthenticationResult{Method: "spf", Result: v.Result, Reason: v.Reason}
+	if v.helo {
+		result.AddProperty("smtp", "helo", v.Domain)
+	} else {
+		result.AddProperty("smtp", "mailfrom", v.Sender)
+	}
+	return result
+}
+
+// spfCheck is the state of a single SPF check, which is shared by included and redirected records.
+type spfCheck struct {
+	resolver     Resolver
+	ip           net.IP
+	sender       string
+	local        string
+	senderDomain string
+	helo         string
+	hostname     string
+	now          time.Time
+
+	lookups     int
+	voidLookups int
+	explanation string
+}
+
+// spfTerm is a parsed SPF mechanism, such as "-ip4:192.0.2.0/24" or "a:example.com/24".
+type spfTerm struct {
+	raw        string
+	qualifier  AuthResult
+	mechanism  string
+	domainSpec string
+	cidr4      int
+	cidr6      int
+	network    *net.IPNet
+}
+
+// checkHost evaluates the SPF record of the domain, returning the result,
+// the mechanism that matched, and the reason for any error.
+// The explanation is only looked up for the checked domain and redirects, and not for includes.
+func (c *spfCheck) checkHost(domain string, explain bool) (AuthResult, string, string) {
+	domain = strings.TrimSuffix(domain, ".")
+	if !validSPFDomain(domain) {
+		return AuthResultNone, "", "invalid domain: " + domain
+	}
+	record, result, reason := c.lookupRecord(domain)
+	if len(record) == 0 {
+		return result, "", reason
+	}
+	terms, redirect, exp, err := parseSPFRecord(record)
+	if err != nil {
+		return AuthResultPermError, "", err.Error()
+	}
+
+	for _, term := range terms {
+		match, result, reason := c.matches(term, domain)
+		if len(result) > 0 {
+			return result, term.raw, reason
+		}
+		if match {
+			if term.qualifier == AuthResultFail && explain && len(exp) > 0 {
+				c.explain(exp, domain)
+			}
+			return term.qualifier, term.raw, ""
+		}
+	}
+
+	if len(redirect) > 0 {
+		if c.lookups++; c.lookups > spfMaxLookups {
+			return AuthResultPermError, "redirect=" + redirect, "too many DNS lookups"
+		}
+		target, err := c.expand(redirect, domain)
+		if err != nil {
+			return AuthResultPermError, "redirect=" + redirect, err.Error()
+		}
+		result, mechanism, reason := c.checkHost(target, explain)
+		if result == AuthResultNone {
+			return AuthResultPermError, "redirect=" + redirect, "redirect to a domain without an SPF record: " + target
+		}
+		return result, mechanism, reason
+	}
+	return AuthResultNeutral, "", "no mechanism matched"
+}
+
+// lookupRecord returns the SPF record of the domain. If there is none,
+// it returns an empty record, with the result and reason.
+func (c *spfCheck) lookupRecord(domain string) (string, AuthResult, string) {
+	records, err := c.resolver.LookupTXT(domain)
+	if err != nil {
+		if isNotFound(err) {
+			return "", AuthResultNone, "no SPF record for " + domain
+		}
+		return "", AuthResultTempError, "SPF record unavailable: " + err.Error()
+	}
+	var found []string
+	for _, record := range records {
+		if strings.EqualFold(record, "v=spf1") || len(record) > 7 && strings.EqualFold(record[:7], "v=spf1 ") {
+			found = append(found, record)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return "", AuthResultNone, "no SPF record for " + domain
+	case 1:
+		return found[0], "", ""
+	}
+	return "", AuthResultPermError, "multiple SPF records for " + domain
+}
+
+// matches returns true if the mechanism matches the client, or an error result and reason.
+func (c *spfCheck) matches(term *spfTerm, domain string) (bool, AuthResult, string) {
+	switch term.mechanism {
+	case "all":
+		return true, "", ""
+	case "ip4", "ip6":
+		return term.network.Contains(c.ip), "", ""
+	}
+
+	// Every other mechanism queries DNS
+	if c.lookups++; c.lookups > spfMaxLookups {
+		return false, AuthResultPermError, "too many DNS lookups"
+	}
+	target := domain
+	if len(term.domainSpec) > 0 {
+		var err error
+		if target, err = c.expand(term.domainSpec, domain); err != nil {
+			return false, AuthResultPermError, err.Error()
+		}
+	}
+
+	switch term.mechanism {
+	case "include":
+		result, _, reason := c.checkHost(target, false)
+		switch result {
+		case AuthResultPass:
+			return true, "", ""
+		case AuthResultFail, AuthResultSoftFail, AuthResultNeutral:
+			return false, "", ""
+		case AuthResultTempError:
+			return false, AuthResultTempError, reason
+		}
+		return false, AuthResultPermError, "include of " + target + ": " + reason
+
+	case "a":
+		ips, result, reason := c.lookupIP(target)
+		return c.containsIP(ips, term.cidr4, term.cidr6), result, reason
+
+	case "mx":
+		mxs, err := c.resolver.LookupMX(target)
+		if err != nil && !isNotFound(err) {
+			return false, AuthResultTempError, "MX lookup failed: " + err.Error()
+		}
+		if len(mxs) == 0 {
+			result, reason := c.voidLookup()
+			return false, result, reason
+		}
+		if len(mxs) > spfMaxNames {
+			return false, AuthResultPermError, "too many MX records for " + target
+		}
+		for _, mx := range mxs {
+			ips, result, reason := c.lookupIP(strings.TrimSuffix(mx.Host, "."))
+			if len(result) > 0 {
+				return false, result, reason
+			}
+			if c.containsIP(ips, term.cidr4, term.cidr6) {
+				return true, "", ""
+			}
+		}
+		return false, "", ""
+
+	case "ptr":
+		for _, name := range c.validatedNames() {
+			if strings.EqualFold(name, target) || strings.HasSuffix(strings.ToLower(name), "."+strings.ToLower(target)) {
+				return true, "", ""
+			}
+		}
+		return false, "", ""
+
+	case "exists":
+		ips, result, reason := c.lookupIP(target)
+		for _, ip := range ips {
+			if ip.To4() != nil {
+				return true, "", ""
+			}
+		}
+		return false, result, reason
+	}
+	return false, AuthResultPermError, "unknown mechanism " + term.mechanism
+}
+
+// lookupIP looks up the addresses of a host, returning an error result and reason
+// if DNS is unavailable, or if there have been too many lookups that found nothing.
+func (c *spfCheck) lookupIP(host string) ([]net.IP, AuthResult, string) {
+	ips, err := c.resolver.LookupIP(host)
+	if err != nil && !isNotFound(err) {
+		return nil, AuthResultTempError, "address lookup failed: " + err.Error()
+	}
+	if len(ips) == 0 {
+		result, reason := c.voidLookup()
+		return nil, result, reason
+	}
+	return ips, "", ""
+}
+
+// voidLookup counts a lookup that found nothing, returning permerror if there have been too many.
+func (c *spfCheck) voidLookup() (AuthResult, string) {
+	if c.voidLookups++; c.voidLookups > spfMaxVoidLookups {
+		return AuthResultPermError, "too many DNS lookups found nothing"
+	}
+	return "", ""
+}
+
+// containsIP returns true if the client is within the network of any of the addresses,
+// using the prefix length of the client's address family.
+func (c *spfCheck) containsIP(ips []net.IP, cidr4 int, cidr6 int) bool {
+	for _, ip := range ips {
+		network := &net.IPNet{IP: ip.To4(), Mask: net.CIDRMask(cidr4, 32)}
+		if network.IP == nil {
+			network = &net.IPNet{IP: ip, Mask: net.CIDRMask(cidr6, 128)}
+		}
+		if (network.IP.To4() == nil) == (c.ip.To4() == nil) && network.Contains(c.ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// validatedNames returns the host names of the client's address
+// whose own addresses include the client's address.
+func (c *spfCheck) validatedNames() []string {
+	names, err := c.resolver.LookupAddr(c.ip.String())
+	if err != nil {
+		return nil
+	}
+	var validated []string
+	for idx, name := range names {
+		if idx >= spfMaxNames {
+			break
+		}
+		name = strings.TrimSuffix(name, ".")
+		ips, err := c.resolver.LookupIP(name)
+		if err != nil {
+			continue
+		}
+		for _, ip := range ips {
+			if ip.Equal(c.ip) {
+				validated = append(validated, name)
+				break
+			}
+		}
+	}
+	return validated
+}
+
+// explain sets the explanation of a fail result from the TXT record of the exp= domain.
+// Any errors are ignored, leaving the explanation empty.
+func (c *spfCheck) explain(exp string, domain string) {
+	target, err := c.expand(exp, domain)
+	if err != nil {
+		return
+	}
+	records, err := c.resolver.LookupTXT(target)
+	if err != nil || len(records) != 1 {
+		return
+	}
+	if explanation, err := expandSPFMacros(records[0], true, func(letter byte) string {
+		return c.macroValue(letter, domain)
+	}); err == nil {
+		c.explanation = explanation
+	}
+}
+
+// expand expands the macros of a domain-spec, removing labels from the left
+// if it is too long, as RFC 7208 requires.
+func (c *spfCheck) expand(domainSpec string, domain string) (string, error) {
+	expanded, err := expandSPFMacros(domainSpec, false, func(letter byte) string {
+		return c.macroValue(letter, domain)
+	})
+	if err != nil {
+		return "", err
+	}
+	expanded = strings.TrimSuffix(expanded, ".")
+	for len(expanded) > spfMaxDomainLength {
+		idx := strings.IndexByte(expanded, '.')
+		if idx < 0 {
+			break
+		}
+		expanded = expanded[idx+1:]
+	}
+	return expanded, nil
+}
+
+// macroValue returns the value of a macro letter, before any transformation.
+func (c *spfCheck) macroValue(letter byte, domain string) string {
+	switch letter {
+	case 's':
+		return c.sender
+	case 'l':
+		return c.local
+	case 'o':
+		return c.senderDomain
+	case 'd':
+		return domain
+	case 'i':
+		if c.ip.To4() != nil {
+			return c.ip.To4().String()
+		}
+		nibbles := make([]string, 0, 32)
+		for _, b := range c.ip.To16() {
+			nibbles = append(nibbles, strconv.FormatInt(int64(b>>4), 16), strconv.FormatInt(int64(b&0xf), 16))
+		}
+		return strings.Join(nibbles, ".")
+	case 'p':
+		names := c.validatedNames()
+		for _, name := range names {
+			if strings.EqualFold(name, domain) || strings.HasSuffix(strings.ToLower(name), "."+strings.ToLower(domain)) {
+				return name
+			}
+		}
+		if len(names) > 0 {
+			return names[0]
+		}
+		return "unknown"
+	case 'v':
+		if c.ip.To4() != nil {
+			return "in-addr"
+		}
+		return "ip6"
+	case 'h':
+		return c.helo
+	case 'c':
+		return c.ip.String()
+	case 'r':
+		return c.hostname
+	case 't':
+		return strconv.FormatInt(c.now.Unix(), 10)
+	}
+	return ""
+}
+
+// parseSPFRecord parses the mechanisms and modifiers of an SPF record, such as
+// "v=spf1 ip4:192.0.2.0/24 include:_spf.example.com -all".
+// Unknown modifiers are ignored, but any other syntax error is returned.
+func parseSPFRecord(record string) ([]*spfTerm, string, string, error) {
+	var terms []*spfTerm
+	var redirect, exp string
+	var hasRedirect, hasExp bool
+	for _, raw := range strings.Fields(record)[1:] {
+		if idx := strings.IndexByte(raw, '='); idx > 0 && isSPFName(raw[:idx]) {
+			name, value := strings.ToLower(raw[:idx]), raw[idx+1:]
+			if err := checkSPFMacros(value); err != nil {
+				return nil, "", "", err
+			}
+			switch name {
+			case "redirect":
+				if hasRedirect || len(value) == 0 {
+					return nil, "", "", errors.New("invalid redirect modifier: " + raw)
+				}
+				redirect, hasRedirect = value, true
+			case "exp":
+				if hasExp || len(value) == 0 {
+					return nil, "", "", errors.New("invalid exp modifier: " + raw)
+				}
+				exp, hasExp = value, true
+			}
+			continue
+		}
+		term, err := parseSPFTerm(raw)
+		if err != nil {
+			return nil, "", "", err
+		}
+		terms = append(terms, term)
+	}
+	return terms, redirect, exp, nil
+}
+
+// parseSPFTerm parses a single mechanism, with its optional qualifier.
+func parseSPFTerm(raw string) (*spfTerm, error) {
+	term := &spfTerm{raw: raw, qualifier: AuthResultPass, cidr4: 32, cidr6: 128}
+	mechanism := raw
+	switch raw[0] {
+	case '+':
+		mechanism = raw[1:]
+	case '-':
+		term.qualifier, mechanism = AuthResultFail, raw[1:]
+	case '~':
+		term.qualifier, mechanism = AuthResultSoftFail, raw[1:]
+	case '?':
+		term.qualifier, mechanism = AuthResultNeutral, raw[1:]
+	}
+	var arg string
+	if idx := strings.IndexAny(mechanism, ":/"); idx >= 0 {
+		mechanism, arg = mechanism[:idx], mechanism[idx:]
+	}
+	term.mechanism = strings.ToLower(mechanism)
+	invalid := errors.New("invalid SPF mechanism: " + raw)
+
+	switch term.mechanism {
+	case "all":
+		if len(arg) > 0 {
+			return nil, invalid
+		}
+	case "include", "exists":
+		if len(arg) < 2 || arg[0] != ':' {
+			return nil, invalid
+		}
+		term.domainSpec = arg[1:]
+	case "a", "mx", "ptr":
+		if term.mechanism != "ptr" {
+			// Dual CIDR lengths, such as "a:example.com/24//64"
+			if idx := strings.LastIndex(arg, "//"); idx >= 0 && isDigits(arg[idx+2:]) {
+				term.cidr6, _ = strconv.Atoi(arg[idx+2:])
+				arg = arg[:idx]
+			}
+			if idx := strings.LastIndexByte(arg, '/'); idx >= 0 && isDigits(arg[idx+1:]) {
+				term.cidr4, _ = strconv.Atoi(arg[idx+1:])
+				arg = arg[:idx]
+			}
+			if term.cidr4 > 32 || term.cidr6 > 128 {
+				return nil, invalid
+			}
+		}
+		if len(arg) > 0 {
+			if len(arg) < 2 || arg[0] != ':' {
+				return nil, invalid
+			}
+			term.domainSpec = arg[1:]
+		}
+	case "ip4", "ip6":
+		if len(arg) < 2 || arg[0] != ':' {
+			return nil, invalid
+		}
+		address, bits := arg[1:], 32
+		if term.mechanism == "ip6" {
+			bits = 128
+		}
+		prefix := bits
+		if idx := strings.IndexByte(address, '/'); idx >= 0 {
+			var err error
+			if prefix, err = strconv.Atoi(address[idx+1:]); err != nil || !isDigits(address[idx+1:]) || prefix > bits {
+				return nil, invalid
+			}
+			address = address[:idx]
+		}
+		ip := net.ParseIP(address)
+		if ip == nil || (term.mechanism == "ip4") != (ip.To4() != nil && !strings.Contains(address, ":")) {
+			return nil, invalid
+		}
+		if term.mechanism == "ip4" {
+			ip = ip.To4()
+		}
+		term.network = &net.IPNet{IP: ip.Mask(net.CIDRMask(prefix, bits)), Mask: net.CIDRMask(prefix, bits)}
+		return term, nil
+	default:
+		return nil, errors.New("unknown SPF mechanism: " + raw)
+	}
+	if err := checkSPFMacros(term.domainSpec); err != nil {
+		return nil, err
+	}
+	return term, nil
+}
+
+// checkSPFMacros returns an error if the macros of a domain-spec are malformed.
+func checkSPFMacros(domainSpec string) error {
+	_, err := expandSPFMacros(domainSpec, false, func(byte) string { return "" })
+	return err
+}
+
+// expandSPFMacros expands the macros of a domain-spec, or of an explanation, such as
+// "%{ir}.%{v}._spf.%{d2}", where value returns the value of each lower case macro letter.
+func expandSPFMacros(spec string, explanation bool, value func(letter byte) string) (string, error) {
+	b := &strings.Builder{}
+	for idx := 0; idx < len(spec); idx++ {
+		if spec[idx] != '%' {
+			b.WriteByte(spec[idx])
+			continue
+		}
+		if idx++; idx >= len(spec) {
+			return "", errors.New("invalid SPF macro: " + spec)
+		}
+		switch spec[idx] {
+		case '%':
+			b.WriteByte('%')
+		case '_':
+			b.WriteByte(' ')
+		case '-':
+			b.WriteString("%20")
+		case '{':
+			end := strings.IndexByte(spec[idx:], '}')
+			if end < 0 {
+				return "", errors.New("invalid SPF macro: " + spec)
+			}
+			expanded, err := expandSPFMacro(spec[idx+1:idx+end], explanation, value)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(expanded)
+			idx += end
+		default:
+			return "", errors.New("invalid SPF macro: " + spec)
+		}
+	}
+	return b.String(), nil
+}
+
+// expandSPFMacro expands a single macro, without its braces, such as "d2" or "ir".
+func expandSPFMacro(macro string, explanation bool, value func(letter byte) string) (string, error) {
+	invalid := errors.New("invalid SPF macro: %{" + macro + "}")
+	if len(macro) == 0 {
+		return "", invalid
+	}
+	letter := macro[0] | 0x20 // Lower case
+	if strings.IndexByte("slodiphv", letter) < 0 && !(explanation && strings.IndexByte("crt", letter) >= 0) {
+		return "", invalid
+	}
+
+	// Transformers: the number of labels to keep from the right, then whether to reverse them
+	rest := macro[1:]
+	digits := 0
+	for digits < len(rest) && rest[digits] >= '0' && rest[digits] <= '9' {
+		digits++
+	}
+	keep := 0
+	if digits > 0 {
+		keep, _ = strconv.Atoi(rest[:digits])
+		if keep == 0 {
+			return "", invalid
+		}
+	}
+	rest = rest[digits:]
+	reverse := len(rest) > 0 && (rest[0] == 'r' || rest[0] == 'R')
+	if reverse {
+		rest = rest[1:]
+	}
+	delimiters := rest
+	for idx := 0; idx < len(delimiters); idx++ {
+		if strings.IndexByte(".-+,/_=", delimiters[idx]) < 0 {
+			return "", invalid
+		}
+	}
+	if len(delimiters) == 0 {
+		delimiters = "."
+	}
+
+	parts := strings.FieldsFunc(value(letter), func(r rune) bool { return strings.ContainsRune(delimiters, r) })
+	if reverse {
+		for left, right := 0, len(parts)-1; left < right; left, right = left+1, right-1 {
+			parts[left], parts[right] = parts[right], parts[left]
+		}
+	}
+	if keep > 0 && keep < len(parts) {
+		parts = parts[len(parts)-keep:]
+	}
+	expanded := strings.Join(parts, ".")
+
+	if macro[0] != letter {
+		// Upper case macros are URL escaped
+		escaped := &strings.Builder{}
+		for idx := 0; idx < len(expanded); idx++ {
+			c := expanded[idx]
+			if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte("-._~", c) >= 0 {
+				escaped.WriteByte(c)
+			} else {
+				fmt.Fprintf(escaped, "%%%02X", c)
+			}
+		}
+		expanded = escaped.String()
+	}
+	return expanded, nil
+}
+
+// isSPFName returns true if the name of a modifier is valid: a letter followed by
+// letters, digits, "-", "_", or ".".
+func isSPFName(name string) bool {
+	for idx := 0; idx < len(name); idx++ {
+		c := name[idx] | 0x20
+		if !(c >= 'a' && c <= 'z' || idx > 0 && (name[idx] >= '0' && name[idx] <= '9' || strings.IndexByte("-_.", name[idx]) >= 0)) {
+			return false
+		}
+	}
+	return len(name) > 0
+}
+
+// validSPFDomain returns true if the domain has at least two labels, none of which are empty or too long.
+func validSPFDomain(domain string) bool {
+	if len(domain) == 0 || len(domain) > spfMaxDomainLength || !strings.Contains(domain, ".") {
+		return false
+	}
+	for _, label := range strings.Split(domain, ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return false
+		}
+	}
+	return true
+}
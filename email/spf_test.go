@@ -0,0 +1,217 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package email
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// memoryZone is a Resolver with fixed records, for tests.
+// Names containing "unavailable" time out, and any other name does not exist.
+type memoryZone struct {
+	txt map[string][]string
+	ip  map[string][]net.IP
+	mx  map[string][]*net.MX
+	ptr map[string][]string
+}
+
+func (z *memoryZone) err(name string) error {
+	if strings.Contains(name, "unavailable") {
+		return &net.DNSError{Err: "timeout", Name: name, IsTimeout: true}
+	}
+	return &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func (z *memoryZone) LookupTXT(name string) ([]string, error) {
+	if records, ok := z.txt[strings.ToLower(name)]; ok {
+		return records, nil
+	}
+	return nil, z.err(name)
+}
+
+func (z *memoryZone) LookupIP(host string) ([]net.IP, error) {
+	if ips, ok := z.ip[strings.ToLower(host)]; ok {
+		return ips, nil
+	}
+	return nil, z.err(host)
+}
+
+func (z *memoryZone) LookupMX(name string) ([]*net.MX, error) {
+	if mxs, ok := z.mx[strings.ToLower(name)]; ok {
+		return mxs, nil
+	}
+	return nil, z.err(name)
+}
+
+func (z *memoryZone) LookupAddr(addr string) ([]string, error) {
+	if names, ok := z.ptr[addr]; ok {
+		return names, nil
+	}
+	return nil, z.err(addr)
+}
+
+// TestSPFVerify ...
+func TestSPFVerify(t *testing.T) {
+	t.Parallel()
+
+	zone := &memoryZone{
+		txt: map[string][]string{
+			"example.com":              {"google-site-verification=abc", "v=spf1 ip4:192.0.2.0/24 ip6:2001:db8::/32 a:mail.example.com/30 mx include:_spf.example.net exists:%{ir}.%{l1r+-}._spf.%{d} ptr -all exp=explain.%{d}"},
+			"explain.example.com":      {"%{i} is not one of %{d}'s designated mail servers (%{c} at %{t})"},
+			"_spf.example.net":         {"v=spf1 ip4:203.0.113.0/24 ~all"},
+			"soft.example.com":         {"v=spf1 ~all"},
+			"neutral.example.com":      {"v=spf1 ?all"},
+			"empty.example.com":        {"v=spf1"},
+			"redirect.example.com":     {"v=spf1 redirect=example.com"},
+			"redirect-none.example":    {"v=spf1 redirect=nothing.example"},
+			"two.example.com":          {"v=spf1 -all", "v=spf1 +all"},
+			"invalid.example.com":      {"v=spf1 ip4:999.0.0.1 -all"},
+			"unknown.example.com":      {"v=spf1 foo:bar -all"},
+			"badmacro.example.com":     {"v=spf1 exists:%{x}.example.com -all"},
+			"loop.example.com":         {"v=spf1 include:loop.example.com -all"},
+			"void.example.com":         {"v=spf1 a:a.nothing.example a:b.nothing.example a:c.nothing.example -all"},
+			"temp.example.com":         {"v=spf1 include:unavailable.example.com -all"},
+			"ipv4mapped.example.com":   {"v=spf1 ip4:198.51.100.7 -all"},
+			"uppercase.example.com":    {"V=SPF1 +IP4:198.51.100.0/24 -ALL"},
+			"unknownmod.example.com":   {"v=spf1 foo=bar ip4:198.51.100.0/24 -all"},
+			"mxonly.example.com":       {"v=spf1 mx/24 -all"},
+			"postmaster.example.org":   {"v=spf1 ip4:192.0.2.99 -all"},
+			"helo.example.org":         {"v=spf1 ip4:192.0.2.99 -all"},
+			"macro-exists.example.com": {"v=spf1 exists:%{l}.%{o}.users.example.com -all"},
+		},
+		ip: map[string][]net.IP{
+			"mail.example.com":                               {net.ParseIP("198.51.100.4")},
+			"mx1.example.com":                                {net.ParseIP("198.51.100.20"), net.ParseIP("2001:db9::20")},
+			"77.100.51.198.alice._spf.example.com":           {net.ParseIP("127.0.0.2")},
+			"host.example.com":                               {net.ParseIP("198.51.100.99")},
+			"forged.example.net":                             {net.ParseIP("198.51.100.98")},
+			"mxonly-mx.example.com":                          {net.ParseIP("203.0.113.200")},
+			"bob.macro-exists.example.com.users.example.com": {net.ParseIP("127.0.0.2")},
+		},
+		mx: map[string][]*net.MX{
+			"example.com":        {{Host: "mx1.example.com.", Pref: 10}},
+			"mxonly.example.com": {{Host: "mxonly-mx.example.com.", Pref: 10}},
+		},
+		ptr: map[string][]string{
+			"198.51.100.99": {"host.example.com."},
+			"198.51.100.98": {"forged.example.net.", "host.example.com."},
+		},
+	}
+	verifier := &SPFVerifier{Resolver: zone, Hostname: "mx.example.org", Now: func() time.Time { return time.Unix(1500000000, 0) }}
+
+	testCases := []struct {
+		ip        string
+		helo      string
+		mailFrom  string
+		result    AuthResult
+		mechanism string
+	}{
+		{"192.0.2.1", "mail.example.com", "<user@example.com>", AuthResultPass, "ip4:192.0.2.0/24"},
+		{"2001:db8::1", "mail.example.com", "user@example.com", AuthResultPass, "ip6:2001:db8::/32"},
+		{"198.51.100.6", "mail.example.com", "user@example.com", AuthResultPass, "a:mail.example.com/30"},
+		{"198.51.100.20", "mail.example.com", "user@example.com", AuthResultPass, "mx"},
+		{"2001:db9::20", "mail.example.com", "user@example.com", AuthResultPass, "mx"},
+		{"203.0.113.5", "mail.example.com", "user@example.com", AuthResultPass, "include:_spf.example.net"},
+		{"198.51.100.77", "mail.example.com", "alice-me@example.com", AuthResultPass, "exists:%{ir}.%{l1r+-}._spf.%{d}"},
+		{"198.51.100.99", "mail.example.com", "user@example.com", AuthResultPass, "ptr"},
+		{"198.51.100.98", "mail.example.com", "user@example.com", AuthResultFail, "-all"},
+		{"198.51.100.8", "mail.example.com", "user@example.com", AuthResultFail, "-all"},
+		{"192.0.2.1", "mail.example.com", "user@redirect.example.com", AuthResultPass, "ip4:192.0.2.0/24"},
+		{"192.0.2.1", "mail.example.com", "user@soft.example.com", AuthResultSoftFail, "~all"},
+		{"192.0.2.1", "mail.example.com", "user@neutral.example.com", AuthResultNeutral, "?all"},
+		{"192.0.2.1", "mail.example.com", "user@empty.example.com", AuthResultNeutral, ""},
+		{"192.0.2.1", "mail.example.com", "user@nothing.example.com", AuthResultNone, ""},
+		{"192.0.2.1", "mail.example.com", "user@localhost", AuthResultNone, ""},
+		{"192.0.2.1", "mail.example.com", "user@redirect-none.example", AuthResultPermError, "redirect=nothing.example"},
+		{"192.0.2.1", "mail.example.com", "user@two.example.com", AuthResultPermError, ""},
+		{"192.0.2.1", "mail.example.com", "user@invalid.example.com", AuthResultPermError, ""},
+		{"192.0.2.1", "mail.example.com", "user@unknown.example.com", AuthResultPermError, ""},
+		{"192.0.2.1", "mail.example.com", "user@badmacro.example.com", AuthResultPermError, ""},
+		{"192.0.2.1", "mail.example.com", "user@loop.example.com", AuthResultPermError, "include:loop.example.com"},
+		{"192.0.2.1", "mail.example.com", "user@void.example.com", AuthResultPermError, "a:c.nothing.example"},
+		{"192.0.2.1", "mail.example.com", "user@temp.example.com", AuthResultTempError, "include:unavailable.example.com"},
+		{"192.0.2.1", "mail.example.com", "user@unavailable.example.com", AuthResultTempError, ""},
+		{"::ffff:198.51.100.7", "mail.example.com", "user@ipv4mapped.example.com", AuthResultPass, "ip4:198.51.100.7"},
+		{"198.51.100.7", "mail.example.com", "user@uppercase.example.com", AuthResultPass, "+IP4:198.51.100.0/24"},
+		{"198.51.100.7", "mail.example.com", "user@unknownmod.example.com", AuthResultPass, "ip4:198.51.100.0/24"},
+		{"203.0.113.7", "mail.example.com", "user@mxonly.example.com", AuthResultPass, "mx/24"},
+		{"192.0.2.99", "helo.example.org", "", AuthResultPass, "ip4:192.0.2.99"},
+		{"192.0.2.99", "mail.example.com", "@postmaster.example.org", AuthResultPass, "ip4:192.0.2.99"},
+		{"192.0.2.1", "mail.example.com", "bob@macro-exists.example.com", AuthResultPass, "exists:%{l}.%{o}.users.example.com"},
+		{"192.0.2.1", "mail.example.com", "bob@example.org", AuthResultNone, ""},
+	}
+
+	for idx, tc := range testCases {
+		verification := verifier.Verify(net.ParseIP(tc.ip), tc.helo, tc.mailFrom)
+		if verification.Result != tc.result || verification.Mechanism != tc.mechanism {
+			t.Errorf("Case %d: expected %s %q; got %s %q (%s)", idx, tc.result, tc.mechanism, verification.Result, verification.Mechanism, verification.Reason)
+		}
+	}
+
+	verification := verifier.Verify(net.ParseIP("198.51.100.8"), "mail.example.com", "user@example.com")
+	expected := "198.51.100.8 is not one of example.com's designated mail servers (198.51.100.8 at 1500000000)"
+	if verification.Explanation != expected {
+		t.Errorf("Expected explanation %q; got %q", expected, verification.Explanation)
+	}
+	if result := verification.AuthenticationResult().String(); result != "spf=fail smtp.mailfrom=user@example.com" {
+		t.Errorf("Unexpected Authentication-Results: %s", result)
+	}
+	verification = verifier.Verify(net.ParseIP("192.0.2.99"), "helo.example.org", "<>")
+	if result := verification.AuthenticationResult().String(); result != "spf=pass smtp.helo=helo.example.org" || verification.Sender != "postmaster@helo.example.org" {
+		t.Errorf("Unexpected Authentication-Results: %s", result)
+	}
+}
+
+// TestExpandSPFMacros checks the examples from RFC 7208, section 7.4.
+func TestExpandSPFMacros(t *testing.T) {
+	t.Parallel()
+
+	c := &spfCheck{ip: net.ParseIP("192.0.2.3").To4(), sender: "strong-bad@email.example.com", local: "strong-bad",
+		senderDomain: "email.example.com", helo: "mx.example.org"}
+	c6 := &spfCheck{ip: net.ParseIP("2001:db8::cb01"), sender: c.sender, local: c.local, senderDomain: c.senderDomain}
+
+	testCases := []struct {
+		check    *spfCheck
+		macro    string
+		expected string
+	}{
+		{c, "%{s}", "strong-bad@email.example.com"},
+		{c, "%{o}", "email.example.com"},
+		{c, "%{d}", "email.example.com"},
+		{c, "%{d4}", "email.example.com"},
+		{c, "%{d3}", "email.example.com"},
+		{c, "%{d2}", "example.com"},
+		{c, "%{d1}", "com"},
+		{c, "%{dr}", "com.example.email"},
+		{c, "%{d2r}", "example.email"},
+		{c, "%{l}", "strong-bad"},
+		{c, "%{l-}", "strong.bad"},
+		{c, "%{lr}", "strong-bad"},
+		{c, "%{lr-}", "bad.strong"},
+		{c, "%{l1r-}", "strong"},
+		{c, "%{ir}.%{v}._spf.%{d2}", "3.2.0.192.in-addr._spf.example.com"},
+		{c, "%{lr-}.lp._spf.%{d2}", "bad.strong.lp._spf.example.com"},
+		{c, "%{lr-}.lp.%{ir}.%{v}._spf.%{d2}", "bad.strong.lp.3.2.0.192.in-addr._spf.example.com"},
+		{c, "%{ir}.%{v}.%{l1r-}.lp._spf.%{d2}", "3.2.0.192.in-addr.strong.lp._spf.example.com"},
+		{c, "%{d2}.trusted-domains.example.net", "example.com.trusted-domains.example.net"},
+		{c, "%{S}%%%_%-", "strong-bad%40email.example.com% %20"},
+		{c6, "%{ir}.%{v}._spf.%{d2}", "1.0.b.c.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6._spf.example.com"},
+	}
+	for idx, tc := range testCases {
+		expanded, err := tc.check.expand(tc.macro, "email.example.com")
+		if err != nil || expanded != tc.expected {
+			t.Errorf("Case %d: expected %q; got %q %v", idx, tc.expected, expanded, err)
+		}
+	}
+
+	for _, macro := range []string{"%", "%{", "%{x}", "%{d0}", "%{c}", "%a", "%{d2q}"} {
+		if _, err := c.expand(macro, "example.com"); err == nil {
+			t.Errorf("Expected an error expanding %q", macro)
+		}
+	}
+}
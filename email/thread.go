@@ -0,0 +1,328 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package email
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// Thread is a node in a conversation tree of messages, as built by ThreadMessages.
+type Thread struct {
+	// Message is the message of this node, or nil if this node is a placeholder:
+	// either for a message that is referenced by others but is missing from the set,
+	// or grouping messages with the same subject that do not reference each other.
+	Message *Message
+
+	// MessageID is the Message-Id of the message, without angle brackets,
+	// which may be empty for messages without one, and for placeholders grouping by subject.
+	MessageID string
+
+	// Parent is the node that this one replies to, or nil for the root of a conversation.
+	Parent *Thread
+
+	// Children are the replies to this node, ordered by Date.
+	Children []*Thread
+
+	// index is the position of the message in the set being threaded,
+	// and date is its Date, which are used to order siblings
+	index int
+	date  time.Time
+}
+
+// ThreadMessages groups messages into conversations, using the threading algorithm
+// by Jamie Zawinski (https://www.jwz.org/doc/threading.html), returning the root of each conversation.
+// Messages are linked by their Message-Id, In-Reply-To and References header fields,
+// with placeholders for any referenced messages that are missing from the set.
+// Conversations that do not reference each other, but have the same subject (ignoring any Re: or Fwd: prefixes),
+// are then grouped together. Siblings, including the roots, are ordered by Date,
+// with placeholders ordered by their earliest reply, and messages with the same Date kept in their original order.
+func ThreadMessages(messages []*Message) []*Thread {
+	ids := map[string]*Thread{}
+	var containers []*Thread
+	container := func(id string) *Thread {
+		if t, ok := ids[id]; ok {
+			return t
+		}
+		t := &Thread{MessageID: id}
+		ids[id] = t
+		containers = append(containers, t)
+		return t
+	}
+
+	for idx, m := range messages {
+		var t *Thread
+		if id := firstMessageID(m.Header.Get("Message-Id")); len(id) > 0 && (ids[id] == nil || ids[id].Message == nil) {
+			t = container(id)
+		} else {
+			// Messages without a Message-Id, or with one already used by another message, are threaded on their own
+			t = &Thread{MessageID: id}
+			containers = append(containers, t)
+		}
+		t.Message, t.index = m, idx
+		t.date, _ = m.Header.Date()
+
+		// Link each reference to the next, unless they are already linked, or it would make a loop
+		var parent *Thread
+		for _, reference := range threadReferences(m.Header) {
+			referenced := container(reference)
+			if parent != nil && referenced.Parent == nil && !referenced.encloses(parent) {
+				parent.adopt(referenced)
+			}
+			parent = referenced
+		}
+
+		// The message's own references determine its parent, replacing any guessed from other messages
+		if parent != nil && t.encloses(parent) {
+			parent = nil
+		}
+		if t.Parent != nil {
+			t.Parent.disown(t)
+		}
+		if parent != nil {
+			parent.adopt(t)
+		}
+	}
+
+	var roots []*Thread
+	for _, t := range containers {
+		if t.Parent == nil {
+			roots = append(roots, t)
+		}
+	}
+	roots = groupThreadsBySubject(pruneThreads(roots, true))
+	sortThreads(roots)
+	return roots
+}
+
+// Messages returns the messages of this thread and all of its replies, depth first, skipping any placeholders.
+func (t *Thread) Messages() []*Message {
+	var messages []*Message
+	if t.Message != nil {
+		messages = append(messages, t.Message)
+	}
+	for _, child := range t.Children {
+		messages = append(messages, child.Messages()...)
+	}
+	return messages
+}
+
+// adopt makes the thread a child of this one.
+func (t *Thread) adopt(child *Thread) {
+	child.Parent = t
+	t.Children = append(t.Children, child)
+}
+
+// disown removes the child from this thread.
+func (t *Thread) disown(child *Thread) {
+	for idx, c := range t.Children {
+		if c == child {
+			t.Children = append(t.Children[:idx], t.Children[idx+1:]...)
+			break
+		}
+	}
+	child.Parent = nil
+}
+
+// encloses returns true if the other thread is this one, or is one of its descendants.
+func (t *Thread) encloses(other *Thread) bool {
+	for ; other != nil; other = other.Parent {
+		if other == t {
+			return true
+		}
+	}
+	return false
+}
+
+// subject returns the normalized subject of this thread's message, or of its first child for placeholders,
+// and whether it was a reply or forward.
+func (t *Thread) subject() (string, bool) {
+	if t.Message != nil {
+		return normalizeThreadSubject(t.Message.Header.Subject())
+	}
+	for _, child := range t.Children {
+		if subject, reply := child.subject(); len(subject) > 0 {
+			return subject, reply
+		}
+	}
+	return "", false
+}
+
+// pruneThreads removes placeholders without any children, and replaces placeholders with their children,
+// except at the root, where a placeholder is kept if it groups several children.
+func pruneThreads(threads []*Thread, root bool) []*Thread {
+	var pruned []*Thread
+	for _, t := range threads {
+		t.Children = pruneThreads(t.Children, false)
+		for _, child := range t.Children {
+			child.Parent = t
+		}
+		if t.Message != nil || root && len(t.Children) > 1 {
+			pruned = append(pruned, t)
+		} else {
+			pruned = append(pruned, t.Children...)
+		}
+	}
+	if root {
+		for _, t := range pruned {
+			t.Parent = nil
+		}
+	}
+	return pruned
+}
+
+// groupThreadsBySubject groups the roots of conversations that have the same subject, returning the new roots.
+// A message is made a reply to one with the same subject if only it is a reply, and otherwise they are
+// grouped as siblings under a placeholder.
+func groupThreadsBySubject(roots []*Thread) []*Thread {
+	// Placeholders, and then messages that are not replies, are preferred to group under
+	subjects := map[string]*Thread{}
+	for _, t := range roots {
+		subject, reply := t.subject()
+		if len(subject) == 0 {
+			continue
+		}
+		if existing, ok := subjects[subject]; !ok || t.Message == nil && existing.Message != nil {
+			subjects[subject] = t
+		} else if _, existingReply := existing.subject(); existing.Message != nil && existingReply && !reply {
+			subjects[subject] = t
+		}
+	}
+
+	for _, t := range roots {
+		subject, reply := t.subject()
+		that := subjects[subject]
+		if len(subject) == 0 || that == t || t.Parent != nil {
+			continue
+		}
+		_, thatReply := that.subject()
+		switch {
+		case t.Message == nil && that.Message == nil:
+			for _, child := range t.Children {
+				that.adopt(child)
+			}
+			t.Children = nil
+		case that.Message == nil || !thatReply && reply:
+			that.adopt(t)
+		case t.Message == nil || thatReply && !reply:
+			t.adopt(that)
+			subjects[subject] = t
+		default:
+			placeholder := &Thread{}
+			placeholder.adopt(that)
+			placeholder.adopt(t)
+			subjects[subject] = placeholder
+		}
+	}
+
+	var grouped []*Thread
+	seen := map[*Thread]bool{}
+	for _, t := range roots {
+		for t.Parent != nil {
+			t = t.Parent
+		}
+		if !seen[t] && (t.Message != nil || len(t.Children) > 0) {
+			seen[t] = true
+			grouped = append(grouped, t)
+		}
+	}
+	return grouped
+}
+
+// sortThreads orders siblings by Date, and then by their position in the set being threaded.
+// Placeholders take the Date and position of their earliest child.
+func sortThreads(threads []*Thread) {
+	for _, t := range threads {
+		sortThreads(t.Children)
+		if t.Message == nil && len(t.Children) > 0 {
+			t.date, t.index = t.Children[0].date, t.Children[0].index
+		}
+	}
+	sort.SliceStable(threads, func(i, j int) bool {
+		if !threads[i].date.Equal(threads[j].date) {
+			return threads[i].date.Before(threads[j].date)
+		}
+		return threads[i].index < threads[j].index
+	})
+}
+
+// threadReferences returns the message IDs that a message replies to, from the oldest to its parent,
+// from its References, followed by its In-Reply-To if that is not already referenced.
+func threadReferences(h Header) []string {
+	references := messageIDs(h.Get("References"))
+	if inReplyTo := firstMessageID(h.Get("In-Reply-To")); len(inReplyTo) > 0 {
+		for _, reference := range references {
+			if reference == inReplyTo {
+				return references
+			}
+		}
+		references = append(references, inReplyTo)
+	}
+	return references
+}
+
+// firstMessageID returns the first message ID in a header field, without angle brackets.
+func firstMessageID(value string) string {
+	if ids := messageIDs(value); len(ids) > 0 {
+		return ids[0]
+	}
+	return ""
+}
+
+// messageIDs returns the message IDs in a Message-Id, In-Reply-To, or References header field, without angle brackets.
+// Any text between them, such as comments or phrases, is ignored, and values without any angle brackets
+// are split on whitespace.
+func messageIDs(value string) []string {
+	var ids []string
+	for rest := value; ; {
+		start := strings.IndexByte(rest, '<')
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(rest[start:], '>')
+		if end < 0 {
+			break
+		}
+		if id := strings.TrimSpace(rest[start+1 : start+end]); len(id) > 0 {
+			ids = append(ids, id)
+		}
+		rest = rest[start+end+1:]
+	}
+	if len(ids) == 0 && !strings.ContainsAny(value, "<>") {
+		return strings.Fields(value)
+	}
+	return ids
+}
+
+// normalizeThreadSubject returns the subject without any reply or forward prefixes (such as "Re:", "Re[2]:", or "Fwd:"),
+// lowercased and with its whitespace collapsed, and whether it had a prefix.
+func normalizeThreadSubject(subject string) (string, bool) {
+	subject = strings.TrimSpace(subject)
+	var reply bool
+	for {
+		lower := strings.ToLower(subject)
+		var prefix string
+		for _, p := range []string{"re", "fwd", "fw"} {
+			if strings.HasPrefix(lower, p) {
+				prefix = p
+				break
+			}
+		}
+		rest := subject[len(prefix):]
+		// Some clients count replies, such as Re[2]: or Re(2):
+		if len(prefix) > 0 && len(rest) > 0 && (rest[0] == '[' || rest[0] == '(') {
+			if end := strings.IndexAny(rest, "])"); end > 0 && isDigits(rest[1:end]) {
+				rest = rest[end+1:]
+			}
+		}
+		if len(prefix) == 0 || !strings.HasPrefix(strings.TrimLeft(rest, " "), ":") {
+			break
+		}
+		subject = strings.TrimSpace(strings.TrimLeft(rest, " ")[1:])
+		reply = true
+	}
+	return strings.ToLower(collapseWhitespace(subject)), reply
+}
@@ -0,0 +1,117 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package email
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// threadTestMessage returns a message with the Message-Id, Subject, Date (as a day of January 2020),
+// and References header fields.
+func threadTestMessage(id string, subject string, day int, references string) *Message {
+	m := &Message{Header: Header{}}
+	if len(id) > 0 {
+		m.Header.Set("Message-Id", "<"+id+"@example.com>")
+	}
+	m.Header.SetSubject(subject)
+	m.Header.Set("Date", fmt.Sprintf("%02d Jan 2020 10:00:00 +0000", day))
+	var ids []string
+	for _, reference := range strings.Fields(references) {
+		ids = append(ids, "<"+reference+"@example.com>")
+	}
+	if len(ids) > 0 {
+		m.Header.Set("References", strings.Join(ids, " "))
+	}
+	return m
+}
+
+// threadTestString returns the trees of the threads, as each Message-Id (without the domain) or
+// "_" for placeholders, followed by any children in parentheses.
+func threadTestString(threads []*Thread) string {
+	var trees []string
+	for _, t := range threads {
+		name := strings.TrimSuffix(t.MessageID, "@example.com")
+		if t.Message == nil {
+			name = "_"
+		} else if len(name) == 0 {
+			name = "?"
+		}
+		if len(t.Children) > 0 {
+			for _, child := range t.Children {
+				if child.Parent != t {
+					return "broken parent of " + child.MessageID
+				}
+			}
+			name += "(" + threadTestString(t.Children) + ")"
+		}
+		trees = append(trees, name)
+	}
+	return strings.Join(trees, " ")
+}
+
+// TestThreadMessages ...
+func TestThreadMessages(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		messages []*Message
+		expected string
+	}{
+		{"replies", []*Message{
+			threadTestMessage("c", "Re: Dinner", 3, "a b"),
+			threadTestMessage("a", "Dinner", 1, ""),
+			threadTestMessage("b", "Re: Dinner", 2, "a"),
+			threadTestMessage("b2", "Re: Dinner", 2, "a"),
+			threadTestMessage("z", "Other", 0, ""),
+		}, "z a(b(c) b2)"},
+		{"missing parents", []*Message{
+			threadTestMessage("e", "Re: Lost", 5, "x"),
+			threadTestMessage("d", "Re: Lost", 4, "x"),
+			threadTestMessage("f", "Re: Re: Lost", 6, "x d"),
+			threadTestMessage("g", "Re: Gone", 1, "y"),
+		}, "g _(d(f) e)"},
+		{"subjects", []*Message{
+			threadTestMessage("r", "RE: Lunch?", 2, ""),
+			threadTestMessage("l", "Lunch?", 1, ""),
+			threadTestMessage("l2", "lunch? ", 3, ""),
+			threadTestMessage("f", "Fwd: Re[2]: Lunch?", 4, ""),
+			threadTestMessage("n", "", 5, ""),
+			threadTestMessage("n2", "", 6, ""),
+		}, "_(l(r) l2 f) n n2"},
+		{"placeholders grouped by subject", []*Message{
+			threadTestMessage("p", "Re: Report", 2, "missing1"),
+			threadTestMessage("q", "Re: Report", 3, "missing2"),
+			threadTestMessage("s", "Re: Report", 1, "missing2"),
+		}, "_(s p q)"},
+		{"loops and duplicates", []*Message{
+			threadTestMessage("a", "One", 1, "b"),
+			threadTestMessage("b", "Two", 2, "a"),
+			threadTestMessage("a", "Three", 3, ""),
+			threadTestMessage("", "Four", 4, "a"),
+			threadTestMessage("c", "Five", 5, "c"),
+		}, "b(a(?)) a c"},
+	}
+	for _, tc := range testCases {
+		threads := ThreadMessages(tc.messages)
+		if result := threadTestString(threads); result != tc.expected {
+			t.Errorf("Case %s: expected %s; got %s", tc.name, tc.expected, result)
+		}
+		// Every message is in exactly one thread
+		var count int
+		for _, thread := range threads {
+			count += len(thread.Messages())
+		}
+		if count != len(tc.messages) {
+			t.Errorf("Case %s: expected %d messages; got %d", tc.name, len(tc.messages), count)
+		}
+	}
+
+	if ids := messageIDs(`<a@example.com> (comment) "Joe" <b@example.com>`); len(ids) != 2 || ids[1] != "b@example.com" {
+		t.Errorf("Unexpected message IDs: %q", ids)
+	}
+}
@@ -0,0 +1,569 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package email
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"mime"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+const (
+	// tnefSignature is the first four bytes of every TNEF stream.
+	tnefSignature = 0x223E9F78
+
+	// TNEF attribute levels
+	tnefLevelMessage    = 0x01
+	tnefLevelAttachment = 0x02
+
+	// TNEF attributes (with their types in the high word)
+	tnefAttrSubject        = 0x00018004
+	tnefAttrMessageID      = 0x00018009
+	tnefAttrBody           = 0x0002800C
+	tnefAttrDateSent       = 0x00038005
+	tnefAttrMessageClass   = 0x00078008
+	tnefAttrAttachData     = 0x0006800F
+	tnefAttrAttachTitle    = 0x00018010
+	tnefAttrAttachRendData = 0x00069002
+	tnefAttrMAPIProps      = 0x00069003
+	tnefAttrAttachment     = 0x00069005
+
+	// MAPI property types
+	mapiTypeShort    = 0x0002
+	mapiTypeLong     = 0x0003
+	mapiTypeFloat    = 0x0004
+	mapiTypeDouble   = 0x0005
+	mapiTypeCurrency = 0x0006
+	mapiTypeAppTime  = 0x0007
+	mapiTypeError    = 0x000A
+	mapiTypeBoolean  = 0x000B
+	mapiTypeObject   = 0x000D
+	mapiTypeInt64    = 0x0014
+	mapiTypeString8  = 0x001E
+	mapiTypeUnicode  = 0x001F
+	mapiTypeSysTime  = 0x0040
+	mapiTypeCLSID    = 0x0048
+	mapiTypeBinary   = 0x0102
+	mapiTypeMulti    = 0x1000
+
+	// MAPI property ID's
+	mapiSubject            = 0x0037
+	mapiMessageClass       = 0x001A
+	mapiBody               = 0x1000
+	mapiRTFCompressed      = 0x1009
+	mapiBodyHTML           = 0x1013
+	mapiInternetMessageID  = 0x1035
+	mapiDisplayName        = 0x3001
+	mapiAttachDataObj      = 0x3701
+	mapiAttachFilename     = 0x3704
+	mapiAttachLongFilename = 0x3707
+	mapiAttachMIMETag      = 0x370E
+	mapiAttachContentID    = 0x3712
+)
+
+// TNEF is the decoded content of a Transport Neutral Encapsulation Format
+// stream, as sent by Microsoft Outlook and Exchange in "application/ms-tnef"
+// parts (usually named "winmail.dat").
+type TNEF struct {
+	// MessageClass is the class of the encapsulated message, such as "IPM.Note".
+	MessageClass string
+
+	// Subject is the subject of the encapsulated message.
+	Subject string
+
+	// MessageID is the Message-ID of the encapsulated message, if any.
+	MessageID string
+
+	// DateSent is the time the encapsulated message was sent.
+	DateSent time.Time
+
+	// Body is the plain text body of the encapsulated message, if any.
+	Body []byte
+
+	// BodyHTML is the html body of the encapsulated message, if any.
+	BodyHTML []byte
+
+	// BodyRTF is the decompressed rich text body of the encapsulated message, if any.
+	BodyRTF []byte
+
+	// Attachments are the files attached to the encapsulated message.
+	Attachments []*TNEFAttachment
+
+	// Properties are all of the MAPI properties of the encapsulated message.
+	Properties []MAPIProperty
+}
+
+// TNEFAttachment is a file attached to a TNEF encapsulated message.
+type TNEFAttachment struct {
+	// Filename is the long filename of the attachment, if available,
+	// otherwise its short (8.3) filename or title.
+	Filename string
+
+	// ContentType is the MIME type of the attachment, from its MAPI
+	// properties if available, otherwise based on its filename.
+	ContentType string
+
+	// ContentID is the Content-ID of the attachment (without angle brackets),
+	// if it is referenced by the html body.
+	ContentID string
+
+	// Data is the content of the attachment.
+	Data []byte
+
+	// Properties are all of the MAPI properties of the attachment.
+	Properties []MAPIProperty
+}
+
+// MAPIProperty is a single MAPI property, such as PR_SUBJECT.
+type MAPIProperty struct {
+	// ID is the property ID, such as 0x0037 for PR_SUBJECT.
+	// Named properties (ID 0x8000 and above) also have a GUID and Name.
+	ID uint16
+
+	// Type is the property type, such as 0x001F for a unicode string.
+	Type uint16
+
+	// GUID is the property set of a named property.
+	GUID []byte
+
+	// Name is the name of a named property, if it is identified by a name
+	// rather than a numeric ID (which is then stored in NameID).
+	Name string
+
+	// NameID is the numeric ID of a named property identified by a number.
+	NameID uint32
+
+	// Values are the raw values of the property. Single-valued properties have one value.
+	// String values have been converted to UTF-8 and had their null-terminators removed.
+	Values [][]byte
+}
+
+// Value returns the first value of this property, or nil if it has none.
+func (p MAPIProperty) Value() []byte {
+	if len(p.Values) == 0 {
+		return nil
+	}
+	return p.Values[0]
+}
+
+// IsTNEF returns true if this Message has a content type of
+// "application/ms-tnef" or "application/vnd.ms-tnef".
+func (m *Message) IsTNEF() bool {
+	mediaType, _, err := m.Header.ContentType()
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/ms-tnef" || mediaType == "application/vnd.ms-tnef"
+}
+
+// TNEF decodes this Message's body as TNEF, or returns an error
+// if IsTNEF would return false or the body is not valid TNEF.
+func (m *Message) TNEF() (*TNEF, error) {
+	if !m.IsTNEF() {
+		return nil, errors.New("Message does not have media content of type application/ms-tnef")
+	}
+	return DecodeTNEF(m.Body)
+}
+
+// ReplaceTNEF replaces every TNEF part within this message (recursively)
+// with regular parts: an html part if the TNEF contains an html body,
+// followed by an attachment part for every TNEF attachment.
+// If this message itself is TNEF, it becomes a "multipart/mixed" message.
+// Parts that fail to decode are left in place, and the first error is returned.
+func (m *Message) ReplaceTNEF() error {
+	if m.IsTNEF() {
+		decoded, err := m.TNEF()
+		if err != nil {
+			return err
+		}
+		m.Header.Set("Content-Type", "multipart/mixed; boundary=\""+randomBoundary()+"\"")
+		m.Header.Del("Content-Disposition")
+		m.Body = nil
+		m.Parts = decoded.parts()
+		return nil
+	}
+
+	if m.HasSubMessage() && m.SubMessage != nil {
+		return m.SubMessage.ReplaceTNEF()
+	}
+
+	var firstErr error
+	if m.HasParts() {
+		parts := make([]*Message, 0, len(m.Parts))
+		for _, part := range m.Parts {
+			if !part.IsTNEF() {
+				if err := part.ReplaceTNEF(); err != nil && firstErr == nil {
+					firstErr = err
+				}
+				parts = append(parts, part)
+				continue
+			}
+			decoded, err := part.TNEF()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				parts = append(parts, part)
+				continue
+			}
+			parts = append(parts, decoded.parts()...)
+		}
+		m.Parts = parts
+	}
+	return firstErr
+}
+
+// parts returns the html body and attachments as regular parts.
+func (t *TNEF) parts() []*Message {
+	parts := make([]*Message, 0, 1+len(t.Attachments))
+	if len(t.BodyHTML) > 0 {
+		parts = append(parts, NewPartHTML(string(t.BodyHTML)))
+	}
+	for _, attachment := range t.Attachments {
+		disposition := "attachment"
+		if len(attachment.ContentID) > 0 {
+			disposition = "inline"
+		}
+		parts = append(parts, newPartFromBytes(attachment.Data, attachment.ContentType,
+			mime.FormatMediaType(disposition, map[string]string{"filename": attachment.Filename}), attachment.ContentID))
+	}
+	return parts
+}
+
+// DecodeTNEF decodes a Transport Neutral Encapsulation Format stream.
+func DecodeTNEF(data []byte) (*TNEF, error) {
+	if len(data) < 6 || binary.LittleEndian.Uint32(data) != tnefSignature {
+		return nil, errors.New("TNEF signature not found")
+	}
+	data = data[6:] // signature and legacy key
+
+	t := &TNEF{}
+	var attachment *TNEFAttachment
+	for len(data) > 0 {
+		if len(data) < 9 {
+			return nil, errors.New("TNEF attribute is truncated")
+		}
+		level := data[0]
+		attribute := binary.LittleEndian.Uint32(data[1:])
+		length := binary.LittleEndian.Uint32(data[5:])
+		if uint64(len(data)) < 9+uint64(length)+2 {
+			return nil, errors.New("TNEF attribute is truncated")
+		}
+		value := data[9 : 9+length]
+		data = data[9+length+2:] // skip the checksum
+
+		if level == tnefLevelAttachment {
+			if attribute == tnefAttrAttachRendData || attachment == nil {
+				attachment = &TNEFAttachment{}
+				t.Attachments = append(t.Attachments, attachment)
+			}
+			switch attribute {
+			case tnefAttrAttachTitle:
+				if len(attachment.Filename) == 0 {
+					attachment.Filename = string(trimNulls(value))
+				}
+			case tnefAttrAttachData:
+				attachment.Data = value
+			case tnefAttrAttachment:
+				properties, err := decodeMAPIProperties(value)
+				if err != nil {
+					return nil, err
+				}
+				attachment.Properties = properties
+				attachment.applyProperties()
+			}
+			continue
+		}
+
+		switch attribute {
+		case tnefAttrSubject:
+			t.Subject = string(trimNulls(value))
+		case tnefAttrMessageID:
+			t.MessageID = string(trimNulls(value))
+		case tnefAttrMessageClass:
+			t.MessageClass = string(trimNulls(value))
+		case tnefAttrBody:
+			t.Body = trimNulls(value)
+		case tnefAttrDateSent:
+			t.DateSent = decodeTNEFDate(value)
+		case tnefAttrMAPIProps:
+			properties, err := decodeMAPIProperties(value)
+			if err != nil {
+				return nil, err
+			}
+			t.Properties = properties
+			if err = t.applyProperties(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for _, attachment := range t.Attachments {
+		if len(attachment.ContentType) == 0 {
+			attachment.ContentType = mime.TypeByExtension(filepath.Ext(attachment.Filename))
+		}
+	}
+	return t, nil
+}
+
+// applyProperties fills in the message fields from the MAPI properties.
+func (t *TNEF) applyProperties() error {
+	for _, property := range t.Properties {
+		switch property.ID {
+		case mapiSubject:
+			t.Subject = string(property.Value())
+		case mapiMessageClass:
+			t.MessageClass = string(property.Value())
+		case mapiInternetMessageID:
+			t.MessageID = string(property.Value())
+		case mapiBody:
+			t.Body = property.Value()
+		case mapiBodyHTML:
+			t.BodyHTML = trimNulls(property.Value())
+		case mapiRTFCompressed:
+			rtf, err := DecompressRTF(property.Value())
+			if err != nil {
+				return err
+			}
+			t.BodyRTF = rtf
+		}
+	}
+	return nil
+}
+
+// applyProperties fills in the attachment fields from the MAPI properties.
+func (a *TNEFAttachment) applyProperties() {
+	var shortFilename, displayName string
+	for _, property := range a.Properties {
+		switch property.ID {
+		case mapiAttachLongFilename:
+			a.Filename = string(property.Value())
+		case mapiAttachFilename:
+			shortFilename = string(property.Value())
+		case mapiDisplayName:
+			displayName = string(property.Value())
+		case mapiAttachMIMETag:
+			a.ContentType = string(property.Value())
+		case mapiAttachContentID:
+			a.ContentID = trimAngleBrackets(string(property.Value()))
+		case mapiAttachDataObj:
+			value := property.Value()
+			if property.Type == mapiTypeObject && len(value) >= 16 {
+				value = value[16:] // skip the interface identifier
+			}
+			if len(a.Data) == 0 {
+				a.Data = value
+			}
+		}
+	}
+	if len(a.Filename) == 0 {
+		a.Filename = shortFilename
+	}
+	if len(a.Filename) == 0 {
+		a.Filename = displayName
+	}
+}
+
+// decodeMAPIProperties decodes a list of MAPI properties.
+func decodeMAPIProperties(data []byte) ([]MAPIProperty, error) {
+	r := &tnefReader{data: data}
+	count := r.uint32()
+	properties := make([]MAPIProperty, 0, min(int(count), 1024))
+	for i := uint32(0); i < count && r.err == nil; i++ {
+		property := MAPIProperty{Type: r.uint16(), ID: r.uint16()}
+		if property.ID >= 0x8000 {
+			property.GUID = r.bytes(16)
+			if kind := r.uint32(); kind == 0 {
+				property.NameID = r.uint32()
+			} else {
+				property.Name = decodeUTF16(r.padded(int(r.uint32())))
+			}
+		}
+
+		valueType := property.Type &^ mapiTypeMulti
+		valueCount := uint32(1)
+		if property.Type&mapiTypeMulti != 0 || isMAPIVariableType(valueType) {
+			valueCount = r.uint32()
+		}
+		for j := uint32(0); j < valueCount && r.err == nil; j++ {
+			var value []byte
+			switch valueType {
+			case mapiTypeShort, mapiTypeBoolean:
+				value = r.padded(2)
+			case mapiTypeLong, mapiTypeFloat, mapiTypeError:
+				value = r.bytes(4)
+			case mapiTypeDouble, mapiTypeCurrency, mapiTypeAppTime, mapiTypeInt64, mapiTypeSysTime:
+				value = r.bytes(8)
+			case mapiTypeCLSID:
+				value = r.bytes(16)
+			case mapiTypeString8:
+				value = trimNulls(r.padded(int(r.uint32())))
+			case mapiTypeUnicode:
+				value = []byte(decodeUTF16(r.padded(int(r.uint32()))))
+			case mapiTypeBinary, mapiTypeObject:
+				value = r.padded(int(r.uint32()))
+			default:
+				return properties, errors.New("TNEF MAPI property has an unknown type")
+			}
+			property.Values = append(property.Values, value)
+		}
+		properties = append(properties, property)
+	}
+	return properties, r.err
+}
+
+// isMAPIVariableType returns true for property types whose values are
+// preceded by a count, even when they are not multi-valued.
+func isMAPIVariableType(valueType uint16) bool {
+	return valueType == mapiTypeString8 || valueType == mapiTypeUnicode ||
+		valueType == mapiTypeBinary || valueType == mapiTypeObject
+}
+
+// tnefReader reads little-endian values from a byte slice,
+// remembering the first error encountered.
+type tnefReader struct {
+	data []byte
+	err  error
+}
+
+// bytes ...
+func (r *tnefReader) bytes(n int) []byte {
+	if r.err != nil || n < 0 || n > len(r.data) {
+		if r.err == nil {
+			r.err = errors.New("TNEF MAPI property is truncated")
+		}
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+// padded reads n bytes, then skips padding to a multiple of 4 bytes.
+func (r *tnefReader) padded(n int) []byte {
+	b := r.bytes(n)
+	if padding := (4 - n%4) % 4; padding > 0 && padding <= len(r.data) {
+		r.data = r.data[padding:]
+	}
+	return b
+}
+
+// uint16 ...
+func (r *tnefReader) uint16() uint16 {
+	b := r.bytes(2)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint16(b)
+}
+
+// uint32 ...
+func (r *tnefReader) uint32() uint32 {
+	b := r.bytes(4)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(b)
+}
+
+// decodeTNEFDate decodes a TNEF date attribute, which is seven
+// little-endian 16-bit values: year, month, day, hour, minute, second, weekday.
+func decodeTNEFDate(value []byte) time.Time {
+	if len(value) < 12 {
+		return time.Time{}
+	}
+	field := func(idx int) int { return int(binary.LittleEndian.Uint16(value[idx*2:])) }
+	return time.Date(field(0), time.Month(field(1)), field(2), field(3), field(4), field(5), 0, time.UTC)
+}
+
+// decodeUTF16 decodes little-endian UTF-16, removing any null-terminators.
+func decodeUTF16(b []byte) string {
+	u := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		u = append(u, binary.LittleEndian.Uint16(b[i:]))
+	}
+	return strings.TrimRight(string(utf16.Decode(u)), "\x00")
+}
+
+// trimNulls removes any trailing null-terminators.
+func trimNulls(b []byte) []byte {
+	return bytes.TrimRight(b, "\x00")
+}
+
+// compressed RTF constants, from MS-OXRTFCP
+const (
+	rtfCompressed   = 0x75465A4C // "LZFu"
+	rtfUncompressed = 0x414C454D // "MELA"
+	rtfPrebuf       = "{\\rtf1\\ansi\\mac\\deff0\\deftab720{\\fonttbl;}{\\f0\\fnil \\froman \\fswiss \\fmodern \\fscript \\fdecor MS Sans SerifSymbolArialTimes New RomanCourier{\\colortbl\\red0\\green0\\blue0\r\n\\par \\pard\\plain\\f0\\fs20\\b\\i\\u\\tab\\tx"
+)
+
+// DecompressRTF decompresses an RTF body stored in the compressed RTF
+// format (PR_RTF_COMPRESSED), as defined by MS-OXRTFCP.
+func DecompressRTF(data []byte) ([]byte, error) {
+	if len(data) < 16 {
+		return nil, errors.New("Compressed RTF header is truncated")
+	}
+	compressedSize := binary.LittleEndian.Uint32(data)
+	rawSize := binary.LittleEndian.Uint32(data[4:])
+	compressionType := binary.LittleEndian.Uint32(data[8:])
+	if compressedSize < 12 {
+		return nil, errors.New("Compressed RTF has an invalid size")
+	}
+	if uint64(compressedSize)+4 < uint64(len(data)) {
+		data = data[:compressedSize+4]
+	}
+	data = data[16:]
+
+	switch compressionType {
+	case rtfUncompressed:
+		return data[:min(len(data), int(rawSize))], nil
+	case rtfCompressed:
+	default:
+		return nil, errors.New("Compressed RTF has an unknown compression type")
+	}
+
+	var dictionary [4096]byte
+	copy(dictionary[:], rtfPrebuf)
+	writePosition := len(rtfPrebuf)
+	out := make([]byte, 0, min(int(rawSize), 16*len(data)))
+
+	for len(data) > 0 {
+		control := data[0]
+		data = data[1:]
+		for bit := uint(0); bit < 8 && len(data) > 0; bit++ {
+			if control&(1<<bit) == 0 {
+				// literal byte
+				dictionary[writePosition] = data[0]
+				writePosition = (writePosition + 1) % len(dictionary)
+				out = append(out, data[0])
+				data = data[1:]
+				continue
+			}
+			// dictionary reference: 12 bit offset, 4 bit length
+			if len(data) < 2 {
+				return nil, errors.New("Compressed RTF dictionary reference is truncated")
+			}
+			reference := int(data[0])<<8 | int(data[1])
+			data = data[2:]
+			offset := reference >> 4
+			length := reference&0x0F + 2
+			if offset == writePosition {
+				return out, nil // end of stream
+			}
+			for i := 0; i < length; i++ {
+				b := dictionary[(offset+i)%len(dictionary)]
+				dictionary[writePosition] = b
+				writePosition = (writePosition + 1) % len(dictionary)
+				out = append(out, b)
+			}
+		}
+	}
+	return out, nil
+}
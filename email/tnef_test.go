@@ -0,0 +1,94 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package email
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+	"unicode/utf16"
+)
+
+// TestDecompressRTF ...
+func TestDecompressRTF(t *testing.T) {
+	t.Parallel()
+
+	// Example from MS-OXRTFCP section 4.1
+	compressed := bytesOrPanic(hex.DecodeString("2d0000002b0000004c5a4675f1c5c7a703000a00726370673132" +
+		"3542320af32068656c090020627705b06c647d0a800fa0"))
+	rtf, err := DecompressRTF(compressed)
+	if err != nil {
+		t.Fatal("Could not decompress RTF:", err)
+	}
+	if string(rtf) != "{\\rtf1\\ansi\\ansicpg1252\\pard hello world}\r\n" {
+		t.Fatalf("Decompressed RTF does not match expected: %q", rtf)
+	}
+
+	// The compressed size includes the rest of the header, so cannot be less than 12
+	truncated := append([]byte{}, compressed...)
+	binary.LittleEndian.PutUint32(truncated, 11)
+	if _, err = DecompressRTF(truncated); err == nil {
+		t.Fatal("Expected an error for an invalid compressed size")
+	}
+}
+
+// TestTNEF ...
+func TestTNEF(t *testing.T) {
+	t.Parallel()
+
+	expectedData := []byte("foo,bar,\r\nbaz,quux,\r\n")
+
+	// Build a TNEF stream with a subject, and one attachment with a long filename
+	buffer := &bytes.Buffer{}
+	binary.Write(buffer, binary.LittleEndian, uint32(tnefSignature))
+	binary.Write(buffer, binary.LittleEndian, uint16(0x0001))
+	writeAttribute := func(level byte, attribute uint32, value []byte) {
+		buffer.WriteByte(level)
+		binary.Write(buffer, binary.LittleEndian, attribute)
+		binary.Write(buffer, binary.LittleEndian, uint32(len(value)))
+		buffer.Write(value)
+		binary.Write(buffer, binary.LittleEndian, uint16(0)) // checksum is not verified
+	}
+	writeAttribute(tnefLevelMessage, tnefAttrSubject, []byte("Quarterly Report\x00"))
+	writeAttribute(tnefLevelAttachment, tnefAttrAttachRendData, make([]byte, 14))
+	writeAttribute(tnefLevelAttachment, tnefAttrAttachTitle, []byte("REPORT~1.CSV\x00"))
+	writeAttribute(tnefLevelAttachment, tnefAttrAttachData, expectedData)
+
+	longFilename := utf16.Encode([]rune("Quarterly Report.csv\x00"))
+	properties := &bytes.Buffer{}
+	binary.Write(properties, binary.LittleEndian, uint32(1))
+	binary.Write(properties, binary.LittleEndian, uint16(mapiTypeUnicode))
+	binary.Write(properties, binary.LittleEndian, uint16(mapiAttachLongFilename))
+	binary.Write(properties, binary.LittleEndian, uint32(1))
+	binary.Write(properties, binary.LittleEndian, uint32(len(longFilename)*2))
+	binary.Write(properties, binary.LittleEndian, longFilename)
+	properties.Write(make([]byte, (4-(len(longFilename)*2)%4)%4))
+	writeAttribute(tnefLevelAttachment, tnefAttrAttachment, properties.Bytes())
+
+	part := newPartFromBytes(buffer.Bytes(), "application/ms-tnef", "attachment; filename=\"winmail.dat\"", "")
+	decoded, err := part.TNEF()
+	if err != nil {
+		t.Fatal("Could not decode TNEF:", err)
+	}
+	if decoded.Subject != "Quarterly Report" || len(decoded.Attachments) != 1 {
+		t.Fatal("TNEF does not match expected values:", decoded)
+	}
+	attachment := decoded.Attachments[0]
+	if attachment.Filename != "Quarterly Report.csv" || !bytes.Equal(attachment.Data, expectedData) || len(attachment.ContentType) == 0 {
+		t.Fatal("TNEF attachment does not match expected values:", attachment)
+	}
+
+	msg := NewMessage(NewHeader("from@host.com", "Subject", "to@host.com"), "text", "html", part)
+	if err = msg.ReplaceTNEF(); err != nil {
+		t.Fatal("Could not replace TNEF:", err)
+	}
+	if !confirmHasParts(msg, 2, false, false) || msg.Parts[1].IsTNEF() || !bytes.Equal(msg.Parts[1].Body, expectedData) {
+		t.Fatal("TNEF part was not replaced with its attachment")
+	}
+	if _, params, err := msg.Parts[1].Header.ContentDisposition(); err != nil || params["filename"] != "Quarterly Report.csv" {
+		t.Fatal("TNEF attachment part has the wrong filename")
+	}
+}
@@ -12,41 +12,23 @@ import (
 	"io"
 	"math"
 	"math/big"
-	"os"
 	"sort"
+	"strings"
 	"time"
 )
 
 var maxInt64 = big.NewInt(math.MaxInt64)
 
-// GenMessageID creates and returns a Message-ID, without surrounding angle brackets.
+// GenMessageID creates and returns a Message-ID, without surrounding angle brackets,
+// using the DefaultIDGenerator.
 func GenMessageID() (string, error) {
-	return generateID("")
+	return DefaultIDGenerator.MessageID()
 }
 
-// GenContentID creates and returns a Content-ID, without surrounding angle brackets.
+// GenContentID creates and returns a Content-ID, without surrounding angle brackets,
+// using the DefaultIDGenerator.
 func GenContentID(filename string) (string, error) {
-	return generateID(filename)
-}
-
-// generateID creates a globally unique identifier in the Message-ID format (subset of email address),
-// optionally having an additional string appended to the local part.
-// Example: 11223344556677889900.11.1234567890@localhost
-func generateID(appendWith string) (string, error) {
-	random, err := rand.Int(rand.Reader, maxInt64)
-	if err != nil {
-		return "", nil
-	}
-	hostname, err := os.Hostname()
-	if err != nil {
-		hostname = "localhost"
-	}
-	pid := os.Getpid()
-	nanoTime := time.Now().UTC().UnixNano()
-	if len(appendWith) == 0 {
-		return fmt.Sprintf("%d.%d.%d@%s", nanoTime, pid, random, hostname), nil
-	}
-	return fmt.Sprintf("%d.%d.%d.%s@%s", nanoTime, pid, random, appendWith, hostname), nil
+	return DefaultIDGenerator.ContentID(filename)
 }
 
 // randomBoundary returns a random hex string, approximately 61 characters long.
@@ -68,6 +50,14 @@ func max(x, y int) int {
 	return y
 }
 
+// min ...
+func min(x, y int) int {
+	if x < y {
+		return x
+	}
+	return y
+}
+
 // sortedHeaderFields ...
 func sortedHeaderFields(stringMap map[string][]string) []string {
 	keyCount := 0
@@ -80,6 +70,39 @@ func sortedHeaderFields(stringMap map[string][]string) []string {
 	return sortedKeys
 }
 
+// copyHeader returns a deep copy of a Header, which is never nil.
+func copyHeader(h Header) Header {
+	c := make(Header, len(h))
+	for field, values := range h {
+		c[field] = append([]string(nil), values...)
+	}
+	return c
+}
+
+// setIfNotEmpty sets the header field to value, if the value is not empty.
+func setIfNotEmpty(h Header, field string, value string) {
+	if len(value) > 0 {
+		h.Set(field, value)
+	}
+}
+
+// setAllIfNotEmpty replaces the header field with values, if there are any values.
+func setAllIfNotEmpty(h Header, field string, values []string) {
+	if len(values) > 0 {
+		h.Del(field)
+		for _, value := range values {
+			h.Add(field, value)
+		}
+	}
+}
+
+// setDateIfNotZero sets the header field to the formatted date, if the date is not zero.
+func setDateIfNotZero(h Header, field string, date time.Time) {
+	if !date.IsZero() {
+		h.Set(field, date.Format(time.RFC1123Z))
+	}
+}
+
 // bufioReader ...
 func bufioReader(r io.Reader) *bufio.Reader {
 	if bufferedReader, ok := r.(*bufio.Reader); ok {
@@ -195,3 +218,41 @@ func (r *leftTrimReader) Read(p []byte) (n3 int, err3 error) {
 func isASCIISpace(b byte) bool {
 	return b == ' ' || b == '\t' || b == '\n' || b == '\r'
 }
+
+// splitContent splits a message into the content to be signed or encrypted, with its Content-* header fields and payload,
+// and a new outer message with all of its other header fields.
+func splitContent(m *Message) (*Message, *Message) {
+	content := &Message{Header: Header{}, Preamble: m.Preamble, Epilogue: m.Epilogue, Parts: m.Parts,
+		SubMessage: m.SubMessage, Body: m.Body, TransferEncoding: m.TransferEncoding}
+	outer := &Message{Header: Header{}}
+	for field, values := range m.Header {
+		if strings.HasPrefix(field, "Content-") {
+			content.Header[field] = values
+		} else {
+			outer.Header[field] = values
+		}
+	}
+	if !content.Header.IsSet("Content-Type") {
+		content.Header.Set("Content-Type", "text/plain; charset=\"UTF-8\"")
+	}
+	return content, outer
+}
+
+// restoreHeader copies the header fields of an outer signed or encrypted message that are not Content-* fields,
+// and are not in the decrypted or verified content, to the content, undoing splitContent.
+func restoreHeader(content *Message, outer *Message) {
+	for field, values := range outer.Header {
+		if !strings.HasPrefix(field, "Content-") && !content.Header.IsSet(field) {
+			content.Header[field] = append([]string(nil), values...)
+		}
+	}
+}
+
+// signedBytes returns the exact bytes of the signed part of a multipart/signed message,
+// which are its Raw bytes if set, and otherwise the part written out again.
+func signedBytes(part *Message) ([]byte, error) {
+	if part.Raw != nil {
+		return part.Raw, nil
+	}
+	return part.BytesWithPolicy(Encoding7Bit)
+}
@@ -0,0 +1,245 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package email
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"hash/crc32"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// uudecodeReader decodes a uuencoded body, skipping the "begin" line
+// and stopping at the "end" line.
+type uudecodeReader struct {
+	r       *bufio.Reader
+	decoded []byte
+	done    bool
+}
+
+// newUUDecodeReader ...
+func newUUDecodeReader(r io.Reader) *uudecodeReader {
+	return &uudecodeReader{r: bufioReader(r)}
+}
+
+// Read ...
+func (r *uudecodeReader) Read(p []byte) (int, error) {
+	for len(r.decoded) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		line, err := r.r.ReadBytes('\n')
+		trimmed := bytes.TrimRight(line, "\r\n")
+		switch {
+		case bytes.HasPrefix(trimmed, []byte("begin ")):
+			// skip the header line
+		case bytes.Equal(trimmed, []byte("end")):
+			r.done = true
+		case len(trimmed) > 0:
+			decoded, decodeErr := uudecodeLine(trimmed)
+			if decodeErr != nil {
+				return 0, decodeErr
+			}
+			r.decoded = decoded
+		}
+		if err == io.EOF {
+			r.done = true
+		} else if err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.decoded)
+	r.decoded = r.decoded[n:]
+	return n, nil
+}
+
+// uudecodeLine decodes a single line of uuencoded data,
+// whose first character is the number of decoded bytes.
+func uudecodeLine(line []byte) ([]byte, error) {
+	length := int(uudecodeChar(line[0]))
+	if length == 0 {
+		return nil, nil
+	}
+	line = line[1:]
+	decoded := make([]byte, 0, length+2)
+	for i := 0; len(decoded) < length; i += 4 {
+		if i >= len(line) {
+			return nil, errors.New("Uuencoded line is shorter than its length")
+		}
+		var group [4]byte
+		for j := 0; j < 4; j++ {
+			if i+j < len(line) {
+				group[j] = uudecodeChar(line[i+j])
+			}
+		}
+		decoded = append(decoded,
+			group[0]<<2|group[1]>>4,
+			group[1]<<4|group[2]>>2,
+			group[2]<<6|group[3])
+	}
+	return decoded[:length], nil
+}
+
+// uudecodeChar ...
+func uudecodeChar(c byte) byte {
+	return (c - ' ') & 0x3F
+}
+
+// ExtractInlineAttachments finds any uuencoded ("begin 644 filename")
+// or yEnc ("=ybegin") blocks embedded in this message's text body,
+// and returns the remaining text along with a synthetic attachment part
+// (created with NewPartAttachmentFromBytes) for each decoded block.
+// The message itself is not modified.
+// The text is returned unchanged if the message does not have a text body.
+func (m *Message) ExtractInlineAttachments() ([]byte, []*Message, error) {
+	mediaType, _, err := m.Header.ContentType()
+	if !m.HasBody() || (err == nil && !strings.HasPrefix(mediaType, "text")) {
+		return m.Body, nil, nil
+	}
+
+	var attachments []*Message
+	text := &bytes.Buffer{}
+	scanner := bufio.NewReader(bytes.NewReader(m.Body))
+	for {
+		line, err := scanner.ReadBytes('\n')
+		trimmed := bytes.TrimRight(line, "\r\n")
+
+		var attachment *Message
+		var blockErr error
+		if filename, ok := uuencodeBeginFilename(trimmed); ok {
+			attachment, blockErr = readUUEncodedBlock(scanner, filename)
+		} else if bytes.HasPrefix(trimmed, []byte("=ybegin ")) {
+			attachment, blockErr = readYEncBlock(scanner, string(trimmed))
+		} else {
+			text.Write(line)
+		}
+		if blockErr != nil {
+			return nil, nil, blockErr
+		}
+		if attachment != nil {
+			attachments = append(attachments, attachment)
+		}
+
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return text.Bytes(), attachments, nil
+}
+
+// uuencodeBeginFilename returns the filename from a "begin 644 filename" line.
+func uuencodeBeginFilename(line []byte) (string, bool) {
+	fields := strings.SplitN(string(line), " ", 3)
+	if len(fields) != 3 || fields[0] != "begin" || len(fields[1]) < 3 || len(fields[1]) > 4 {
+		return "", false
+	}
+	if _, err := strconv.ParseUint(fields[1], 8, 16); err != nil {
+		return "", false
+	}
+	return fields[2], true
+}
+
+// readUUEncodedBlock decodes the lines following a "begin" line, up to the "end" line.
+func readUUEncodedBlock(r *bufio.Reader, filename string) (*Message, error) {
+	decoded := &bytes.Buffer{}
+	for {
+		line, err := r.ReadBytes('\n')
+		line = bytes.TrimRight(line, "\r\n")
+		if bytes.Equal(line, []byte("end")) {
+			break
+		}
+		if len(line) > 0 {
+			b, decodeErr := uudecodeLine(line)
+			if decodeErr != nil {
+				return nil, decodeErr
+			}
+			decoded.Write(b)
+		}
+		if err == io.EOF {
+			return nil, errors.New("Uuencoded block for " + filename + " is missing its end line")
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return NewPartAttachmentFromBytes(decoded.Bytes(), filename), nil
+}
+
+// readYEncBlock decodes the lines following a "=ybegin" line, up to the "=yend" line,
+// verifying the size and CRC32 checksum if they are present.
+func readYEncBlock(r *bufio.Reader, begin string) (*Message, error) {
+	beginParams := yencParams(begin)
+	filename := beginParams["name"]
+	decoded := &bytes.Buffer{}
+	var endParams map[string]string
+	for endParams == nil {
+		line, err := r.ReadBytes('\n')
+		line = bytes.TrimRight(line, "\r\n")
+		switch {
+		case bytes.HasPrefix(line, []byte("=ypart ")):
+			// the part's position within a multi-part file is not needed
+		case bytes.HasPrefix(line, []byte("=yend")):
+			endParams = yencParams(string(line))
+		default:
+			yencDecodeLine(decoded, line)
+		}
+		if endParams == nil && err == io.EOF {
+			return nil, errors.New("yEnc block for " + filename + " is missing its =yend line")
+		}
+		if endParams == nil && err != nil {
+			return nil, err
+		}
+	}
+
+	if size, ok := endParams["size"]; ok && size != strconv.Itoa(decoded.Len()) {
+		return nil, errors.New("yEnc block for " + filename + " does not match its size")
+	}
+	checksum := endParams["pcrc32"]
+	if _, isPart := beginParams["part"]; !isPart && len(endParams["crc32"]) > 0 {
+		checksum = endParams["crc32"]
+	}
+	if len(checksum) > 0 {
+		expected, err := strconv.ParseUint(checksum, 16, 32)
+		if err != nil || uint32(expected) != crc32.ChecksumIEEE(decoded.Bytes()) {
+			return nil, errors.New("yEnc block for " + filename + " does not match its checksum")
+		}
+	}
+	return NewPartAttachmentFromBytes(decoded.Bytes(), filename), nil
+}
+
+// yencDecodeLine decodes a single line of yEnc data: each byte is offset
+// by 42, and critical bytes are escaped with "=" and offset by a further 64.
+func yencDecodeLine(w *bytes.Buffer, line []byte) {
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		if c == '=' && i+1 < len(line) {
+			i++
+			c = line[i] - 64
+		}
+		w.WriteByte(c - 42)
+	}
+}
+
+// yencParams parses the key=value parameters of a yEnc header or trailer line.
+// The name parameter is always last, and may contain spaces.
+func yencParams(line string) map[string]string {
+	params := map[string]string{}
+	if idx := strings.Index(line, " name="); idx >= 0 {
+		params["name"] = strings.TrimSpace(line[idx+len(" name="):])
+		line = line[:idx]
+	}
+	for _, field := range strings.Fields(line)[1:] {
+		if key, value, ok := strings.Cut(field, "="); ok {
+			params[key] = value
+		}
+	}
+	return params
+}
@@ -0,0 +1,76 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package email
+
+import (
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"strings"
+	"testing"
+)
+
+// TestUUEncodeTransferEncoding ...
+func TestUUEncodeTransferEncoding(t *testing.T) {
+	t.Parallel()
+
+	raw := "From: test.from@host.com\r\n" +
+		"Content-Type: application/octet-stream\r\n" +
+		"Content-Transfer-Encoding: x-uuencode\r\n" +
+		"\r\n" +
+		"begin 644 cat.txt\r\n" +
+		"#0V%T\r\n" +
+		"`\r\n" +
+		"end\r\n"
+
+	msg, err := ParseMessage(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Could not parse message:", err)
+	}
+	if string(msg.Body) != "Cat" || msg.Header.IsSet("Content-Transfer-Encoding") {
+		t.Fatalf("Uuencoded body was not decoded: %q", msg.Body)
+	}
+}
+
+// TestExtractInlineAttachments ...
+func TestExtractInlineAttachments(t *testing.T) {
+	t.Parallel()
+
+	expectedYEnc := []byte("yEnc \x00\x0a\x0d= binary \xd6\xe0\xe3")
+	yenc := &bytes.Buffer{}
+	for _, b := range expectedYEnc {
+		switch c := b + 42; c {
+		case 0x00, 0x0A, 0x0D, '=':
+			yenc.WriteByte('=')
+			yenc.WriteByte(c + 64)
+		default:
+			yenc.WriteByte(c)
+		}
+	}
+
+	body := "Here are the files:\r\n" +
+		"begin 644 cat.txt\r\n" +
+		"#0V%T\r\n" +
+		"`\r\n" +
+		"end\r\n" +
+		fmt.Sprintf("=ybegin line=128 size=%d name=my binary.dat\r\n", len(expectedYEnc)) +
+		yenc.String() + "\r\n" +
+		fmt.Sprintf("=yend size=%d crc32=%08x\r\n", len(expectedYEnc), crc32.ChecksumIEEE(expectedYEnc)) +
+		"Thanks!\r\n"
+
+	text, attachments, err := NewPartText(body).ExtractInlineAttachments()
+	if err != nil {
+		t.Fatal("Could not extract inline attachments:", err)
+	}
+	if string(text) != "Here are the files:\r\nThanks!\r\n" || len(attachments) != 2 {
+		t.Fatalf("Inline attachments were not extracted: %q", text)
+	}
+	if string(attachments[0].Body) != "Cat" || !bytes.Equal(attachments[1].Body, expectedYEnc) {
+		t.Fatal("Inline attachments do not match expected content")
+	}
+	if _, params, err := attachments[1].Header.ContentDisposition(); err != nil || params["filename"] != "my binary.dat" {
+		t.Fatal("yEnc attachment has the wrong filename")
+	}
+}